@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"9fans.net/go/acme"
+	"github.com/jcowgar/acme-utils/internal/buffer"
+	"github.com/jcowgar/acme-utils/internal/config"
+	"github.com/jcowgar/acme-utils/internal/conversation"
+	"github.com/jcowgar/acme-utils/internal/mcp"
+	"github.com/jcowgar/acme-utils/internal/openfiles"
+)
+
+// acmeChat is the chat of a project shown in an Acme window. The window is
+// named after the chat file, so Get and Put work as for any other file, and
+// is saved after every response.
+type acmeChat struct {
+	win        *acme.Win
+	cfg        *config.Config
+	windows    *buffer.AcmeSource
+	projectDir string
+	chatFname  string
+
+	mu      sync.Mutex
+	sending bool
+}
+
+// actionAcme opens the chat of the current project in a new Acme window and
+// serves the Send, New and Model commands of its tag until it is closed.
+func actionAcme(_ []string) {
+	projectDir, err := findProjectDirectory()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error finding project directory: %v\n", err)
+		os.Exit(1)
+	}
+
+	chatFname := filepath.Join(projectDir, ".ai-stdio.md")
+
+	// The configuration is layered as for send, the chat's front matter
+	// included when there is a chat already
+	content, err := os.ReadFile(chatFname)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "Error reading chat filename: %v\n", err)
+		os.Exit(1)
+	}
+
+	cfg, err := loadChatConfig(string(content))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	windows := buffer.NewAcmeSource()
+	if cfg.Buffers.Acme != "off" {
+		buffer.Register(windows)
+	}
+	for _, command := range cfg.Buffers.Commands {
+		buffer.Register(buffer.CommandSource{Command: command})
+	}
+
+	win, err := acme.New()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening acme window: %v\n", err)
+		os.Exit(1)
+	}
+
+	chat := &acmeChat{
+		win:        win,
+		cfg:        cfg,
+		windows:    windows,
+		projectDir: projectDir,
+		chatFname:  chatFname,
+	}
+
+	if err := chat.open(); err != nil {
+		win.Errf("could not open chat: %v", err)
+	}
+
+	win.EventLoop(chat)
+
+	// MCP servers are kept running between sends until the window closes
+	mcp.CloseShared()
+}
+
+// open names the window, fills it with the chat file, creating a new chat
+// when there is none, and adds the commands to the tag.
+func (c *acmeChat) open() error {
+	c.win.Name("%s", c.chatFname)
+	c.win.Fprintf("tag", " Send New Model ")
+
+	content, err := os.ReadFile(c.chatFname)
+	if os.IsNotExist(err) {
+		content = []byte(newChatContent(c.projectDir, "", "", nil, findPrompt()))
+	} else if err != nil {
+		return err
+	}
+
+	c.win.Clear()
+	c.win.Write("body", content)
+
+	return c.save()
+}
+
+// save writes the window body to the chat file and marks the window clean.
+func (c *acmeChat) save() error {
+	body, err := c.win.ReadAll("body")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(c.chatFname, body, 0644); err != nil {
+		return err
+	}
+
+	return c.win.Ctl("clean")
+}
+
+// ExecSend sends the chat to the model, streaming the response into the
+// window. The event loop keeps running while the response arrives.
+func (c *acmeChat) ExecSend() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.sending {
+		return fmt.Errorf("already sending")
+	}
+	c.sending = true
+
+	go func() {
+		if err := c.send(); err != nil {
+			c.win.Errf("%v", err)
+		}
+
+		c.mu.Lock()
+		c.sending = false
+		c.mu.Unlock()
+	}()
+
+	return nil
+}
+
+func (c *acmeChat) send() error {
+	body, err := c.win.ReadAll("body")
+	if err != nil {
+		return fmt.Errorf("could not read chat: %w", err)
+	}
+
+	cfg, err := loadChatConfig(string(body))
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	openFiles := openfiles.AcmeSource{Windows: c.windows}
+
+	conv, err := prepareConversation(cfg, string(body), c.chatFname, openFiles)
+	if err != nil {
+		return err
+	}
+	if conv == nil {
+		// There is no new conversation data, ignore this request
+		return nil
+	}
+
+	if err := approveProposals(cfg, conv, acmeBodyWriter{c.win}); err != nil {
+		return fmt.Errorf("failed to approve proposals: %w", err)
+	}
+
+	if err := redactConversation(cfg, conv); err != nil {
+		return fmt.Errorf("failed to redact conversation: %w", err)
+	}
+
+	provider, err := newConversationProvider(cfg, conv)
+	if err != nil {
+		return fmt.Errorf("failed to create provider: %w", err)
+	}
+
+	if err := sendConversation(cfg, provider, conv, acmeBodyWriter{c.win}); err != nil {
+		return fmt.Errorf("error processing LLM request: %w", err)
+	}
+
+	return c.save()
+}
+
+// idle refuses to change the body while a response is being streamed into
+// it, which would otherwise be interleaved with the new body.
+func (c *acmeChat) idle() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.sending {
+		return fmt.Errorf("a response is still arriving, wait for it before changing the chat")
+	}
+
+	return nil
+}
+
+// ExecNew replaces the chat with a new one, using the model given as the
+// argument if any. A template:name argument starts the chat from the prompt
+// template instead of .prompt, rendered with any key=value arguments. It is
+// refused while a response is arriving.
+func (c *acmeChat) ExecNew(arg string) error {
+	if err := c.idle(); err != nil {
+		return err
+	}
+
+	templateName := ""
+	args := make([]string, 0)
+	for _, field := range strings.Fields(arg) {
+		if name, ok := strings.CutPrefix(field, "template:"); ok {
+			templateName = name
+		} else {
+			args = append(args, field)
+		}
+	}
+
+	modelName, params, err := parseNewArgs(args)
+	if err != nil {
+		return err
+	}
+
+	prompt, err := chatPrompt(c.projectDir, modelName, templateName, params)
+	if err != nil {
+		return err
+	}
+
+	c.win.Clear()
+	c.win.Write("body", []byte(newChatContent(c.projectDir, modelName, templateName, params, prompt)))
+
+	return c.save()
+}
+
+// ExecModel sets the model of the chat, which is refused while a response
+// is arriving. Without an argument it lists the configured models instead.
+func (c *acmeChat) ExecModel(modelName string) error {
+	if modelName == "" {
+		c.win.Err(strings.Join(configuredModels(&c.cfg.LLM), "\n"))
+		return nil
+	}
+
+	if err := c.idle(); err != nil {
+		return err
+	}
+
+	modelName, _, err := c.cfg.LLM.ResolveProvider(modelName)
+	if err != nil {
+		return err
+	}
+
+	body, err := c.win.ReadAll("body")
+	if err != nil {
+		return err
+	}
+
+	c.win.Clear()
+	c.win.Write("body", []byte(conversation.SetFrontMatter(string(body), "model", modelName)))
+
+	return c.save()
+}
+
+func (c *acmeChat) Execute(cmd string) bool {
+	return false
+}
+
+func (c *acmeChat) Look(arg string) bool {
+	return false
+}
+
+// acmeBodyWriter appends everything written to the body of an Acme window.
+type acmeBodyWriter struct {
+	win *acme.Win
+}
+
+func (w acmeBodyWriter) Write(p []byte) (int, error) {
+	return w.win.Write("body", p)
+}
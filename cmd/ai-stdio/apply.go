@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jcowgar/acme-utils/internal/agent"
+	"github.com/jcowgar/acme-utils/internal/config"
+	"github.com/jcowgar/acme-utils/internal/conversation"
+	"github.com/jcowgar/acme-utils/internal/mcp"
+	"github.com/jcowgar/acme-utils/internal/patch"
+	"github.com/jcowgar/acme-utils/internal/snapshot"
+)
+
+// backupDirectory returns the directory a run approving changes keeps the
+// previous content of the files it changes in. It is in the state
+// directory, as the snapshots are, so the backups are not project files the
+// agent and +glob would see, in a directory per project and run.
+func backupDirectory(projectDir string) (string, error) {
+	stateDir, err := config.StateDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find state directory: %w", err)
+	}
+
+	project := filepath.Base(projectDir) + "-" + snapshot.Digest(projectDir)[:12]
+
+	return filepath.Join(stateDir, "backups", project, time.Now().Format("20060102-150405")), nil
+}
+
+// proposalDirectory returns the directory the write tools record their
+// proposals in, which only a proposal recorded there is approved from.
+func proposalDirectory() (string, error) {
+	stateDir, err := config.StateDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find state directory: %w", err)
+	}
+
+	return filepath.Join(stateDir, "proposals"), nil
+}
+
+// approvalToolbox returns a toolbox for dir applying approved proposals,
+// keeping the previous content of the files it changes.
+func approvalToolbox(dir string) (*agent.Toolbox, error) {
+	tools, err := agent.NewToolbox(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	tools.BackupDir, err = backupDirectory(tools.Dir)
+	if err != nil {
+		return nil, err
+	}
+	tools.ProposalDir, err = proposalDirectory()
+	if err != nil {
+		return nil, err
+	}
+
+	return tools, nil
+}
+
+// actionApply applies changes from the chat to the project: by default the
+// code blocks of a response, the last one unless --response numbers another.
+// With --approve it applies the changes the write tools proposed in the last
+// turn instead, as sending +approve does, and appends what was done to the
+// chat as the start of the next user message.
+func actionApply(args []string) {
+	flags := flag.NewFlagSet("apply", flag.ExitOnError)
+	response := flags.String("response", "last", "Apply the code blocks of response `N`, counting from 1, or of the last response")
+	isApprove := flags.Bool("approve", false, "Apply the changes proposed by the write tools, which need agent.write")
+	isDryRun := flags.Bool("dry-run", false, "Only show the changes, do not make them")
+	flags.Parse(args)
+
+	projectDir, chatFname, content, conv := readChat()
+
+	dir := conv.ProjectDirectory
+	if dir == "" {
+		dir = projectDir
+	}
+
+	tools, err := approvalToolbox(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	proposals := agent.Pending(conv.Messages)
+
+	if *isApprove {
+		if len(proposals) == 0 {
+			fmt.Fprintf(os.Stderr, "nothing awaits approval\n")
+			return
+		}
+
+		if !*isDryRun {
+			cfg, err := loadChatConfig(content)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to load configuration: %v\n", err)
+				os.Exit(1)
+			}
+			if !cfg.Agent.Write {
+				fmt.Fprintf(os.Stderr, "%v\n", errWriteDisabled)
+				os.Exit(1)
+			}
+			addProposedTools(cfg, tools, proposals)
+			defer mcp.CloseShared()
+		}
+
+		applyProposals(tools, chatFname, content, conv, proposals, *isDryRun)
+		return
+	}
+
+	if len(proposals) > 0 {
+		fmt.Fprintf(os.Stderr, "%d proposed changes await approval, review them with ai-stdio apply --approve --dry-run and apply them with ai-stdio apply --approve\n", len(proposals))
+	}
+
+	msg, err := selectResponse(conv, *response)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	applyCodeBlocks(tools, msg.CodeBlocks(), *isDryRun)
+}
+
+// readChat reads and parses the chat of the project, exiting on failure.
+func readChat() (string, string, string, *conversation.Conversation) {
+	projectDir, err := findProjectDirectory()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error finding project directory: %v\n", err)
+		os.Exit(1)
+	}
+
+	chatFname := filepath.Join(projectDir, ".ai-stdio.md")
+	content, err := os.ReadFile(chatFname)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading chat filename: %v\n", err)
+		os.Exit(1)
+	}
+
+	conv, err := conversation.ParseContent(string(content))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not parse conversation content: %v\n", err)
+		os.Exit(1)
+	}
+
+	conv.ProjectDirectory, err = chatProjectDirectory(projectDir, conv.ProjectDirectory)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	return projectDir, chatFname, string(content), conv
+}
+
+// selectResponse returns the response numbered by spec, counting from 1, or
+// the last response for "last".
+func selectResponse(conv *conversation.Conversation, spec string) (*conversation.Message, error) {
+	responses := make([]*conversation.Message, 0)
+	for i := range conv.Messages {
+		if conv.Messages[i].Role == "Response" {
+			responses = append(responses, &conv.Messages[i])
+		}
+	}
+
+	if len(responses) == 0 {
+		return nil, fmt.Errorf("the chat has no response")
+	}
+
+	if spec == "last" {
+		return responses[len(responses)-1], nil
+	}
+
+	n, err := strconv.Atoi(spec)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid response %q, want a number from 1 or last", spec)
+	}
+	if n > len(responses) {
+		return nil, fmt.Errorf("response %d does not exist, the chat has %d", n, len(responses))
+	}
+
+	return responses[n-1], nil
+}
+
+// applyProposals applies the proposals awaiting approval and appends the
+// report of what was done to the chat.
+func applyProposals(tools *agent.Toolbox, chatFname string, content string, conv *conversation.Conversation, proposals []agent.Proposal, isDryRun bool) {
+	if isDryRun {
+		for _, proposal := range proposals {
+			fmt.Println(proposal.String())
+		}
+		return
+	}
+
+	report := tools.Approve(context.Background(), proposals)
+	fmt.Print(report)
+
+	// The report opens the next user message, unless the chat already ends
+	// within one
+	addition := "\n" + report
+	if !strings.HasSuffix(strings.TrimRight(content, "\n"), "## You") && conv.Messages[len(conv.Messages)-1].Role != "You" {
+		addition = "\n## You\n\n" + report
+	}
+
+	f, err := os.OpenFile(chatFname, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not open chat: %v\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(addition); err != nil {
+		fmt.Fprintf(os.Stderr, "could not write chat: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// applyCodeBlocks writes the code blocks annotated with a file, and applies
+// the diffs, after showing the diff of every change. Nothing is written
+// unless every block applies.
+func applyCodeBlocks(tools *agent.Toolbox, blocks []conversation.CodeBlock, isDryRun bool) {
+	diff, err := codeBlocksDiff(tools, blocks)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	if diff == "" {
+		fmt.Fprintf(os.Stderr, "nothing to apply\n")
+		return
+	}
+
+	fmt.Print(diff)
+	if isDryRun {
+		return
+	}
+
+	result, err := tools.Apply(context.Background(), agent.Proposal{Diff: diff})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s\n", result)
+	if _, err := os.Stat(tools.BackupDir); err == nil {
+		fmt.Fprintf(os.Stderr, "Previous content kept in %s\n", tools.BackupDir)
+	}
+}
+
+// codeBlocksDiff returns the diff of the changes the code blocks make,
+// skipping blocks that neither name a file nor are a diff. Two blocks may
+// not change the same file, as each is made against the project as it is.
+func codeBlocksDiff(tools *agent.Toolbox, blocks []conversation.CodeBlock) (string, error) {
+	var diff strings.Builder
+	changedBy := make(map[string]int)
+
+	for i, block := range blocks {
+		var blockDiff string
+		var err error
+
+		switch {
+		case block.IsDiff():
+			blockDiff, err = tools.NormalizeDiff(block.Content)
+		case block.Path != "":
+			blockDiff, err = tools.FileDiff(block.Path, block.Content)
+		default:
+			fmt.Fprintf(os.Stderr, "skipping code block %d, it names no file\n", i+1)
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("code block %d: %w", i+1, err)
+		}
+
+		files, err := patch.Parse(blockDiff)
+		if err != nil {
+			return "", fmt.Errorf("code block %d: %w", i+1, err)
+		}
+		for _, file := range files {
+			if previous, ok := changedBy[file.Path()]; ok {
+				return "", fmt.Errorf("code blocks %d and %d both change %s", previous, i+1, file.Path())
+			}
+			changedBy[file.Path()] = i + 1
+		}
+
+		diff.WriteString(blockDiff)
+	}
+
+	return diff.String(), nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jcowgar/acme-utils/internal/agent"
+	"github.com/jcowgar/acme-utils/internal/conversation"
+)
+
+func TestBackupDirectory(t *testing.T) {
+	stateHome := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", stateHome)
+
+	first, err := backupDirectory("/home/me/src/app")
+	if err != nil {
+		t.Fatalf("backupDirectory() error = %v", err)
+	}
+	if !strings.HasPrefix(first, filepath.Join(stateHome, "ai-stdio", "backups", "app-")) {
+		t.Errorf("backupDirectory() = %s, want it in the state directory", first)
+	}
+
+	// Projects of the same name are kept apart
+	other, err := backupDirectory("/home/me/work/app")
+	if err != nil {
+		t.Fatalf("backupDirectory() error = %v", err)
+	}
+	if filepath.Dir(other) == filepath.Dir(first) {
+		t.Errorf("backupDirectory() = %s for both projects, want them apart", other)
+	}
+}
+
+func TestSelectResponse(t *testing.T) {
+	conv := &conversation.Conversation{Messages: []conversation.Message{
+		{Role: "You", Content: "one"},
+		{Role: "Response", Content: "first"},
+		{Role: "You", Content: "two"},
+		{Role: "Response", Content: "second"},
+	}}
+
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr string
+	}{
+		{spec: "last", want: "second"},
+		{spec: "1", want: "first"},
+		{spec: "2", want: "second"},
+		{spec: "3", wantErr: "response 3 does not exist, the chat has 2"},
+		{spec: "0", wantErr: "invalid response"},
+		{spec: "first", wantErr: "invalid response"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := selectResponse(conv, tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("selectResponse(%q) = %v, %v, want an error containing %q", tt.spec, got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got.Content != tt.want {
+				t.Errorf("selectResponse(%q) = %v, %v, want %q", tt.spec, got, err, tt.want)
+			}
+		})
+	}
+
+	if _, err := selectResponse(&conversation.Conversation{Messages: conv.Messages[:1]}, "last"); err == nil {
+		t.Errorf("selectResponse() of a chat without responses succeeded, want an error")
+	}
+}
+
+func TestCodeBlocksDiff(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("one\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tools, err := agent.NewToolbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patchA := "--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n-one\n+three\n"
+
+	tests := []struct {
+		name    string
+		blocks  []conversation.CodeBlock
+		want    []string
+		wantErr string
+	}{
+		{name: "file", blocks: []conversation.CodeBlock{{Language: "txt", Path: "a.txt", Content: "two\n"}}, want: []string{"+++ b/a.txt", "-one", "+two"}},
+		{name: "new file", blocks: []conversation.CodeBlock{{Path: "sub/b.txt", Content: "new\n"}}, want: []string{"--- /dev/null", "+++ b/sub/b.txt", "+new"}},
+		{name: "diff", blocks: []conversation.CodeBlock{{Language: "diff", Content: patchA}}, want: []string{"+++ b/a.txt", "+three"}},
+		{name: "unnamed block", blocks: []conversation.CodeBlock{{Language: "go", Content: "x()\n"}}},
+		{name: "same file twice", blocks: []conversation.CodeBlock{{Path: "a.txt", Content: "two\n"}, {Language: "diff", Content: patchA}}, wantErr: "code blocks 1 and 2 both change a.txt"},
+		{name: "outside the project", blocks: []conversation.CodeBlock{{Path: "../a.txt", Content: "two\n"}}, wantErr: "code block 1"},
+		{name: "stale diff", blocks: []conversation.CodeBlock{{Language: "diff", Content: "--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n-zero\n+three\n"}}, wantErr: "code block 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := codeBlocksDiff(tools, tt.blocks)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("codeBlocksDiff() = %q, %v, want an error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("codeBlocksDiff() error = %v", err)
+			}
+
+			if len(tt.want) == 0 && got != "" {
+				t.Errorf("codeBlocksDiff() = %q, want no diff", got)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("codeBlocksDiff() = %q, want it to contain %q", got, want)
+				}
+			}
+		})
+	}
+}
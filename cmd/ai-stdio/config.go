@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jcowgar/acme-utils/internal/config"
+	"github.com/jcowgar/acme-utils/internal/conversation"
+)
+
+// actionConfig runs the config subcommands.
+func actionConfig(args []string) {
+	if len(args) == 0 {
+		args = []string{""}
+	}
+
+	switch args[0] {
+	case "show":
+		configShow(args[1:])
+	case "init":
+		configInit(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "usage: ai-stdio config show [--resolved] | config init [--force]\n")
+		os.Exit(1)
+	}
+}
+
+// configInit writes a commented starter global configuration, tailored to
+// a running local Ollama and the API keys in the environment.
+func configInit(args []string) {
+	flags := flag.NewFlagSet("config init", flag.ExitOnError)
+	isForce := flags.Bool("force", false, "Overwrite an existing configuration file")
+	flags.Parse(args)
+
+	filename, err := config.GlobalConfigFile()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not get configuration file path: %v\n", err)
+		os.Exit(1)
+	}
+
+	if _, err := os.Stat(filename); err == nil && !*isForce {
+		fmt.Fprintf(os.Stderr, "%s already exists, use --force to overwrite it\n", filename)
+		os.Exit(1)
+	}
+
+	env := config.DetectEnvironment(context.Background())
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "could not create configuration directory: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := os.WriteFile(filename, []byte(config.Starter(env)), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "could not write configuration file: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("wrote %s\n", filename)
+	if env.OllamaURL != "" {
+		fmt.Printf("found Ollama at %s\n", env.OllamaURL)
+	}
+	if env.OpenAIKey {
+		fmt.Println("found OPENAI_API_KEY")
+	}
+	if env.OpenRouterKey {
+		fmt.Println("found OPENROUTER_API_KEY")
+	}
+}
+
+// configShow prints the configuration layers of the current project and
+// chat, or with --resolved every effective value and the layer it came from.
+func configShow(args []string) {
+	flags := flag.NewFlagSet("config show", flag.ExitOnError)
+	isResolved := flags.Bool("resolved", false, "Print the effective configuration with the source of each value")
+	flags.Parse(args)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not get current working directory: %v\n", err)
+		os.Exit(1)
+	}
+
+	var frontMatter map[string]string
+	chatFname := ""
+	if projectDir, err := findProjectDirectory(); err == nil {
+		chatFname = filepath.Join(projectDir, ".ai-stdio.md")
+		if content, err := os.ReadFile(chatFname); err == nil {
+			frontMatter = conversation.FrontMatter(string(content))
+		}
+	}
+
+	resolved, err := config.Resolve(cwd, frontMatter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *isResolved {
+		for _, setting := range resolved.Settings() {
+			fmt.Printf("%s: %v    # %s\n", setting.Path, setting.Value, setting.Source)
+		}
+		return
+	}
+
+	for _, layer := range resolved.Layers {
+		path := layer.Path
+		switch {
+		case layer.Name == config.LayerDefaults:
+			path = "(built in)"
+		case layer.Name == config.LayerChat:
+			path = chatFname
+		case path == "":
+			path = "(none found)"
+		}
+
+		status := "found"
+		if !layer.Found {
+			status = "missing"
+		}
+
+		fmt.Printf("%-8s %-7s %s\n", layer.Name, status, path)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jcowgar/acme-utils/internal/config"
+	"github.com/jcowgar/acme-utils/internal/conversation"
+	"github.com/jcowgar/acme-utils/internal/llm"
+	"github.com/jcowgar/acme-utils/internal/prompt"
+	"github.com/jcowgar/acme-utils/internal/redact"
+)
+
+// quickAction is a one-shot action over text piped on stdin, such as an
+// editor selection. Its prompt is the template named do-<name> in the
+// prompt library when there is one, otherwise the built-in prompt.
+type quickAction struct {
+	description string
+	prompt      string
+
+	// postProcess turns the response into the output, nil to print the
+	// response as is
+	postProcess func(response string) string
+}
+
+// quickActions are the actions run by ai-stdio do <action>.
+var quickActions = map[string]quickAction{
+	"explain": {
+		description: "Explain what the selection does",
+		prompt: `You are an expert {{with .Language}}{{.}} {{end}}programmer working on the {{.Project}} project.
+Explain what the code you are given does, how it does it and anything
+surprising about it. Be concise.`,
+	},
+	"refactor": {
+		description: "Rewrite the selection to be clearer, keeping its behaviour",
+		prompt: `You are an expert {{with .Language}}{{.}} {{end}}programmer working on the {{.Project}} project.
+Refactor the code you are given to be clearer and simpler without changing
+its behaviour. Reply with only the refactored code, in a single code block,
+so it can replace the original in place.`,
+		postProcess: stripCodeFence,
+	},
+	"document": {
+		description: "Add documentation comments to the selection",
+		prompt: `You are an expert {{with .Language}}{{.}} {{end}}programmer working on the {{.Project}} project.
+Add documentation comments to the code you are given, following the
+conventions of the language. Do not change the code itself. Reply with only
+the documented code, in a single code block.`,
+		postProcess: stripCodeFence,
+	},
+	"test": {
+		description: "Write tests for the selection",
+		prompt: `You are an expert {{with .Language}}{{.}} {{end}}programmer working on the {{.Project}} project.
+Write tests for the code you are given, using the language's standard
+testing tools and covering its edge cases. Reply with only the test code, in
+a single code block.`,
+		postProcess: stripCodeFence,
+	},
+	"fix": {
+		description: "Fix bugs in the selection",
+		prompt: `You are an expert {{with .Language}}{{.}} {{end}}programmer working on the {{.Project}} project.
+Find and fix the bugs in the code you are given, changing as little as
+possible. Reply with only the fixed code, in a single code block, so it can
+replace the original in place.`,
+		postProcess: stripCodeFence,
+	},
+	"commit-msg": {
+		description: "Write a commit message for the diff",
+		prompt: `Write a git commit message for the diff you are given: a summary line of at
+most 72 characters in the imperative mood, a blank line, then a short body
+explaining what changed and why. Reply with only the commit message.`,
+		postProcess: stripCodeFence,
+	},
+}
+
+// actionDo runs a quick action over stdin, writing the result to stdout.
+func actionDo(args []string) {
+	flags := flag.NewFlagSet("do", flag.ExitOnError)
+	modelName := flags.String("model", "", "Model to use instead of the default")
+	language := flags.String("lang", "", "Language of the selection, instead of the project's")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: ai-stdio do [-model name] [-lang language] action < selection\n\nactions:\n")
+		for _, name := range quickActionNames() {
+			fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, quickActions[name].description)
+		}
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	name := flags.Arg(0)
+	action, ok := quickActions[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown action: %s\n", name)
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	selection, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not read selection: %v\n", err)
+		os.Exit(1)
+	}
+
+	output, err := runQuickAction(context.Background(), name, action, string(selection), *modelName, *language)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed: %v\n", name, err)
+		os.Exit(1)
+	}
+
+	fmt.Print(output)
+}
+
+func runQuickAction(ctx context.Context, name string, action quickAction, selection string, modelName string, language string) (string, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return "", fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	projectDir, err := findProjectDirectory()
+	if err != nil {
+		return "", err
+	}
+
+	vars := prompt.DetectVars(projectDir)
+	vars.Model = modelName
+	vars.Params["action"] = name
+	if language != "" {
+		vars.Language = language
+	}
+
+	system, err := quickActionPrompt(projectDir, name, action, vars)
+	if err != nil {
+		return "", err
+	}
+
+	// The output may replace the selection in place, so the secrets hidden
+	// from the model are put back into it
+	var redactor *redact.Redactor
+	if !cfg.Redact.Disabled {
+		redactor, err = redact.New(cfg.Redact)
+		if err != nil {
+			return "", err
+		}
+
+		var findings []redact.Finding
+		selection, findings = redactor.Redact("selection", selection)
+		for _, line := range redact.Summarize(findings) {
+			fmt.Fprintf(os.Stderr, "redacted %s\n", line)
+		}
+	}
+
+	if modelName == "" {
+		modelName = cfg.LLM.DefaultProvider
+	}
+	_, providerConfig, err := cfg.LLM.ResolveProvider(modelName)
+	if err != nil {
+		return "", fmt.Errorf("%w, run ai-stdio models to list them", err)
+	}
+
+	provider, err := llm.NewProvider(providerConfig.Type, providerConfig)
+	if err != nil {
+		return "", fmt.Errorf("failed to create provider: %w", err)
+	}
+
+	response, err := provider.Chat(ctx, []llm.Message{
+		{Role: "system", Content: system},
+		{Role: "user", Content: conversation.Fence(selection, strings.ToLower(vars.Language))},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return quickActionOutput(action, response, selection, redactor), nil
+}
+
+// quickActionOutput turns the response of an action over selection into its
+// output, putting back the secrets redactor hid from the model when it is
+// not nil.
+func quickActionOutput(action quickAction, response string, selection string, redactor *redact.Redactor) string {
+	output := strings.TrimSpace(response)
+	if action.postProcess != nil {
+		output = action.postProcess(response)
+	}
+	if redactor != nil {
+		output = redactor.Restore(output)
+	}
+
+	// Keep the selection's final newline so it can be replaced in place
+	if strings.HasSuffix(selection, "\n") && !strings.HasSuffix(output, "\n") {
+		output += "\n"
+	}
+
+	return output
+}
+
+// quickActionPrompt renders the action's prompt, preferring a do-<name>
+// template from the prompt library over the built-in one.
+func quickActionPrompt(projectDir string, name string, action quickAction, vars prompt.Vars) (string, error) {
+	if tmpl, err := promptLibrary(projectDir).Find("do-" + name); err == nil {
+		return tmpl.Render(vars)
+	}
+
+	return prompt.Render(name, action.prompt, vars)
+}
+
+func quickActionNames() []string {
+	names := make([]string, 0, len(quickActions))
+	for name := range quickActions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// stripCodeFence returns the content of the first fenced code block in the
+// response, or the whole response, trimmed, when there is none.
+func stripCodeFence(response string) string {
+	blocks := conversation.ParseCodeBlocks(response)
+	if len(blocks) == 0 {
+		return strings.TrimSpace(response)
+	}
+
+	return strings.TrimSuffix(blocks[0].Content, "\n")
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jcowgar/acme-utils/internal/config"
+	"github.com/jcowgar/acme-utils/internal/conversation"
+	"github.com/jcowgar/acme-utils/internal/doctor"
+)
+
+// actionDoctor validates the configuration of the current project and chat,
+// checks every provider can be reached and reports how to fix what cannot.
+func actionDoctor(_ []string) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not get current working directory: %v\n", err)
+		os.Exit(1)
+	}
+
+	var frontMatter map[string]string
+	if projectDir, err := findProjectDirectory(); err == nil {
+		if content, err := os.ReadFile(filepath.Join(projectDir, ".ai-stdio.md")); err == nil {
+			frontMatter = conversation.FrontMatter(string(content))
+		}
+	}
+
+	resolved, err := config.Resolve(cwd, frontMatter)
+	if err != nil {
+		fmt.Printf("fail  config: %v\n", err)
+		fmt.Printf("      fix: correct the YAML syntax of the file named above\n")
+		os.Exit(1)
+	}
+
+	checks := doctor.Run(context.Background(), resolved)
+	for _, check := range checks {
+		fmt.Printf("%-5s %s: %s\n", check.Status, check.Name, check.Message)
+		if check.Fix != "" {
+			fmt.Printf("%s fix: %s\n", strings.Repeat(" ", 5), check.Fix)
+		}
+	}
+
+	if doctor.Failed(checks) {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jcowgar/acme-utils/internal/conversation"
+)
+
+// actionExtract prints the code of the fenced blocks of a response, so an
+// editor can replace a selection with it.
+func actionExtract(args []string) {
+	flags := flag.NewFlagSet("extract", flag.ExitOnError)
+	lang := flags.String("lang", "", "Only extract blocks of this language")
+	index := flags.String("index", "", "Only extract block `N` of those left, counting from 1, or the last")
+	response := flags.String("response", "last", "Extract from response `N`, counting from 1, or from the last response")
+	flags.Parse(args)
+
+	_, _, _, conv := readChat()
+
+	msg, err := selectResponse(conv, *response)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	blocks, err := selectCodeBlocks(msg.CodeBlocks(), *lang, *index)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	contents := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		contents = append(contents, block.Content)
+	}
+
+	fmt.Print(strings.Join(contents, "\n"))
+}
+
+// selectCodeBlocks returns the blocks of a language, or of any when lang is
+// "", and of those the one numbered by index, or all when index is "". No
+// block being left is an error, so an editor does not replace its selection
+// with nothing.
+func selectCodeBlocks(blocks []conversation.CodeBlock, lang string, index string) ([]conversation.CodeBlock, error) {
+	matching := make([]conversation.CodeBlock, 0, len(blocks))
+	for _, block := range blocks {
+		if lang == "" || strings.EqualFold(block.Language, lang) {
+			matching = append(matching, block)
+		}
+	}
+
+	if len(matching) == 0 {
+		if lang != "" {
+			return nil, fmt.Errorf("the response has no %s code block", lang)
+		}
+		return nil, fmt.Errorf("the response has no code block")
+	}
+
+	switch index {
+	case "":
+		return matching, nil
+	case "last":
+		return matching[len(matching)-1:], nil
+	}
+
+	n, err := strconv.Atoi(index)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid index %q, want a number from 1 or last", index)
+	}
+	if n > len(matching) {
+		return nil, fmt.Errorf("code block %d does not exist, the response has %d", n, len(matching))
+	}
+
+	return matching[n-1 : n], nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jcowgar/acme-utils/internal/conversation"
+)
+
+func TestSelectCodeBlocks(t *testing.T) {
+	blocks := []conversation.CodeBlock{
+		{Language: "go", Content: "a()\n"},
+		{Language: "sh", Content: "make\n"},
+		{Language: "Go", Content: "b()\n"},
+		{Content: "plain\n"},
+	}
+
+	tests := []struct {
+		name    string
+		blocks  []conversation.CodeBlock
+		lang    string
+		index   string
+		want    string
+		wantErr string
+	}{
+		{name: "all", blocks: blocks, want: "a()\n,make\n,b()\n,plain\n"},
+		{name: "language", blocks: blocks, lang: "go", want: "a()\n,b()\n"},
+		{name: "language of any case", blocks: blocks, lang: "GO", want: "a()\n,b()\n"},
+		{name: "numbered", blocks: blocks, index: "2", want: "make\n"},
+		{name: "numbered of a language", blocks: blocks, lang: "go", index: "2", want: "b()\n"},
+		{name: "last", blocks: blocks, index: "last", want: "plain\n"},
+		{name: "last of a language", blocks: blocks, lang: "go", index: "last", want: "b()\n"},
+		{name: "out of range", blocks: blocks, index: "5", wantErr: "code block 5 does not exist, the response has 4"},
+		{name: "out of range of a language", blocks: blocks, lang: "sh", index: "2", wantErr: "the response has 1"},
+		{name: "zero", blocks: blocks, index: "0", wantErr: "invalid index"},
+		{name: "not a number", blocks: blocks, index: "first", wantErr: "invalid index"},
+		{name: "no block of the language", blocks: blocks, lang: "python", wantErr: "no python code block"},
+		{name: "no block", index: "last", wantErr: "the response has no code block"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectCodeBlocks(tt.blocks, tt.lang, tt.index)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("selectCodeBlocks() = %v, %v, want an error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("selectCodeBlocks() error = %v", err)
+			}
+
+			contents := make([]string, 0, len(got))
+			for _, block := range got {
+				contents = append(contents, block.Content)
+			}
+			if joined := strings.Join(contents, ","); joined != tt.want {
+				t.Errorf("selectCodeBlocks() = %q, want %q", joined, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jcowgar/acme-utils/internal/buffer"
+	"github.com/jcowgar/acme-utils/internal/config"
+	"github.com/jcowgar/acme-utils/internal/jsonrpc"
+	"github.com/jcowgar/acme-utils/internal/lsp"
+	"github.com/jcowgar/acme-utils/internal/mcp"
+	"github.com/jcowgar/acme-utils/internal/openfiles"
+)
+
+// lspActions are the quick actions offered as code actions on a selection.
+var lspActions = []lsp.Action{
+	{Name: "explain", Title: "Explain selection", Result: lsp.ShowResult},
+	{Name: "refactor", Title: "Refactor with AI", Kind: "refactor.rewrite", Result: lsp.ReplaceSelection},
+	{Name: "test", Title: "Generate tests", Result: lsp.InsertAfterSelection},
+}
+
+// actionLSP serves the quick actions and sending the chat of the project to
+// an editor over the Language Server Protocol on standard input and output.
+func actionLSP(_ []string) {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Standard output carries the protocol, so Acme windows are only read
+	// when asked for
+	if cfg.Buffers.Acme == "on" {
+		buffer.Register(buffer.NewAcmeSource())
+	}
+	for _, command := range cfg.Buffers.Commands {
+		buffer.Register(buffer.CommandSource{Command: command})
+	}
+
+	projectDir, err := findProjectDirectory()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error finding project directory: %v\n", err)
+		os.Exit(1)
+	}
+
+	self := mcp.Self()
+	server := lsp.NewServer(lsp.ServerInfo{Name: self.Name, Version: self.Version})
+	server.Actions = lspActions
+	server.Run = func(ctx context.Context, name string, selection string, language string) (string, error) {
+		return runQuickAction(ctx, name, quickActions[name], selection, "", language)
+	}
+	server.ChatFile = filepath.Join(projectDir, ".ai-stdio.md")
+	server.SendChat = func(ctx context.Context, content string) (string, error) {
+		return sendLSPChat(server, projectDir, content)
+	}
+
+	err = server.Serve(jsonrpc.NewHeaderStream(os.Stdin, os.Stdout))
+
+	// MCP servers are kept running between sends until the editor exits
+	mcp.CloseShared()
+
+	if errors.Is(err, lsp.ErrNoShutdown) {
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "lsp: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// sendLSPChat sends the chat as the editor has it, returning the response
+// sections to add to it. The files open in the editor are those +files
+// attaches.
+func sendLSPChat(server *lsp.Server, projectDir string, content string) (string, error) {
+	cfg, err := loadChatConfig(content)
+	if err != nil {
+		return "", fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	openFiles := openfiles.ListSource{Dir: projectDir, Files: server.OpenFiles()}
+
+	conv, err := prepareConversation(cfg, content, server.ChatFile, openFiles)
+	if err != nil {
+		return "", err
+	}
+	if conv == nil {
+		// There is no new conversation data, ignore this request
+		return "", nil
+	}
+
+	var out strings.Builder
+
+	if err := approveProposals(cfg, conv, &out); err != nil {
+		return "", fmt.Errorf("failed to approve proposals: %w", err)
+	}
+
+	if err := redactConversation(cfg, conv); err != nil {
+		return "", fmt.Errorf("failed to redact conversation: %w", err)
+	}
+
+	provider, err := newConversationProvider(cfg, conv)
+	if err != nil {
+		return "", fmt.Errorf("failed to create provider: %w", err)
+	}
+
+	if err := sendConversation(cfg, provider, conv, &out); err != nil {
+		return "", fmt.Errorf("error processing LLM request: %w", err)
+	}
+
+	return out.String(), nil
+}
@@ -3,15 +3,46 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
+)
+
+// commands are the subcommands, given as the first argument, that run in
+// place of the -new and -send flags.
+var commands = map[string]func(args []string){
+	"acme":      actionAcme,
+	"apply":     actionApply,
+	"config":    actionConfig,
+	"do":        actionDo,
+	"doctor":    actionDoctor,
+	"extract":   actionExtract,
+	"lsp":       actionLSP,
+	"mcp":       actionMCP,
+	"mcp-serve": actionMCPServe,
+	"models":    actionModels,
+	"new":       actionNew,
+	"serve":     actionServe,
+	"templates": actionTemplates,
+}
+
+var (
+	openFilesFlag     = flag.String("files", "", "Comma separated list of the open files attached by +files")
+	openFilesFromFlag = flag.String("files-from", "", "File listing the open files attached by +files, one per line, or - for stdin")
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
+
 	isNew := flag.Bool("new", false, "Create a new AI chat")
 	isSend := flag.Bool("send", false, "Send the current AI chat to the LLM")
 	flag.Parse()
 
 	if *isNew == *isSend {
-		fmt.Printf("invalid usage\nusage: ai-stdio -new [model] | -send\n\n")
+		fmt.Printf("invalid usage\nusage: ai-stdio -new [model] | new [--template name] [model] [key=value...] | templates | -send | apply [--response N | --approve] [--dry-run] | acme | config show [--resolved] | config init [--force] | do action | doctor | extract [--lang go] [--index N] [--response N] | lsp | mcp [server...] | mcp-serve [-dir path] | models [--available] | serve --stdio | serve --http [host]:port\n\n")
 		flag.PrintDefaults()
 	} else if *isNew {
 		actionNew(flag.Args())
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jcowgar/acme-utils/internal/config"
+	"github.com/jcowgar/acme-utils/internal/mcp"
+)
+
+// actionMCP lists the tools and resources of the configured MCP servers, or
+// of those named as arguments.
+func actionMCP(args []string) {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	pool := mcp.Shared(cfg.MCP.Servers)
+	defer mcp.CloseShared()
+
+	names := args
+	if len(names) == 0 {
+		names = pool.Names()
+	}
+	if len(names) == 0 {
+		fmt.Fprintf(os.Stderr, "no mcp servers are configured, add them under mcp.servers\n")
+		return
+	}
+
+	ctx := context.Background()
+	failed := false
+
+	for _, name := range names {
+		if err := listMCPServer(ctx, pool, name); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			failed = true
+		}
+	}
+
+	if failed {
+		mcp.CloseShared()
+		os.Exit(1)
+	}
+}
+
+// listMCPServer prints a server's tools, as offered to the model, and its
+// resources, as attached with +mcp.
+func listMCPServer(ctx context.Context, pool *mcp.Pool, name string) error {
+	client, err := pool.Client(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	tools, err := client.ListTools(ctx)
+	if err != nil {
+		return err
+	}
+
+	resources, err := client.ListResources(ctx)
+	if err != nil {
+		// Servers without resources may not support listing them
+		resources = nil
+	}
+
+	fmt.Printf("%s\t%s %s\n", name, client.ServerInfo.Name, client.ServerInfo.Version)
+	for _, tool := range tools {
+		fmt.Printf("  tool %s__%s\t%s\n", name, tool.Name, firstLine(tool.Description))
+	}
+	for _, resource := range resources {
+		fmt.Printf("  +mcp %s:%s\t%s\n", name, resource.URI, resource.Name)
+	}
+
+	return nil
+}
+
+func firstLine(text string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	return line
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jcowgar/acme-utils/internal/agent"
+	"github.com/jcowgar/acme-utils/internal/config"
+	"github.com/jcowgar/acme-utils/internal/conversation"
+	"github.com/jcowgar/acme-utils/internal/jsonrpc"
+	"github.com/jcowgar/acme-utils/internal/llm"
+	"github.com/jcowgar/acme-utils/internal/mcp"
+	"github.com/jcowgar/acme-utils/internal/redact"
+)
+
+// maxListedResources caps the project files listed as MCP resources
+const maxListedResources = 1000
+
+// actionMCPServe serves the configured models and the resource fetchers to
+// other agents over MCP on standard input and output.
+func actionMCPServe(args []string) {
+	flags := flag.NewFlagSet("mcp-serve", flag.ExitOnError)
+	dirFlag := flags.String("dir", "", "Project directory files are served from, the git root or working directory by default")
+	flags.Parse(args)
+
+	projectDir := *dirFlag
+	if projectDir == "" {
+		dir, err := findProjectDirectory()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error finding project directory: %v\n", err)
+			os.Exit(1)
+		}
+		projectDir = dir
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	server, err := newMCPServer(&cfg, projectDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	if err := server.Serve(jsonrpc.NewLineStream(os.Stdin, os.Stdout)); err != nil {
+		fmt.Fprintf(os.Stderr, "mcp-serve: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// mcpProject serves a project's files, URLs and the configured models,
+// applying the ignore rules and redaction of the configuration.
+type mcpProject struct {
+	cfg      *config.Config
+	files    *agent.Toolbox
+	redactor *redact.Redactor
+}
+
+// newMCPServer returns an MCP server offering a chat tool for the
+// configured models, tools for reading files, globs and URLs as +file, +glob
+// and +url do, and the project's files as resources.
+func newMCPServer(cfg *config.Config, projectDir string) (*mcp.Server, error) {
+	files, err := agent.NewToolbox(projectDir)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &mcpProject{cfg: cfg, files: files}
+	if !cfg.Redact.Disabled {
+		p.redactor, err = redact.New(cfg.Redact)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	server := mcp.NewServer(mcp.Self())
+	server.Instructions = "Ask the models configured for this project with the chat tool, and read project files and web pages with read_file, glob and fetch_url. Secrets are redacted from everything returned."
+
+	server.AddTool(mcp.Tool{
+		Name:        "chat",
+		Description: "Ask one of the configured models. Models: " + strings.Join(modelNames(&cfg.LLM), ", ") + ".",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"prompt": map[string]interface{}{"type": "string", "description": "The question, sent as the last user message"},
+				"system": map[string]interface{}{"type": "string", "description": "System prompt"},
+				"model":  map[string]interface{}{"type": "string", "description": "Name or alias of the model, the default model when omitted"},
+				"messages": map[string]interface{}{
+					"type":        "array",
+					"description": "Earlier messages of the conversation, before the prompt",
+					"items": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"role":    map[string]interface{}{"type": "string", "enum": []string{"user", "assistant"}},
+							"content": map[string]interface{}{"type": "string"},
+						},
+						"required": []string{"role", "content"},
+					},
+				},
+			},
+		},
+	}, p.chat)
+
+	server.AddTool(mcp.Tool{
+		Name:        "read_file",
+		Description: "Read a file of the project, preferring unsaved editor content, as +file does.",
+		InputSchema: map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"path": map[string]interface{}{"type": "string", "description": "Path relative to the project directory"}},
+			"required":   []string{"path"},
+		},
+	}, func(ctx context.Context, arguments json.RawMessage) (string, error) {
+		var args struct {
+			Path string `json:"path"`
+		}
+		if err := json.Unmarshal(arguments, &args); err != nil {
+			return "", fmt.Errorf("invalid arguments: %w", err)
+		}
+
+		return p.fetchText(p.fileRequest(args.Path))
+	})
+
+	server.AddTool(mcp.Tool{
+		Name:        "glob",
+		Description: "Read every file of the project matching a glob pattern, leaving out ignored files, as +glob does.",
+		InputSchema: map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"pattern": map[string]interface{}{"type": "string", "description": "Pattern relative to the project directory, such as internal/*.go"}},
+			"required":   []string{"pattern"},
+		},
+	}, func(ctx context.Context, arguments json.RawMessage) (string, error) {
+		var args struct {
+			Pattern string `json:"pattern"`
+		}
+		if err := json.Unmarshal(arguments, &args); err != nil {
+			return "", fmt.Errorf("invalid arguments: %w", err)
+		}
+
+		return p.fetchText(p.globRequest(args.Pattern))
+	})
+
+	server.AddTool(mcp.Tool{
+		Name:        "fetch_url",
+		Description: "Fetch a web page as text, through the cache and size limits +url uses.",
+		InputSchema: map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"url": map[string]interface{}{"type": "string"}},
+			"required":   []string{"url"},
+		},
+	}, func(ctx context.Context, arguments json.RawMessage) (string, error) {
+		var args struct {
+			URL string `json:"url"`
+		}
+		if err := json.Unmarshal(arguments, &args); err != nil {
+			return "", fmt.Errorf("invalid arguments: %w", err)
+		}
+
+		return p.fetchText(conversation.URLResourceRequest{URL: args.URL}, nil)
+	})
+
+	server.ListResources = p.listResources
+	server.ReadResource = p.readResource
+	server.ResourceTemplates = []mcp.ResourceTemplate{
+		{URITemplate: "file://" + filepath.ToSlash(files.Dir) + "/{path}", Name: "Project file", Description: "A file of the project, as +file reads it"},
+		{URITemplate: "glob:///{pattern}", Name: "Project files matching a glob", Description: "The files matching a pattern relative to the project directory, as +glob reads them"},
+		{URITemplate: "https://{url}", Name: "Web page", Description: "A web page as text, as +url fetches it"},
+	}
+
+	return server, nil
+}
+
+// modelNames lists the names and aliases chats may select providers by,
+// marking the default.
+func modelNames(cfg *config.LLMConfig) []string {
+	names := make([]string, 0, len(cfg.Providers))
+	for name, provider := range cfg.Providers {
+		if name == cfg.DefaultProvider {
+			name += " (default)"
+		}
+		names = append(names, name)
+		names = append(names, provider.Aliases...)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// chat asks a configured model, redacting what is sent.
+func (p *mcpProject) chat(ctx context.Context, arguments json.RawMessage) (string, error) {
+	var args struct {
+		Prompt   string `json:"prompt"`
+		System   string `json:"system"`
+		Model    string `json:"model"`
+		Messages []struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+
+	messages := make([]llm.Message, 0, len(args.Messages)+2)
+	if args.System != "" {
+		messages = append(messages, llm.Message{Role: "system", Content: args.System})
+	}
+	for _, msg := range args.Messages {
+		if msg.Role != "user" && msg.Role != "assistant" {
+			return "", fmt.Errorf("invalid message role %q, use user or assistant", msg.Role)
+		}
+		messages = append(messages, llm.Message{Role: msg.Role, Content: msg.Content})
+	}
+	if args.Prompt != "" {
+		messages = append(messages, llm.Message{Role: "user", Content: args.Prompt})
+	}
+	if len(messages) == 0 || messages[len(messages)-1].Role != "user" {
+		return "", fmt.Errorf("give a prompt or end the messages with a user message")
+	}
+
+	for i := range messages {
+		messages[i].Content = p.redact(fmt.Sprintf("message %d", i+1), messages[i].Content)
+	}
+
+	model := args.Model
+	if model == "" {
+		model = p.cfg.LLM.DefaultProvider
+	}
+
+	_, providerConfig, err := p.cfg.LLM.ResolveProvider(model)
+	if err != nil {
+		return "", err
+	}
+
+	provider, err := llm.NewProvider(providerConfig.Type, providerConfig)
+	if err != nil {
+		return "", fmt.Errorf("failed to create provider: %w", err)
+	}
+
+	reply, err := provider.Chat(ctx, messages)
+	if err != nil {
+		return "", fmt.Errorf("failed to get response from provider: %w", err)
+	}
+
+	return reply, nil
+}
+
+func (p *mcpProject) redact(source string, text string) string {
+	if p.redactor == nil {
+		return text
+	}
+
+	redacted, findings := p.redactor.Redact(source, text)
+	for _, line := range redact.Summarize(findings) {
+		fmt.Fprintf(os.Stderr, "redacted %s\n", line)
+	}
+
+	return redacted
+}
+
+// fileRequest returns the request for a project file, or the error refusing
+// it when it is outside the project or ignored.
+func (p *mcpProject) fileRequest(path string) (conversation.ResourceRequest, error) {
+	filename, err := p.files.Resolve(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.ignored(filename) {
+		return nil, fmt.Errorf("%s is ignored", path)
+	}
+
+	return conversation.FileResourceRequest{Filename: filename}, nil
+}
+
+// globRequest returns the request for the project files matching a
+// pattern, refusing patterns leading outside the project.
+func (p *mcpProject) globRequest(pattern string) (conversation.ResourceRequest, error) {
+	clean := filepath.Clean(pattern)
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("%s is outside the project directory", pattern)
+	}
+
+	return conversation.FileGlobResourceRequest{Pattern: clean}, nil
+}
+
+// ignored reports whether a file is left out by the ignore rules of +files
+// and +glob.
+func (p *mcpProject) ignored(filename string) bool {
+	if inIgnoreFilenames(filename) {
+		return true
+	}
+
+	for _, ignore := range p.cfg.LLM.GlobIgnore {
+		if strings.Contains(filename, ignore) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fetch fetches a request's resources, dropping ignored files and any
+// outside the project, and redacting the rest.
+func (p *mcpProject) fetch(req conversation.ResourceRequest, err error) ([]conversation.Resource, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	results := conversation.FetchResources(p.cfg, p.files.Dir, []conversation.ResourceRequest{req})
+	if err := conversation.FetchErrors(results); err != nil {
+		return nil, err
+	}
+
+	resources := make([]conversation.Resource, 0, len(results[0].Resources))
+	for _, resource := range results[0].Resources {
+		if resource.ResourceType == conversation.ReferenceTypeFile {
+			if _, err := p.files.Resolve(resource.Source); err != nil || p.ignored(resource.Source) {
+				continue
+			}
+		}
+
+		resource.Content = p.redact(resource.ResourceType+" "+resource.Name, resource.Content)
+		resources = append(resources, resource)
+	}
+
+	return resources, nil
+}
+
+// fetchText fetches a request's resources as the text of a tool result.
+func (p *mcpProject) fetchText(req conversation.ResourceRequest, err error) (string, error) {
+	resources, err := p.fetch(req, err)
+	if err != nil {
+		return "", err
+	}
+
+	if len(resources) == 1 {
+		return resources[0].Content, nil
+	}
+	if len(resources) == 0 {
+		return "no matching files", nil
+	}
+
+	material := make([]conversation.ReferenceMaterial, 0, len(resources))
+	for _, resource := range resources {
+		material = append(material, conversation.ReferenceMaterial{Typ: resource.ResourceType, Name: resource.Name, Content: resource.Content})
+	}
+
+	return strings.TrimSpace(formatReferenceMaterial(material)), nil
+}
+
+// listResources lists the files git tracks in the project, other than
+// ignored files.
+func (p *mcpProject) listResources(ctx context.Context) ([]mcp.Resource, error) {
+	cmd := exec.CommandContext(ctx, "git", "ls-files")
+	cmd.Dir = p.files.Dir
+
+	output, err := cmd.Output()
+	if err != nil {
+		// Not a git repository, the files can still be read by template
+		return []mcp.Resource{}, nil
+	}
+
+	resources := make([]mcp.Resource, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() && len(resources) < maxListedResources {
+		name := scanner.Text()
+		filename := filepath.Join(p.files.Dir, name)
+		if p.ignored(filename) {
+			continue
+		}
+
+		resources = append(resources, mcp.Resource{URI: fileURI(filename), Name: name})
+	}
+
+	return resources, nil
+}
+
+// readResource reads a file:// or glob:/// resource of the project or an
+// http or https URL.
+func (p *mcpProject) readResource(ctx context.Context, uri string) ([]mcp.ResourceContents, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid resource URI %s: %w", uri, err)
+	}
+
+	var resources []conversation.Resource
+	switch parsed.Scheme {
+	case "file":
+		resources, err = p.fetch(p.fileRequest(parsed.Path))
+	case "glob":
+		resources, err = p.fetch(p.globRequest(strings.TrimPrefix(parsed.Path, "/")))
+	case "http", "https":
+		resources, err = p.fetch(conversation.URLResourceRequest{URL: uri}, nil)
+	default:
+		return nil, fmt.Errorf("unsupported resource URI %s", uri)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	contents := make([]mcp.ResourceContents, 0, len(resources))
+	for _, resource := range resources {
+		resourceURI := resource.Source
+		if resource.ResourceType == conversation.ReferenceTypeFile {
+			resourceURI = fileURI(resource.Source)
+		}
+
+		contents = append(contents, mcp.ResourceContents{URI: resourceURI, MimeType: "text/plain", Text: resource.Content})
+	}
+
+	return contents, nil
+}
+
+func fileURI(filename string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(filename)}).String()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jcowgar/acme-utils/internal/config"
+	"github.com/jcowgar/acme-utils/internal/llm"
+	"github.com/jcowgar/acme-utils/internal/llm/types"
+)
+
+// actionModels lists the configured models and, with --available, the
+// models each backend offers.
+func actionModels(args []string) {
+	flags := flag.NewFlagSet("models", flag.ExitOnError)
+	isAvailable := flags.Bool("available", false, "Also list the models available from each backend")
+	flags.Parse(args)
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, line := range configuredModels(&cfg.LLM) {
+		fmt.Println(line)
+	}
+
+	if *isAvailable {
+		if !listAvailableModels(&cfg.LLM) {
+			os.Exit(1)
+		}
+	}
+}
+
+// configuredModels describes each configured provider on a line, marking
+// the default.
+func configuredModels(cfg *config.LLMConfig) []string {
+	names := make([]string, 0, len(cfg.Providers))
+	for name := range cfg.Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		provider := cfg.Providers[name]
+
+		line := fmt.Sprintf("%s\t%s %s", name, provider.Type, provider.Model)
+		if len(provider.Aliases) > 0 {
+			line += " (aliases: " + strings.Join(provider.Aliases, ", ") + ")"
+		}
+		if name == cfg.DefaultProvider {
+			line += " (default)"
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// listAvailableModels prints the models offered by each distinct backend,
+// asking each only once however many providers share it. It reports
+// whether every backend could be listed.
+func listAvailableModels(cfg *config.LLMConfig) bool {
+	backends := make(map[string]config.ProviderConfig)
+	for _, provider := range cfg.Providers {
+		baseURL, _ := provider.Params["base_url"].(string)
+		backends[provider.Type+" "+baseURL] = provider
+	}
+
+	keys := make([]string, 0, len(backends))
+	for key := range backends {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	ok := true
+	for _, key := range keys {
+		fmt.Printf("\n%s:\n", strings.TrimSpace(key))
+
+		models, err := availableModels(backends[key])
+		if err != nil {
+			fmt.Printf("\terror: %v\n", err)
+			ok = false
+			continue
+		}
+
+		sort.Strings(models)
+		for _, model := range models {
+			fmt.Printf("\t%s\n", model)
+		}
+	}
+
+	return ok
+}
+
+func availableModels(providerConfig config.ProviderConfig) ([]string, error) {
+	provider, err := llm.NewProvider(providerConfig.Type, providerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	lister, ok := provider.(types.ModelLister)
+	if !ok {
+		return nil, fmt.Errorf("%s cannot list its models", provider.Name())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return lister.ListModels(ctx)
+}
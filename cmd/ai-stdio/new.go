@@ -2,9 +2,11 @@ package main
 
 import (
 	"crypto/rand"
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -56,6 +58,43 @@ func findProjectDirectory() (string, error) {
 	return projectDir, nil
 }
 
+// chatProjectDirectory checks the project_directory a chat in the project
+// root names, returning it as an absolute path, or "" when it names none. A
+// chat file may come from anywhere, and the agent's tools reach the whole
+// of the directory, so it must be the root or inside it.
+func chatProjectDirectory(root string, dir string) (string, error) {
+	if dir == "" {
+		return "", nil
+	}
+
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve project directory: %w", err)
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve project_directory: %w", err)
+	}
+
+	if !isWithin(resolvedRoot, resolved) {
+		return "", fmt.Errorf("project_directory %s is outside the project %s", dir, root)
+	}
+
+	return resolved, nil
+}
+
+// isWithin reports whether path is dir or below it, comparing the paths as
+// they are written.
+func isWithin(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
 func generateChatFilename(basePath string) (string, error) {
 	const (
 		codeLength = 6
@@ -92,7 +131,16 @@ func generateChatFilename(basePath string) (string, error) {
 }
 
 func actionNew(args []string) {
-	prompt := findPrompt()
+	flags := flag.NewFlagSet("new", flag.ExitOnError)
+	templateName := flags.String("template", "", "Name of the prompt template to start the chat with")
+	flags.Parse(args)
+
+	modelName, params, err := parseNewArgs(flags.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
 	projectDir, err := findProjectDirectory()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error finding project directory: %v\n", err)
@@ -119,13 +167,89 @@ func actionNew(args []string) {
 
 	chatFname = filepath.Join(projectDir, ".ai-stdio.md")
 
+	prompt, err := chatPrompt(projectDir, modelName, *templateName, params)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error rendering prompt: %v\n", err)
+		fmt.Fprintf(os.Stderr, "template parameters are given as key=value arguments\n")
+		os.Exit(1)
+	}
+
+	content := newChatContent(projectDir, modelName, *templateName, params, prompt)
+	os.WriteFile(chatFname, []byte(content), 0644)
+}
+
+// parseNewArgs splits the arguments of a new chat into the model, the first
+// argument that is not a parameter, and the key=value parameters its prompt
+// template is rendered with. The parameters are kept in the front matter so
+// later sends render the template the same way.
+func parseNewArgs(args []string) (string, map[string]string, error) {
+	modelName := ""
+	params := make(map[string]string)
+
+	for _, arg := range args {
+		key, value, isParam := strings.Cut(arg, "=")
+		if !isParam {
+			if modelName != "" {
+				return "", nil, fmt.Errorf("more than one model given: %s and %s", modelName, arg)
+			}
+			modelName = arg
+			continue
+		}
+
+		switch key = strings.TrimSpace(key); key {
+		case "":
+			return "", nil, fmt.Errorf("parameter %s has no name", arg)
+		case "model", "project_directory", "template":
+			return "", nil, fmt.Errorf("parameter %s would replace the chat's %s", arg, key)
+		}
+		params[key] = strings.TrimSpace(value)
+	}
+
+	return modelName, params, nil
+}
+
+// chatPrompt returns the Prompt section of a new chat, rendered from the
+// named template with params, or read from the nearest .prompt file when
+// templateName is empty.
+func chatPrompt(projectDir string, modelName string, templateName string, params map[string]string) (string, error) {
+	if templateName == "" {
+		return findPrompt(), nil
+	}
+
+	values := make(map[string]interface{}, len(params))
+	for key, value := range params {
+		values[key] = value
+	}
+
+	prompt, err := renderTemplate(projectDir, templateName, modelName, values)
+	if err != nil {
+		return "", err
+	}
+
+	return "## Prompt\n\n" + prompt, nil
+}
+
+// newChatContent returns the content of a new, empty chat. modelName may be
+// empty to use the default provider and templateName empty when the prompt
+// is not from a template. params are added to the front matter.
+func newChatContent(projectDir string, modelName string, templateName string, params map[string]string, prompt string) string {
 	frontmatter := fmt.Sprintf("---\nproject_directory: %s\n", projectDir)
-	if len(args) >= 1 {
-		modelName := args[0]
+	if modelName != "" {
 		frontmatter += fmt.Sprintf("model: %s\n", modelName)
 	}
+	if templateName != "" {
+		frontmatter += fmt.Sprintf("template: %s\n", templateName)
+	}
+
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		frontmatter += fmt.Sprintf("%s: %s\n", key, params[key])
+	}
 	frontmatter += "---\n"
 
-	content := fmt.Sprintf("%s\n# Title Here\n\n%s\n\n## You\n\n", frontmatter, prompt)
-	os.WriteFile(chatFname, []byte(content), 0644)
+	return fmt.Sprintf("%s\n# Title Here\n\n%s\n\n## You\n\n", frontmatter, prompt)
 }
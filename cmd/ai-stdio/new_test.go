@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseNewArgs(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		wantModel string
+		want      map[string]string
+		wantErr   string
+	}{
+		{"nothing", nil, "", map[string]string{}, ""},
+		{"model", []string{"sonnet"}, "sonnet", map[string]string{}, ""},
+		{"params", []string{"audience=reviewers", "sonnet", "tone= terse "}, "sonnet", map[string]string{"audience": "reviewers", "tone": "terse"}, ""},
+		{"value with =", []string{"query=a=b"}, "", map[string]string{"query": "a=b"}, ""},
+		{"two models", []string{"sonnet", "gpt"}, "", nil, "more than one model"},
+		{"no name", []string{"=x"}, "", nil, "has no name"},
+		{"reserved", []string{"model=gpt"}, "", nil, "replace the chat's model"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, params, err := parseNewArgs(tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseNewArgs(%q) error = %v, want %q", tt.args, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseNewArgs(%q) error = %v", tt.args, err)
+			}
+			if model != tt.wantModel || !reflect.DeepEqual(params, tt.want) {
+				t.Errorf("parseNewArgs(%q) = %q, %v, want %q, %v", tt.args, model, params, tt.wantModel, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewChatContentParams(t *testing.T) {
+	content := newChatContent("/project", "sonnet", "review", map[string]string{"tone": "terse", "audience": "reviewers"}, "## Prompt\n\nReview")
+
+	want := "---\nproject_directory: /project\nmodel: sonnet\ntemplate: review\naudience: reviewers\ntone: terse\n---\n"
+	if !strings.HasPrefix(content, want) {
+		t.Errorf("newChatContent() = %q, want front matter %q", content, want)
+	}
+}
+
+func TestChatProjectDirectory(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	for _, dir := range []string{"sub", "sub/deeper"} {
+		if err := os.Mkdir(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		dir     string
+		want    string
+		wantErr bool
+	}{
+		{name: "none", dir: "", want: ""},
+		{name: "root", dir: root, want: resolvedRoot},
+		{name: "inside", dir: filepath.Join(root, "sub/deeper"), want: filepath.Join(resolvedRoot, "sub/deeper")},
+		{name: "relative", dir: "sub", want: filepath.Join(resolvedRoot, "sub")},
+		{name: "filesystem root", dir: "/", wantErr: true},
+		{name: "parent", dir: filepath.Join(root, ".."), wantErr: true},
+		{name: "relative parent", dir: "sub/../..", wantErr: true},
+		{name: "elsewhere", dir: outside, wantErr: true},
+		{name: "symlink out", dir: filepath.Join(root, "link"), wantErr: true},
+		{name: "missing", dir: filepath.Join(root, "missing"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := chatProjectDirectory(root, tt.dir)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("chatProjectDirectory(%q) = %q, want an error", tt.dir, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("chatProjectDirectory(%q) = %q, %v, want %q", tt.dir, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestChatPrompt(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	projectDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(projectDir, ".ai-stdio", "prompts"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(projectDir, ".ai-stdio", "prompts", "review.md"), []byte("Review {{.Project}} for {{.Model}}.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := chatPrompt(projectDir, "sonnet", "review", nil)
+	if want := "## Prompt\n\nReview " + filepath.Base(projectDir) + " for sonnet."; err != nil || got != want {
+		t.Errorf("chatPrompt() = %q, %v, want %q", got, err, want)
+	}
+
+	if _, err := chatPrompt(projectDir, "", "missing", nil); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("chatPrompt() of a missing template error = %v, want it not found", err)
+	}
+}
+
+func TestNewChatContent(t *testing.T) {
+	tests := []struct {
+		name      string
+		modelName string
+		template  string
+		want      string
+	}{
+		{"defaults", "", "", "---\nproject_directory: /project\n---\n"},
+		{"model and template", "sonnet", "review", "---\nproject_directory: /project\nmodel: sonnet\ntemplate: review\n---\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := newChatContent("/project", tt.modelName, tt.template, nil, "## Prompt\n\nReview")
+			if want := tt.want + "\n# Title Here\n\n## Prompt\n\nReview\n\n## You\n\n"; content != want {
+				t.Errorf("newChatContent() = %q, want %q", content, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jcowgar/acme-utils/internal/config"
+	"github.com/jcowgar/acme-utils/internal/prompt"
+)
+
+// promptLibrary returns the templates of the project and of the global
+// configuration directory.
+func promptLibrary(projectDir string) *prompt.Library {
+	configDir := ""
+	if filename, err := config.GlobalConfigFile(); err == nil {
+		configDir = filepath.Dir(filename)
+	}
+
+	return prompt.NewLibrary(projectDir, configDir)
+}
+
+// renderTemplate renders the named prompt template for the project, making
+// the chat's model and other front matter values available to it.
+func renderTemplate(projectDir string, name string, modelName string, params map[string]interface{}) (string, error) {
+	tmpl, err := promptLibrary(projectDir).Find(name)
+	if err != nil {
+		return "", err
+	}
+
+	vars := prompt.DetectVars(projectDir)
+	vars.Model = modelName
+	for key, value := range params {
+		vars.Params[key] = fmt.Sprint(value)
+	}
+
+	return tmpl.Render(vars)
+}
+
+// actionTemplates lists the prompt templates available to the project.
+func actionTemplates(_ []string) {
+	projectDir, err := findProjectDirectory()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error finding project directory: %v\n", err)
+		os.Exit(1)
+	}
+
+	templates, err := promptLibrary(projectDir).List()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	for _, tmpl := range templates {
+		fmt.Printf("%s\t%s\n", tmpl.Name, tmpl.Path)
+	}
+}
@@ -2,25 +2,66 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/jcowgar/acme-utils/internal/agent"
+	"github.com/jcowgar/acme-utils/internal/buffer"
 	"github.com/jcowgar/acme-utils/internal/config"
 	"github.com/jcowgar/acme-utils/internal/conversation"
 	"github.com/jcowgar/acme-utils/internal/llm"
+	"github.com/jcowgar/acme-utils/internal/mcp"
+	"github.com/jcowgar/acme-utils/internal/openfiles"
+	"github.com/jcowgar/acme-utils/internal/redact"
 )
 
 func actionSend(_ []string) {
-	cfg, err := config.Load()
+	projectDir, err := findProjectDirectory()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error finding project directory: %v\n", err)
+		os.Exit(1)
+	}
+
+	// MCP servers started for resources or tools are stopped on the way out
+	defer mcp.CloseShared()
+
+	chatFname := filepath.Join(projectDir, ".ai-stdio.md")
+	rawContent, err := os.ReadFile(chatFname)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading chat filename: %v", err)
+		os.Exit(1)
+	}
+
+	cfg, err := loadChatConfig(string(rawContent))
 	if err != nil {
 		log.Printf("failed to load configuration: %v\n", err)
 		return
 	}
 
-	conv, err := readConversation(&cfg)
+	registerBufferSources(cfg)
+
+	// +files looks for the files of the project the chat works on
+	dir, err := chatProjectDirectory(projectDir, conversation.FrontMatter(string(rawContent))["project_directory"])
+	if err != nil {
+		log.Printf("failed to read conversation: %v\n", err)
+		return
+	}
+	if dir == "" {
+		dir = projectDir
+	}
+
+	openFiles, err := openFilesSource(cfg, dir)
+	if err != nil {
+		log.Printf("failed to find open files: %v\n", err)
+		return
+	}
+
+	conv, err := prepareConversation(cfg, string(rawContent), chatFname, openFiles)
 	if err != nil {
 		log.Printf("failed to read conversation: %v\n", err)
 		return
@@ -30,43 +71,148 @@ func actionSend(_ []string) {
 		return
 	}
 
-	model := conv.Model
-	if model == "" {
-		model = cfg.LLM.DefaultProvider
+	if err := approveProposals(cfg, conv, os.Stdout); err != nil {
+		log.Printf("failed to approve proposals: %v\n", err)
+		return
 	}
 
-	providerConfig := cfg.LLM.Providers[model]
-	provider, err := llm.NewProvider(providerConfig.Type, providerConfig)
+	if err := redactConversation(cfg, conv); err != nil {
+		log.Printf("failed to redact conversation: %v\n", err)
+		return
+	}
+
+	provider, err := newConversationProvider(cfg, conv)
 	if err != nil {
 		log.Printf("failed to create provider: %v\n", err)
 		return
 	}
 
-	if err := sendLLMRequest(provider, conv); err != nil {
+	if err := sendConversation(cfg, provider, conv, os.Stdout); err != nil {
 		log.Printf("error processing LLM request: %v\n", err)
 	}
 }
 
-func readConversation(cfg *config.Config) (*conversation.Conversation, error) {
-	projectDir, err := findProjectDirectory()
+// newConversationProvider creates the provider for the model named by the
+// conversation, or the default provider when it names none.
+func newConversationProvider(cfg *config.Config, conv *conversation.Conversation) (llm.Provider, error) {
+	model := conv.Model
+	if model == "" {
+		model = cfg.LLM.DefaultProvider
+	}
+
+	_, providerConfig, err := cfg.LLM.ResolveProvider(model)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error finding project directory: %v\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("%w, run ai-stdio models to list them", err)
 	}
 
-	chatFname := filepath.Join(projectDir, ".ai-stdio.md")
-	rawContent, err := os.ReadFile(chatFname)
+	return llm.NewProvider(providerConfig.Type, providerConfig)
+}
+
+// registerBufferSources registers the editors configured to supply unsaved
+// buffer content for +file resources.
+func registerBufferSources(cfg *config.Config) {
+	switch cfg.Buffers.Acme {
+	case "on":
+		buffer.Register(buffer.NewAcmeSource())
+	case "off":
+	default:
+		if buffer.RunningUnderAcme() {
+			buffer.Register(buffer.NewAcmeSource())
+		}
+	}
+
+	for _, command := range cfg.Buffers.Commands {
+		buffer.Register(buffer.CommandSource{Command: command})
+	}
+}
+
+// openFilesSource selects where the +files directive finds the open files
+// of the project in dir, according to the configuration and the -files and
+// -files-from flags.
+func openFilesSource(cfg *config.Config, dir string) (openfiles.Source, error) {
+	listed := make([]string, 0)
+	if *openFilesFlag != "" {
+		listed = append(listed, strings.Split(*openFilesFlag, ",")...)
+	}
+	if *openFilesFromFlag != "" {
+		files, err := readFileList(*openFilesFromFlag)
+		if err != nil {
+			return nil, err
+		}
+		listed = append(listed, files...)
+	}
+
+	source := cfg.Files.Source
+	if source == "" || source == "auto" {
+		switch {
+		case *openFilesFlag != "" || *openFilesFromFlag != "":
+			source = "list"
+		case buffer.RunningUnderAcme():
+			source = "acme"
+		default:
+			source = "git"
+		}
+	}
+
+	switch source {
+	case "list":
+		return openfiles.ListSource{Dir: dir, Files: listed}, nil
+	case "acme":
+		return openfiles.AcmeSource{Windows: buffer.NewAcmeSource()}, nil
+	case "git":
+		return openfiles.GitSource{Dir: dir}, nil
+	default:
+		return nil, fmt.Errorf("unsupported open files source: %s", source)
+	}
+}
+
+// readFileList reads the list of files named by -files-from, where - is
+// standard input.
+func readFileList(filename string) ([]string, error) {
+	if filename == "-" {
+		return openfiles.ReadList(os.Stdin)
+	}
+
+	f, err := os.Open(filename)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading chat filename: %v", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("could not open file list: %w", err)
+	}
+	defer f.Close()
+
+	return openfiles.ReadList(f)
+}
+
+// loadChatConfig resolves the configuration for the working directory with
+// the front matter of the chat content as its most specific layer.
+func loadChatConfig(content string) (*config.Config, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("could not get current working directory: %w", err)
+	}
+
+	resolved, err := config.Resolve(cwd, conversation.FrontMatter(content))
+	if err != nil {
+		return nil, err
 	}
 
-	content := string(rawContent)
+	return &resolved.Config, nil
+}
+
+// prepareConversation parses the content of the chat file chatFname and
+// fetches its reference material, taking the files for +files from
+// openFiles. A nil conversation is returned when the last message is empty,
+// as there is nothing to send.
+func prepareConversation(cfg *config.Config, content string, chatFname string, openFiles openfiles.Source) (*conversation.Conversation, error) {
 	conv, err := conversation.ParseContent(content)
 	if err != nil {
 		return nil, fmt.Errorf("could not parse conversation content: %w", err)
 	}
 
+	conv.ProjectDirectory, err = chatProjectDirectory(filepath.Dir(chatFname), conv.ProjectDirectory)
+	if err != nil {
+		return nil, err
+	}
+
 	lastMessage, err := conv.GetLastUserMessage()
 	if err != nil {
 		return nil, fmt.Errorf("could not get last user message: %w", err)
@@ -78,73 +224,427 @@ func readConversation(cfg *config.Config) (*conversation.Conversation, error) {
 		return nil, nil
 	}
 
-	for _, req := range conv.ResourceRequests {
-		resources, err := req.Fetch(cfg, conv.ProjectDirectory)
+	if conv.Prompt == "" && conv.Template != "" {
+		projectDir := conv.ProjectDirectory
+		if projectDir == "" {
+			projectDir = filepath.Dir(chatFname)
+		}
+
+		conv.Prompt, err = renderTemplate(projectDir, conv.Template, conv.Model, conv.Parameters)
 		if err != nil {
-			return nil, fmt.Errorf("could not fetch resource: %w", err)
+			return nil, err
+		}
+	}
+
+	if conv.IncludeFiles {
+		dir := conv.ProjectDirectory
+		if dir == "" {
+			dir = filepath.Dir(chatFname)
 		}
 
-		for _, resource := range resources {
-			conv.AddReferenceMaterial(resource.ResourceType, resource.Name, resource.Content)
+		if err := requestOpenFiles(cfg, conv, dir, openFiles); err != nil {
+			return nil, fmt.Errorf("could not find open files: %w", err)
 		}
 	}
 
+	if err := fetchReferenceMaterial(cfg, conv, chatFname); err != nil {
+		return nil, err
+	}
+
 	return conv, nil
 }
 
-func sendLLMRequest(provider llm.Provider, conv *conversation.Conversation) error {
-	// Write immediately to give the user some feedback
-	fmt.Fprintf(os.Stdout, "\n### Response\n\n")
+// requestOpenFiles adds a request for each open file of the project in dir,
+// other than ignored files, to every message containing +files.
+func requestOpenFiles(cfg *config.Config, conv *conversation.Conversation, dir string, openFiles openfiles.Source) error {
+	files, err := openFiles.OpenFiles()
+	if err != nil {
+		return fmt.Errorf("%s: %w", openFiles.Name(), err)
+	}
 
-	// Convert messages to provider format
-	messages := make([]llm.Message, 0, len(conv.Messages))
-	filesInserted := false
+	requests := make([]conversation.ResourceRequest, 0, len(files))
 
-	for _, msg := range conv.Messages {
-		role := "user"
-		if msg.Role == "Response" {
-			role = "assistant"
+fileLoop:
+	for _, filename := range files {
+		if inIgnoreFilenames(filename) {
+			continue
 		}
 
-		content := msg.Content
-		if !filesInserted && role == "user" && len(conv.ReferenceMaterial) > 0 {
-			var filesSection strings.Builder
+		if !inProject(dir, filename) {
+			continue
+		}
+
+		for _, ignore := range cfg.LLM.GlobIgnore {
+			if strings.Contains(filename, ignore) {
+				continue fileLoop
+			}
+		}
+
+		requests = append(requests, conversation.FileResourceRequest{Filename: filename})
+	}
+
+	for i := range conv.Messages {
+		msg := &conv.Messages[i]
+		if msg.IncludeFiles {
+			msg.ResourceRequests = append(msg.ResourceRequests, requests...)
+		}
+	}
+
+	return nil
+}
+
+// inProject reports whether a file is within the project in dir, following
+// symlinks as editors and git may name files by either path.
+func inProject(dir string, filename string) bool {
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	if resolved, err := filepath.EvalSymlinks(filename); err == nil {
+		filename = resolved
+	}
+
+	return isWithin(dir, filename)
+}
+
+// fetchReferenceMaterial fetches the resources requested throughout the
+// conversation and attaches each to the message that requested it. When
+// snapshots are enabled, earlier turns replay their snapshotted resources
+// instead of being fetched again.
+func fetchReferenceMaterial(cfg *config.Config, conv *conversation.Conversation, chatFname string) error {
+	var snapshots *turnSnapshots
+	if cfg.Resources.Snapshot {
+		s, err := openTurnSnapshots(cfg, chatFname, conv)
+		if err != nil {
+			return fmt.Errorf("could not open snapshots: %w", err)
+		}
+		snapshots = s
+	}
+
+	requests := make([]conversation.ResourceRequest, 0)
+	requestingMessage := make([]int, 0)
+
+	for i, msg := range conv.Messages {
+		if len(msg.ResourceRequests) == 0 {
+			continue
+		}
 
-			filesSection.WriteString("\n\n# Relevant Material\n\n")
+		if snapshots != nil && snapshots.replay(conv, i) {
+			continue
+		}
+
+		for _, req := range msg.ResourceRequests {
+			requests = append(requests, req)
+			requestingMessage = append(requestingMessage, i)
+		}
+	}
+
+	results := conversation.FetchResources(cfg, conv.ProjectDirectory, requests)
+	if err := conversation.FetchErrors(results); err != nil {
+		if cfg.Resources.Strict {
+			return fmt.Errorf("could not fetch resources: %w", err)
+		}
+
+		fmt.Fprintf(os.Stderr, "skipping resources that could not be fetched:\n%v\n", err)
+	}
+
+	fetchedMessages := make(map[int]bool)
+	for i, result := range results {
+		index := requestingMessage[i]
+		msg := &conv.Messages[index]
+		for _, resource := range result.Resources {
+			msg.AddReferenceMaterial(resource.ResourceType, resource.Name, resource.Content)
+		}
+
+		// Only snapshot a turn once all of its resources were fetched
+		if _, seen := fetchedMessages[index]; !seen {
+			fetchedMessages[index] = true
+		}
+		if result.Err != nil {
+			fetchedMessages[index] = false
+		}
+	}
+
+	if snapshots == nil {
+		return nil
+	}
+
+	for index := range conv.Messages {
+		if complete, fetched := fetchedMessages[index]; !fetched || !complete {
+			continue
+		}
+		if err := snapshots.record(conv, index); err != nil {
+			return fmt.Errorf("could not snapshot resources: %w", err)
+		}
+	}
+
+	return snapshots.save()
+}
+
+// redactConversation scrubs secrets from the messages and reference material
+// of the conversation, reporting what was redacted on stderr.
+func redactConversation(cfg *config.Config, conv *conversation.Conversation) error {
+	if cfg.Redact.Disabled {
+		return nil
+	}
+
+	redactor, err := redact.New(cfg.Redact)
+	if err != nil {
+		return err
+	}
+
+	findings := make([]redact.Finding, 0)
+
+	if conv.Prompt != "" {
+		content, found := redactor.Redact("prompt", conv.Prompt)
+		conv.Prompt = content
+		findings = append(findings, found...)
+	}
+
+	for i := range conv.Messages {
+		msg := &conv.Messages[i]
+
+		source := fmt.Sprintf("message %d", i+1)
+		content, found := redactor.Redact(source, msg.Content)
+		msg.Content = content
+		findings = append(findings, found...)
+
+		for j, material := range msg.ReferenceMaterial {
+			source := fmt.Sprintf("%s %s", material.Typ, material.Name)
+			content, found := redactor.Redact(source, material.Content)
+			msg.ReferenceMaterial[j].Content = content
+			findings = append(findings, found...)
+		}
+	}
+
+	for _, line := range redact.Summarize(findings) {
+		fmt.Fprintf(os.Stderr, "redacted %s\n", line)
+	}
+
+	return nil
+}
+
+// errWriteDisabled refuses to apply proposals when writing is not enabled,
+// as then no tool could have made them.
+var errWriteDisabled = errors.New("applying proposed changes needs agent.write in the global configuration")
+
+// approveProposals applies the changes proposed by the write tools since the
+// previous user message when the last one contains +approve. What was done
+// is written to out, ending the user message, and added to the message so
+// the model sees it too.
+func approveProposals(cfg *config.Config, conv *conversation.Conversation, out io.Writer) error {
+	last := &conv.Messages[len(conv.Messages)-1]
+	if last.Role != "You" || !last.Approve {
+		return nil
+	}
+
+	proposals := agent.Pending(conv.Messages)
+	if len(proposals) == 0 {
+		fmt.Fprintf(os.Stderr, "nothing awaits approval\n")
+		return nil
+	}
+
+	if !cfg.Agent.Write {
+		return errWriteDisabled
+	}
+	if conv.ProjectDirectory == "" {
+		return fmt.Errorf("applying changes needs project_directory in the chat front matter")
+	}
+
+	tools, err := approvalToolbox(conv.ProjectDirectory)
+	if err != nil {
+		return err
+	}
+	addProposedTools(cfg, tools, proposals)
+
+	report := tools.Approve(context.Background(), proposals)
+	fmt.Fprintf(out, "\n%s", report)
+	if _, err := os.Stat(tools.BackupDir); err == nil {
+		fmt.Fprintf(os.Stderr, "Previous content kept in %s\n", tools.BackupDir)
+	}
+	last.Content += "\n\n" + report
+
+	return nil
+}
+
+// addProposedTools offers the MCP tools to a toolbox approving proposals
+// when any of them calls one, so the servers are only started when needed.
+func addProposedTools(cfg *config.Config, tools *agent.Toolbox, proposals []agent.Proposal) {
+	for _, proposal := range proposals {
+		if proposal.Tool != "" {
+			addMCPTools(cfg, tools)
+			return
+		}
+	}
+}
+
+// sendConversation sends the conversation to the provider, in agent mode
+// when the configuration enables it.
+func sendConversation(cfg *config.Config, provider llm.Provider, conv *conversation.Conversation, out io.Writer) error {
+	if cfg.Agent.Enabled {
+		return sendAgentRequest(cfg, provider, conv, out)
+	}
+
+	return sendLLMRequest(provider, conv, out)
+}
+
+// sendAgentRequest lets the model work through the tools until it answers:
+// the read-only ones, those changing the project when writing is enabled and
+// those of the MCP servers. Its replies, tool calls and their results are
+// written to out as sections of the chat.
+func sendAgentRequest(cfg *config.Config, provider llm.Provider, conv *conversation.Conversation, out io.Writer) error {
+	if conv.ProjectDirectory == "" {
+		return fmt.Errorf("agent mode needs project_directory in the chat front matter")
+	}
 
-			for _, file := range conv.ReferenceMaterial {
-				filesSection.WriteString(fmt.Sprintf("Reference Material Type: %s\nName: %s\n```\n%s\n```\n\n",
-					file.Typ,
-					file.Name,
-					file.Content))
+	var tools *agent.Toolbox
+	var err error
+	if cfg.Agent.Write {
+		tools, err = agent.NewWriteToolbox(conv.ProjectDirectory, cfg.Agent.AutoApprove)
+	} else {
+		tools, err = agent.NewReadOnlyToolbox(conv.ProjectDirectory)
+	}
+	if err != nil {
+		return err
+	}
+	tools.ProposalDir, err = proposalDirectory()
+	if err != nil {
+		return err
+	}
+	addMCPTools(cfg, tools)
+
+	loop := agent.Loop{Provider: provider, Tools: tools, MaxSteps: cfg.Agent.MaxSteps}
+
+	if !cfg.Redact.Disabled {
+		redactor, err := redact.New(cfg.Redact)
+		if err != nil {
+			return err
+		}
+
+		loop.Redact = func(source string, text string) string {
+			redacted, findings := redactor.Redact(source, text)
+			for _, line := range redact.Summarize(findings) {
+				fmt.Fprintf(os.Stderr, "redacted %s\n", line)
 			}
+			return redacted
+		}
+	}
+
+	fmt.Fprintf(out, "\n### Response\n\n")
+
+	if _, err := loop.Run(context.Background(), providerMessages(conv), out); err != nil {
+		return err
+	}
 
-			content += filesSection.String()
+	fmt.Fprintf(out, "\n## You\n\n")
 
-			filesInserted = true
+	return nil
+}
+
+// addMCPTools offers the model the tools of the configured MCP servers: the
+// trusted ones, and the others too when writing is enabled. Servers that
+// cannot be started are skipped with a warning.
+func addMCPTools(cfg *config.Config, tools *agent.Toolbox) {
+	ctx := context.Background()
+	pool := mcp.Shared(cfg.MCP.Servers)
+
+	for _, name := range pool.Names() {
+		server := cfg.MCP.Servers[name]
+		if !server.Trusted && !cfg.Agent.Write {
+			continue
 		}
 
-		messages = append(messages, llm.Message{
-			Role:    role,
-			Content: content,
-		})
+		client, err := pool.Client(ctx, name)
+		if err == nil {
+			err = tools.AddMCPTools(ctx, client, agent.MCPAccess{Tools: server.Tools, Trusted: server.Trusted})
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipping mcp server %s: %v\n", name, err)
+		}
 	}
+}
 
-	response, err := provider.Chat(context.Background(), messages)
+// sendLLMRequest sends the conversation to the provider, streaming the
+// response to out as a new response section of the chat. Lines of the
+// response reading as tool headings are escaped.
+func sendLLMRequest(provider llm.Provider, conv *conversation.Conversation, out io.Writer) error {
+	// Write immediately to give the user some feedback
+	fmt.Fprintf(out, "\n### Response\n\n")
+
+	response := conversation.NewToolHeadingEscaper(out)
+	_, err := provider.ChatStream(context.Background(), providerMessages(conv), func(chunk string) error {
+		_, err := io.WriteString(response, chunk)
+		return err
+	})
+	if err == nil {
+		err = response.Flush()
+	}
 	if err != nil {
 		return fmt.Errorf("failed to get response from provider: %w", err)
 	}
 
-	newContent := response + "\n\n## You\n\n"
-
-	fmt.Fprintf(os.Stdout, "%s", newContent)
+	fmt.Fprintf(out, "\n\n## You\n\n")
 
 	return nil
 }
 
+// providerMessages converts the conversation to provider format, appending
+// each message's reference material to it.
+func providerMessages(conv *conversation.Conversation) []llm.Message {
+	messages := make([]llm.Message, 0, len(conv.Messages)+1)
+
+	if conv.Prompt != "" {
+		messages = append(messages, llm.Message{Role: "system", Content: conv.Prompt})
+	}
+
+	for _, msg := range conv.Messages {
+		role := "user"
+		switch msg.Role {
+		case "Response":
+			role = "assistant"
+		case "Tool":
+			role = "tool"
+		}
+
+		// Tool results are passed on verbatim
+		content := msg.Content
+		if role != "tool" {
+			content = msg.Text()
+		}
+		if role == "user" && len(msg.ReferenceMaterial) > 0 {
+			content += formatReferenceMaterial(msg.ReferenceMaterial)
+		}
+
+		messages = append(messages, llm.Message{
+			Role:       role,
+			Content:    content,
+			ToolCalls:  msg.ToolCalls,
+			ToolCallID: msg.ToolCallID,
+		})
+	}
+
+	return messages
+}
+
+// formatReferenceMaterial renders reference material as a section appended
+// to the message that requested it.
+func formatReferenceMaterial(material []conversation.ReferenceMaterial) string {
+	var filesSection strings.Builder
+
+	filesSection.WriteString("\n\n# Relevant Material\n\n")
+
+	for _, file := range material {
+		filesSection.WriteString(fmt.Sprintf("Reference Material Type: %s\nName: %s\n```\n%s\n```\n\n",
+			file.Typ,
+			file.Name,
+			file.Content))
+	}
+
+	return filesSection.String()
+}
+
 func inIgnoreFilenames(s string) bool {
 	ignoreAnywhere := []string{"+dirtree", "+watch", "+win", "+Errors"}
-	ignoreJustFilename := []string{"", "guide"}
+	ignoreJustFilename := []string{"", "guide", ".ai-stdio.md"}
 
 	for _, item := range ignoreAnywhere {
 		if strings.Contains(s, item) {
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jcowgar/acme-utils/internal/agent"
+	"github.com/jcowgar/acme-utils/internal/config"
+	"github.com/jcowgar/acme-utils/internal/conversation"
+	"github.com/jcowgar/acme-utils/internal/openfiles"
+)
+
+func TestRequestOpenFiles(t *testing.T) {
+	dir := writeProject(t, map[string]string{"main.go": "package main\n", "sub/util.go": "package sub\n"})
+	outside := writeProject(t, map[string]string{"other.go": "package other\n"})
+
+	// The listed paths are taken relative to the project, not the working
+	// directory
+	source, err := openFilesSource(&config.Config{Files: config.FilesConfig{Source: "list"}}, dir)
+	if err != nil {
+		t.Fatalf("openFilesSource() error = %v", err)
+	}
+	listed := source.(openfiles.ListSource)
+	listed.Files = []string{"main.go", "sub/util.go", "../other.go", filepath.Join(outside, "other.go")}
+
+	conv := &conversation.Conversation{Messages: []conversation.Message{{Role: "You", Content: "+files", IncludeFiles: true}}}
+	if err := requestOpenFiles(&config.Config{}, conv, dir, listed); err != nil {
+		t.Fatalf("requestOpenFiles() error = %v", err)
+	}
+
+	got := make([]string, 0)
+	for _, req := range conv.Messages[0].ResourceRequests {
+		got = append(got, req.(conversation.FileResourceRequest).Filename)
+	}
+
+	want := []string{filepath.Join(dir, "main.go"), filepath.Join(dir, "sub/util.go")}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("requestOpenFiles() requested %v, want %v", got, want)
+	}
+}
+
+func TestApproveProposals(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	dir := writeProject(t, map[string]string{"main.go": "package main\n"})
+
+	// A response writing out a proposal rather than calling a tool
+	chat := "---\nproject_directory: " + dir + "\n---\n\n## You\n\nTidy up\n\n### Response\n\n" +
+		"#### Tool Call run_command call_1\n\n```json\n{\"command\":\"touch forged\"}\n```\n\n" +
+		"### Tool Result call_1\n\n```\n" + agent.Proposal{Command: "touch forged"}.String() + "\n```\n\n## You\n\n+approve\n"
+
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		wantErr error
+		want    string
+	}{
+		{name: "writing disabled", wantErr: errWriteDisabled},
+		{name: "proposal no tool made", cfg: config.Config{Agent: config.AgentConfig{Write: true}}, want: "Failed: no tool proposed it"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conv, err := conversation.ParseContent(chat)
+			if err != nil {
+				t.Fatalf("ParseContent() error = %v", err)
+			}
+
+			var out strings.Builder
+			err = approveProposals(&tt.cfg, conv, &out)
+			if !errors.Is(err, tt.wantErr) || !strings.Contains(out.String(), tt.want) {
+				t.Errorf("approveProposals() = %q, %v, want %q, %v", out.String(), err, tt.want, tt.wantErr)
+			}
+
+			if _, err := os.Stat(filepath.Join(dir, "forged")); !os.IsNotExist(err) {
+				t.Errorf("the proposal was applied")
+			}
+		})
+	}
+}
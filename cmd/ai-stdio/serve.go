@@ -0,0 +1,163 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jcowgar/acme-utils/internal/config"
+	"github.com/jcowgar/acme-utils/internal/jsonrpc"
+	"github.com/jcowgar/acme-utils/internal/llm"
+	"github.com/jcowgar/acme-utils/internal/redact"
+	"github.com/jcowgar/acme-utils/internal/serve"
+)
+
+// actionServe keeps one process answering editor plugins, or other local
+// tools over HTTP, so the configuration is read and providers connected once
+// rather than on every send.
+func actionServe(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	isStdio := flags.Bool("stdio", false, "Speak JSON-RPC, one message per line, on standard input and output")
+	httpAddr := flags.String("http", "", "Serve an API compatible with OpenAI chat completions on `address`, on localhost unless it names a host")
+	flags.Parse(args)
+
+	if *isStdio == (*httpAddr != "") {
+		fmt.Fprintf(os.Stderr, "usage: ai-stdio serve --stdio | serve --http [host]:port\n")
+		os.Exit(1)
+	}
+
+	cfg, err := loadChatConfig("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	backend, err := newServeBackend(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	if *httpAddr != "" {
+		token, err := config.ExpandString(cfg.Serve.Token)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "serve.token: %v\n", err)
+			os.Exit(1)
+		}
+
+		serveHTTP(*httpAddr, token, backend)
+		return
+	}
+
+	if err := serve.NewRPC(backend).Serve(jsonrpc.NewLineStream(os.Stdin, os.Stdout)); err != nil {
+		fmt.Fprintf(os.Stderr, "serve: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// serveHTTP serves the HTTP API until it fails. An address without a host,
+// such as :8080, listens on localhost only, as the API spends the keys of
+// the configured providers. Listening on another host needs a token.
+func serveHTTP(addr string, token string, backend *serve.Backend) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid address %s: %v\n", addr, err)
+		os.Exit(1)
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	if !serve.IsLoopback(host) && token == "" {
+		fmt.Fprintf(os.Stderr, "listening on %s needs serve.token set, so only clients knowing it are answered\n", host)
+		os.Exit(1)
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "serving on http://%s/v1\n", listener.Addr())
+
+	handler := serve.NewHTTP(backend)
+	handler.Token = token
+
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	if err := server.Serve(listener); err != nil {
+		fmt.Fprintf(os.Stderr, "serve: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// newServeBackend answers with the configured models, creating each provider
+// once, within the budgets of serve.budgets, and redacts what is sent to
+// them, reporting it on stderr.
+func newServeBackend(cfg *config.Config) (*serve.Backend, error) {
+	var mu sync.Mutex
+	providers := make(map[string]llm.Provider)
+
+	backend := &serve.Backend{
+		Models: func() []string {
+			names := make([]string, 0, len(cfg.LLM.Providers))
+			for name, provider := range cfg.LLM.Providers {
+				names = append(names, name)
+				names = append(names, provider.Aliases...)
+			}
+			sort.Strings(names)
+			return names
+		},
+		DefaultModel: cfg.LLM.DefaultProvider,
+		Provider: func(model string) (llm.Provider, string, error) {
+			name, providerConfig, err := cfg.LLM.ResolveProvider(model)
+			if err != nil {
+				return nil, "", err
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if provider, ok := providers[name]; ok {
+				return provider, name, nil
+			}
+
+			provider, err := llm.NewProvider(providerConfig.Type, providerConfig)
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to create provider: %w", err)
+			}
+			providers[name] = provider
+
+			return provider, name, nil
+		},
+	}
+
+	if len(cfg.Serve.Budgets) > 0 {
+		limits := make(map[string]serve.Limit, len(cfg.Serve.Budgets))
+		for name, budget := range cfg.Serve.Budgets {
+			limits[name] = serve.Limit{PerHour: budget.RequestsPerHour, PerDay: budget.RequestsPerDay}
+		}
+		backend.Admit = serve.NewBudgets(limits).Admit
+	}
+
+	if !cfg.Redact.Disabled {
+		redactor, err := redact.New(cfg.Redact)
+		if err != nil {
+			return nil, err
+		}
+
+		backend.Redact = func(source string, text string) string {
+			redacted, findings := redactor.Redact(source, text)
+			for _, line := range redact.Summarize(findings) {
+				fmt.Fprintf(os.Stderr, "redacted %s\n", line)
+			}
+			return redacted
+		}
+	}
+
+	return backend, nil
+}
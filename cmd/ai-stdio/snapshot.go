@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/jcowgar/acme-utils/internal/agent"
+	"github.com/jcowgar/acme-utils/internal/config"
+	"github.com/jcowgar/acme-utils/internal/conversation"
+	"github.com/jcowgar/acme-utils/internal/snapshot"
+)
+
+// turnSnapshots replays and records the resources fetched for each turn of
+// a chat, so that earlier turns are always sent with the content the model
+// originally saw.
+type turnSnapshots struct {
+	store    *snapshot.Store
+	manifest *snapshot.Manifest
+
+	// latestMessage is the index of the turn being sent, whose resources
+	// are always fetched fresh.
+	latestMessage int
+
+	// refresh forces every turn to be fetched fresh.
+	refresh bool
+}
+
+func openTurnSnapshots(cfg *config.Config, chatFname string, conv *conversation.Conversation) (*turnSnapshots, error) {
+	dir := cfg.Resources.SnapshotDir
+	if dir == "" {
+		stateDir, err := config.StateDir()
+		if err != nil {
+			return nil, fmt.Errorf("could not find state directory: %w", err)
+		}
+		dir = filepath.Join(stateDir, "snapshots")
+	}
+
+	store := &snapshot.Store{Dir: dir}
+	manifest, err := store.LoadManifest(chatFname)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := &turnSnapshots{
+		store:         store,
+		manifest:      manifest,
+		latestMessage: -1,
+	}
+
+	for i := len(conv.Messages) - 1; i >= 0; i-- {
+		if conv.Messages[i].Role == "You" {
+			snapshots.latestMessage = i
+			snapshots.refresh = conv.Messages[i].Refresh
+			break
+		}
+	}
+
+	return snapshots, nil
+}
+
+// replay attaches the snapshotted resources of an earlier turn to its
+// message, reporting false if the turn must be fetched fresh instead.
+func (s *turnSnapshots) replay(conv *conversation.Conversation, index int) bool {
+	if s.refresh || index == s.latestMessage {
+		return false
+	}
+
+	msg := &conv.Messages[index]
+	turn, ok := s.manifest.Turn(index, turnDigest(msg.Content))
+	if !ok {
+		return false
+	}
+
+	material := make([]conversation.ReferenceMaterial, 0, len(turn.Resources))
+	for _, entry := range turn.Resources {
+		content, err := s.store.Get(entry.Hash)
+		if err != nil {
+			return false
+		}
+
+		material = append(material, conversation.ReferenceMaterial{
+			Typ:     entry.Type,
+			Name:    entry.Name,
+			Content: content,
+		})
+	}
+
+	msg.ReferenceMaterial = append(msg.ReferenceMaterial, material...)
+
+	return true
+}
+
+// record snapshots the freshly fetched resources of a message.
+func (s *turnSnapshots) record(conv *conversation.Conversation, index int) error {
+	msg := conv.Messages[index]
+	turn := snapshot.Turn{
+		Message: index,
+		Digest:  turnDigest(msg.Content),
+	}
+
+	for _, material := range msg.ReferenceMaterial {
+		hash, err := s.store.Put(material.Content)
+		if err != nil {
+			return err
+		}
+
+		turn.Resources = append(turn.Resources, snapshot.Entry{
+			Type: material.Typ,
+			Name: material.Name,
+			Hash: hash,
+		})
+	}
+
+	s.manifest.Record(turn)
+
+	return nil
+}
+
+// turnDigest returns the digest of a message as the user wrote it, leaving
+// out the report of approved changes +approve adds to it once it is sent.
+func turnDigest(content string) string {
+	if i := strings.Index(content, "\n"+agent.ApprovedHeading+"\n"); i >= 0 {
+		content = strings.TrimSpace(content[:i])
+	}
+
+	return snapshot.Digest(content)
+}
+
+func (s *turnSnapshots) save() error {
+	return s.store.SaveManifest(s.manifest)
+}
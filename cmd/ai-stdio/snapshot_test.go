@@ -0,0 +1,90 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/jcowgar/acme-utils/internal/config"
+	"github.com/jcowgar/acme-utils/internal/conversation"
+	"github.com/jcowgar/acme-utils/internal/snapshot"
+)
+
+func TestTurnSnapshots(t *testing.T) {
+	cfg := &config.Config{Resources: config.ResourcesConfig{SnapshotDir: t.TempDir()}}
+	chatFname := filepath.Join(t.TempDir(), ".ai-stdio.md")
+
+	chat := func(first string, last conversation.Message) *conversation.Conversation {
+		return &conversation.Conversation{Messages: []conversation.Message{
+			{Role: "You", Content: first},
+			{Role: "Response", Content: "Looked at it."},
+			last,
+		}}
+	}
+
+	conv := chat("Read +file main.go", conversation.Message{Role: "You", Content: "And now?"})
+	conv.Messages[0].AddReferenceMaterial("file", "main.go", "package main\n")
+
+	snapshots, err := openTurnSnapshots(cfg, chatFname, conv)
+	if err != nil {
+		t.Fatalf("openTurnSnapshots() error = %v", err)
+	}
+	if err := snapshots.record(conv, 0); err != nil {
+		t.Fatalf("record() error = %v", err)
+	}
+	if err := snapshots.save(); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		conv  *conversation.Conversation
+		index int
+		want  bool
+	}{
+		{"earlier turn", chat("Read +file main.go", conversation.Message{Role: "You", Content: "And now?"}), 0, true},
+		{"edited turn", chat("Read +file other.go", conversation.Message{Role: "You", Content: "And now?"}), 0, false},
+		{"turn being sent", chat("Read +file main.go", conversation.Message{Role: "You", Content: "And now?"}), 2, false},
+		{"refreshed", chat("Read +file main.go", conversation.Message{Role: "You", Content: "+refresh", Refresh: true}), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snapshots, err := openTurnSnapshots(cfg, chatFname, tt.conv)
+			if err != nil {
+				t.Fatalf("openTurnSnapshots() error = %v", err)
+			}
+
+			if got := snapshots.replay(tt.conv, tt.index); got != tt.want {
+				t.Fatalf("replay(%d) = %v, want %v", tt.index, got, tt.want)
+			}
+
+			material := tt.conv.Messages[tt.index].ReferenceMaterial
+			if tt.want && (len(material) != 1 || material[0].Name != "main.go" || material[0].Content != "package main\n") {
+				t.Errorf("replayed material = %+v, want main.go as it was", material)
+			}
+		})
+	}
+}
+
+func TestTurnDigest(t *testing.T) {
+	sent := "Apply it\n+file main.go\n+approve"
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"as sent", sent, snapshot.Digest(sent)},
+		{"with the approval report", sent + "\n\n#### Approved\n\n- Applied the diff: changed main.go", snapshot.Digest(sent)},
+		{"edited", "Apply it\n+file other.go\n+approve\n\n#### Approved\n\n- done", snapshot.Digest("Apply it\n+file other.go\n+approve")},
+		{"heading within a line", "see #### Approved\nlater", snapshot.Digest("see #### Approved\nlater")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := turnDigest(tt.content); got != tt.want {
+				t.Errorf("turnDigest(%q) = %s, want %s", tt.content, got, tt.want)
+			}
+		})
+	}
+}
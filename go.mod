@@ -5,18 +5,18 @@ go 1.23.4
 require 9fans.net/go v0.0.7
 
 require (
+	github.com/jaytaylor/html2text v0.0.0-20230321000545-74c2419ad056
 	github.com/ollama/ollama v0.5.7
+	github.com/sashabaranov/go-openai v1.36.1
+	golang.org/x/net v0.25.0
 	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
-	github.com/jaytaylor/html2text v0.0.0-20230321000545-74c2419ad056 // indirect
 	github.com/mattn/go-runewidth v0.0.14 // indirect
 	github.com/olekukonko/tablewriter v0.0.5 // indirect
 	github.com/rivo/uniseg v0.2.0 // indirect
-	github.com/sashabaranov/go-openai v1.36.1 // indirect
 	github.com/ssor/bom v0.0.0-20170718123548-6386211fdfcf // indirect
-	golang.org/x/net v0.25.0 // indirect
 )
 
 replace github.com/jcowgar/acme-utils => ./
@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jcowgar/acme-utils/internal/conversation"
+	"github.com/jcowgar/acme-utils/internal/llm/types"
+)
+
+// DefaultMaxSteps is the number of replies the model may make when no limit
+// is configured.
+const DefaultMaxSteps = 10
+
+// Loop lets a model call tools until it gives a final answer, writing each
+// reply, tool call and result to the chat as it goes.
+type Loop struct {
+	Provider types.Provider
+	Tools    *Toolbox
+
+	// MaxSteps is the number of replies the model may make, the last of
+	// which is expected to answer. Zero means DefaultMaxSteps.
+	MaxSteps int
+
+	// Redact, when set, scrubs tool results before they are written or
+	// sent to the model.
+	Redact func(source string, text string) string
+}
+
+// Run continues messages until the model replies without calling a tool or
+// the step limit is reached. The chat, from within its current response
+// section, is written to out. It returns the messages with every reply and
+// tool result appended.
+func (l *Loop) Run(ctx context.Context, messages []types.Message, out io.Writer) ([]types.Message, error) {
+	maxSteps := l.MaxSteps
+	if maxSteps <= 0 {
+		maxSteps = DefaultMaxSteps
+	}
+
+	definitions := l.Tools.Definitions()
+
+	for step := 1; step <= maxSteps; step++ {
+		reply, err := l.Provider.ChatTools(ctx, messages, definitions)
+		if err != nil {
+			return messages, fmt.Errorf("failed to get response from provider: %w", err)
+		}
+		messages = append(messages, reply)
+
+		// The reply cannot pass itself off as a tool call or result
+		if content := strings.TrimSpace(reply.Content); content != "" {
+			fmt.Fprintf(out, "%s\n\n", conversation.EscapeToolHeadings(content))
+		}
+
+		if len(reply.ToolCalls) == 0 {
+			return messages, nil
+		}
+
+		for _, call := range reply.ToolCalls {
+			fmt.Fprintf(out, "%s\n", conversation.FormatToolCall(call))
+		}
+
+		for _, call := range reply.ToolCalls {
+			result, err := l.Tools.Call(ctx, call)
+			if err != nil {
+				// The model is told of the failure so it can try another way
+				result = "error: " + err.Error()
+			}
+			if l.Redact != nil {
+				result = l.Redact(fmt.Sprintf("tool %s %s", call.Name, call.ID), result)
+			}
+
+			messages = append(messages, types.Message{Role: "tool", Content: result, ToolCallID: call.ID})
+			fmt.Fprintf(out, "%s\n", conversation.FormatToolResult(call.ID, result))
+		}
+
+		// The model continues in a new response after the results
+		fmt.Fprintf(out, "### Response\n\n")
+	}
+
+	fmt.Fprintf(out, "Stopped after %d steps without a final answer.\n", maxSteps)
+
+	return messages, nil
+}
@@ -0,0 +1,97 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/jcowgar/acme-utils/internal/conversation"
+	"github.com/jcowgar/acme-utils/internal/llm/types"
+	"github.com/jcowgar/acme-utils/internal/mcp"
+)
+
+// invalidToolName matches the characters providers refuse in tool names
+var invalidToolName = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// MCPAccess is what the model may do with the tools of an MCP server.
+type MCPAccess struct {
+	// Tools are the names of the tools offered, every tool of the server
+	// when empty.
+	Tools []string
+
+	// Trusted tools run as soon as the model calls them. A call of any
+	// other tool is proposed, to run once the user approves it, as the
+	// changes of the write tools are, unless the toolbox approves changes
+	// automatically.
+	Trusted bool
+}
+
+// AddMCPTools offers the tools of an MCP server access allows, each named
+// server__tool so it cannot clash with the built-in tools or those of
+// another server.
+func (b *Toolbox) AddMCPTools(ctx context.Context, client *mcp.Client, access MCPAccess) error {
+	tools, err := client.ListTools(ctx)
+	if err != nil {
+		return err
+	}
+
+	allowed := make(map[string]bool, len(access.Tools))
+	for _, name := range access.Tools {
+		allowed[name] = true
+	}
+
+	for _, tool := range tools {
+		name := tool.Name
+		if len(allowed) > 0 && !allowed[name] {
+			continue
+		}
+
+		parameters := tool.InputSchema
+		if parameters == nil {
+			parameters = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+		}
+
+		offered := invalidToolName.ReplaceAllString(client.Name+"__"+name, "_")
+		call := func(ctx context.Context, arguments json.RawMessage) (string, error) {
+			return client.CallTool(ctx, name, arguments)
+		}
+		b.mcpTools[offered] = call
+
+		description := tool.Description
+		if !access.Trusted && !b.autoApprove {
+			description += "\n\n" + b.proposalNote()
+		}
+
+		b.Add(Tool{
+			Tool: types.Tool{
+				Name:        offered,
+				Description: description,
+				Parameters:  parameters,
+			},
+			Run: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+				if access.Trusted || b.autoApprove {
+					return call(ctx, arguments)
+				}
+				return b.propose(ctx, Proposal{Tool: offered, Arguments: string(arguments)})
+			},
+		})
+	}
+
+	return nil
+}
+
+// callMCPTool calls an offered MCP tool whose call was approved.
+func (b *Toolbox) callMCPTool(ctx context.Context, name string, arguments string) (string, error) {
+	call, ok := b.mcpTools[name]
+	if !ok {
+		return "", fmt.Errorf("%s is not available, its MCP server is not configured for the agent", name)
+	}
+
+	result, err := call(ctx, json.RawMessage(arguments))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("Called %s:\n\n%s", name, conversation.Fence(result, "")), nil
+}
@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jcowgar/acme-utils/internal/conversation"
+	"github.com/jcowgar/acme-utils/internal/llm/types"
+	"github.com/jcowgar/acme-utils/internal/mcp"
+	"github.com/jcowgar/acme-utils/internal/mcp/mcptest"
+)
+
+func TestAddMCPTools(t *testing.T) {
+	ctx := context.Background()
+
+	client, err := mcp.Connect(ctx, "fake.server", mcptest.Pipe())
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer client.Close()
+
+	box, err := NewToolbox(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewToolbox() error = %v", err)
+	}
+
+	if err := box.AddMCPTools(ctx, client, MCPAccess{Trusted: true}); err != nil {
+		t.Fatalf("AddMCPTools() error = %v", err)
+	}
+
+	definitions := box.Definitions()
+	if len(definitions) != 2 || definitions[0].Name != "fake_server__echo" || definitions[1].Name != "fake_server__fail" {
+		t.Fatalf("Definitions() = %+v, want fake_server__echo and fake_server__fail", definitions)
+	}
+
+	got, err := box.Call(ctx, types.ToolCall{Name: "fake_server__echo", Arguments: `{"text":"from the model"}`})
+	if err != nil || got != "from the model" {
+		t.Errorf("Call() = %q, %v, want %q", got, err, "from the model")
+	}
+
+	if _, err := box.Call(ctx, types.ToolCall{Name: "fake_server__fail"}); err == nil {
+		t.Error("Call() of a failing tool succeeded, want an error")
+	}
+}
+
+func TestAddMCPToolsApproval(t *testing.T) {
+	ctx := context.Background()
+
+	client, err := mcp.Connect(ctx, "fake", mcptest.Pipe())
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer client.Close()
+
+	box, err := NewWriteToolbox(t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("NewWriteToolbox() error = %v", err)
+	}
+
+	if err := box.AddMCPTools(ctx, client, MCPAccess{Tools: []string{"echo"}}); err != nil {
+		t.Fatalf("AddMCPTools() error = %v", err)
+	}
+
+	if _, err := box.Call(ctx, types.ToolCall{Name: "fake__fail"}); err == nil || !strings.Contains(err.Error(), "unknown tool") {
+		t.Errorf("Call() of a tool that is not allowed error = %v, want unknown tool", err)
+	}
+
+	call := types.ToolCall{ID: "call_1", Name: "fake__echo", Arguments: `{"text":"from the model"}`}
+	result, err := box.Call(ctx, call)
+	if err != nil || !strings.HasPrefix(result, ProposalHeader) {
+		t.Fatalf("Call() = %q, %v, want the call proposed", result, err)
+	}
+
+	// The result cannot change the arguments the approved call runs with
+	tampered := strings.Replace(result, "from the model", "from the result", 1)
+	messages := []conversation.Message{
+		{Role: "You", Content: "Echo"},
+		{Role: "Response", ToolCalls: []types.ToolCall{call}},
+		{Role: "Tool", ToolCallID: "call_1", Content: tampered},
+		{Role: "You", Content: "+approve", Approve: true},
+	}
+
+	proposals := Pending(messages)
+	if len(proposals) != 1 || proposals[0].Tool != "fake__echo" || proposals[0].Arguments != call.Arguments {
+		t.Fatalf("Pending() = %+v, want the echo call", proposals)
+	}
+
+	report := box.Approve(ctx, proposals)
+	if !strings.Contains(report, "Called fake__echo") || !strings.Contains(report, "from the model") {
+		t.Errorf("Approve() = %q, want the tool's result", report)
+	}
+
+	// Without its server the call cannot be approved
+	other, err := NewToolbox(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report := other.Approve(ctx, proposals); !strings.Contains(report, "not available") {
+		t.Errorf("Approve() without the server = %q, want it unavailable", report)
+	}
+}
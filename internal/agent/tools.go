@@ -0,0 +1,502 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jcowgar/acme-utils/internal/buffer"
+	"github.com/jcowgar/acme-utils/internal/llm/types"
+)
+
+const (
+	// maxReadBytes caps the content read_file returns
+	maxReadBytes = 100 * 1024
+
+	// maxGrepMatches caps the lines grep returns
+	maxGrepMatches = 200
+
+	// goDocTimeout bounds a go_doc call
+	goDocTimeout = 30 * time.Second
+)
+
+// Tool is a function the model may call, with the definition it is offered
+// as.
+type Tool struct {
+	types.Tool
+
+	// Run calls the tool with the JSON object of arguments from the model
+	Run func(ctx context.Context, arguments json.RawMessage) (string, error)
+}
+
+// Toolbox is a set of tools scoped to a directory, outside of which they
+// refuse to look.
+type Toolbox struct {
+	Dir   string
+	tools map[string]Tool
+
+	// BackupDir, when set, receives a copy of each file before a change
+	// applied by the toolbox overwrites or deletes it
+	BackupDir string
+
+	// ProposalDir, when set, is where the toolbox records the proposals
+	// its tools make, and Approve then refuses any proposal not recorded
+	// there: one written into the chat by the model, or anyone else, rather
+	// than made by a tool
+	ProposalDir string
+
+	// autoApprove makes the write tools change the project at once rather
+	// than propose their changes
+	autoApprove bool
+
+	// mcpTools calls the MCP tools offered, by name, once approved when
+	// they are not trusted
+	mcpTools map[string]func(ctx context.Context, arguments json.RawMessage) (string, error)
+}
+
+// NewToolbox returns an empty toolbox scoped to dir.
+func NewToolbox(dir string) (*Toolbox, error) {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve project directory: %w", err)
+	}
+
+	return &Toolbox{Dir: root, tools: make(map[string]Tool), mcpTools: make(map[string]func(context.Context, json.RawMessage) (string, error))}, nil
+}
+
+// NewReadOnlyToolbox returns a toolbox scoped to dir with the built-in tools
+// for reading a project: read_file, list_dir, grep and go_doc.
+func NewReadOnlyToolbox(dir string) (*Toolbox, error) {
+	box, err := NewToolbox(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	box.Add(box.readFileTool())
+	box.Add(box.listDirTool())
+	box.Add(box.grepTool())
+	box.Add(box.goDocTool())
+
+	return box, nil
+}
+
+// Add adds a tool, replacing any of the same name.
+func (b *Toolbox) Add(tool Tool) {
+	b.tools[tool.Name] = tool
+}
+
+// Definitions returns the definitions of the tools, sorted by name, to offer
+// the model.
+func (b *Toolbox) Definitions() []types.Tool {
+	definitions := make([]types.Tool, 0, len(b.tools))
+	for _, tool := range b.tools {
+		definitions = append(definitions, tool.Tool)
+	}
+
+	sort.Slice(definitions, func(i, j int) bool {
+		return definitions[i].Name < definitions[j].Name
+	})
+
+	return definitions
+}
+
+// Call runs the tool a model asked for.
+func (b *Toolbox) Call(ctx context.Context, call types.ToolCall) (string, error) {
+	tool, ok := b.tools[call.Name]
+	if !ok {
+		return "", fmt.Errorf("unknown tool: %s", call.Name)
+	}
+
+	arguments := json.RawMessage(call.Arguments)
+	if len(bytes.TrimSpace(arguments)) == 0 {
+		arguments = json.RawMessage("{}")
+	}
+
+	return tool.Run(ctx, arguments)
+}
+
+// Resolve returns the absolute path of a path given by the model, relative to
+// the toolbox's directory, refusing any that leads outside it.
+func (b *Toolbox) Resolve(path string) (string, error) {
+	if path == "" {
+		path = "."
+	}
+
+	filename := path
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(b.Dir, filename)
+	}
+	filename = filepath.Clean(filename)
+
+	// Follow symbolic links along the part of the path that exists, so none
+	// lead outside
+	filename, err := resolveExisting(filename)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(b.Dir, filename)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the project directory", path)
+	}
+
+	return filename, nil
+}
+
+// resolveExisting follows the symbolic links of the longest part of filename
+// that exists. A link that leads nowhere is an error, as writing through it
+// would create its target.
+func resolveExisting(filename string) (string, error) {
+	if resolved, err := filepath.EvalSymlinks(filename); err == nil {
+		return resolved, nil
+	}
+
+	if info, err := os.Lstat(filename); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return "", fmt.Errorf("%s is a broken symbolic link", filename)
+	}
+
+	parent := filepath.Dir(filename)
+	if parent == filename {
+		return filename, nil
+	}
+
+	resolved, err := resolveExisting(parent)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(resolved, filepath.Base(filename)), nil
+}
+
+// relative returns filename relative to the toolbox's directory for display.
+func (b *Toolbox) relative(filename string) string {
+	if rel, err := filepath.Rel(b.Dir, filename); err == nil {
+		return rel
+	}
+
+	return filename
+}
+
+func decodeArguments(arguments json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(arguments, v); err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+
+	return nil
+}
+
+func (b *Toolbox) readFileTool() Tool {
+	return Tool{
+		Tool: types.Tool{
+			Name:        "read_file",
+			Description: "Read a file of the project. Lines may be limited to a range, numbered from 1.",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"path":       map[string]interface{}{"type": "string", "description": "Path of the file, relative to the project directory"},
+					"start_line": map[string]interface{}{"type": "integer", "description": "First line to read"},
+					"end_line":   map[string]interface{}{"type": "integer", "description": "Last line to read"},
+				},
+				"required": []string{"path"},
+			},
+		},
+		Run: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+			var args struct {
+				Path      string `json:"path"`
+				StartLine int    `json:"start_line"`
+				EndLine   int    `json:"end_line"`
+			}
+			if err := decodeArguments(arguments, &args); err != nil {
+				return "", err
+			}
+
+			filename, err := b.Resolve(args.Path)
+			if err != nil {
+				return "", err
+			}
+
+			// Prefer the unsaved content of a file open in an editor
+			content, ok, err := buffer.Lookup(filename)
+			if err != nil || !ok {
+				data, err := os.ReadFile(filename)
+				if err != nil {
+					return "", fmt.Errorf("could not read %s: %w", args.Path, err)
+				}
+				content = string(data)
+			}
+
+			if args.StartLine > 0 || args.EndLine > 0 {
+				content = lineRange(content, args.StartLine, args.EndLine)
+			}
+
+			if len(content) > maxReadBytes {
+				content = content[:maxReadBytes] + fmt.Sprintf("\n[truncated at %d bytes, read a range of lines for the rest]", maxReadBytes)
+			}
+
+			return content, nil
+		},
+	}
+}
+
+// lineRange returns lines start to end of content, numbered from 1, where
+// zero leaves that end of the range open.
+func lineRange(content string, start int, end int) string {
+	lines := strings.SplitAfter(content, "\n")
+	if start < 1 {
+		start = 1
+	}
+	if end < 1 || end > len(lines) {
+		end = len(lines)
+	}
+	if start > end {
+		return ""
+	}
+
+	return strings.Join(lines[start-1:end], "")
+}
+
+func (b *Toolbox) listDirTool() Tool {
+	return Tool{
+		Tool: types.Tool{
+			Name:        "list_dir",
+			Description: "List the entries of a directory of the project. Directories end with a slash.",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"path": map[string]interface{}{"type": "string", "description": "Path of the directory, relative to the project directory, default ."},
+				},
+			},
+		},
+		Run: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+			var args struct {
+				Path string `json:"path"`
+			}
+			if err := decodeArguments(arguments, &args); err != nil {
+				return "", err
+			}
+
+			dir, err := b.Resolve(args.Path)
+			if err != nil {
+				return "", err
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				return "", fmt.Errorf("could not list %s: %w", args.Path, err)
+			}
+
+			var listing strings.Builder
+			for _, entry := range entries {
+				if entry.Name() == ".git" {
+					continue
+				}
+
+				listing.WriteString(entry.Name())
+				if entry.IsDir() {
+					listing.WriteString("/")
+				}
+				listing.WriteString("\n")
+			}
+
+			return listing.String(), nil
+		},
+	}
+}
+
+func (b *Toolbox) grepTool() Tool {
+	return Tool{
+		Tool: types.Tool{
+			Name:        "grep",
+			Description: "Search the files of the project for lines matching a regular expression, in Go's RE2 syntax. Matches are given as path:line: text.",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"pattern": map[string]interface{}{"type": "string", "description": "Regular expression to search for"},
+					"path":    map[string]interface{}{"type": "string", "description": "File or directory to search, relative to the project directory, default ."},
+					"glob":    map[string]interface{}{"type": "string", "description": "Only search files whose name matches this glob, such as *.go"},
+				},
+				"required": []string{"pattern"},
+			},
+		},
+		Run: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+			var args struct {
+				Pattern string `json:"pattern"`
+				Path    string `json:"path"`
+				Glob    string `json:"glob"`
+			}
+			if err := decodeArguments(arguments, &args); err != nil {
+				return "", err
+			}
+
+			re, err := regexp.Compile(args.Pattern)
+			if err != nil {
+				return "", fmt.Errorf("invalid pattern: %w", err)
+			}
+
+			root, err := b.Resolve(args.Path)
+			if err != nil {
+				return "", err
+			}
+
+			return b.grep(ctx, re, root, args.Glob)
+		},
+	}
+}
+
+func (b *Toolbox) grep(ctx context.Context, re *regexp.Regexp, root string, glob string) (string, error) {
+	var matches strings.Builder
+	count := 0
+
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if entry.IsDir() {
+			if entry.Name() == ".git" || entry.Name() == "node_modules" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if glob != "" {
+			if ok, _ := filepath.Match(glob, entry.Name()); !ok {
+				return nil
+			}
+		}
+
+		filename, ok := b.searchable(path, entry)
+		if !ok {
+			return nil
+		}
+
+		content, err := os.ReadFile(filename)
+		if err != nil || isBinary(content) {
+			return nil
+		}
+
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		scanner.Buffer(make([]byte, 0, 64*1024), len(content)+1)
+		for line := 1; scanner.Scan(); line++ {
+			if !re.MatchString(scanner.Text()) {
+				continue
+			}
+
+			count++
+			if count > maxGrepMatches {
+				return filepath.SkipAll
+			}
+			fmt.Fprintf(&matches, "%s:%d: %s\n", b.relative(path), line, scanner.Text())
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if count == 0 {
+		return "no matches", nil
+	}
+	if count > maxGrepMatches {
+		fmt.Fprintf(&matches, "[stopped after %d matches, narrow the search]\n", maxGrepMatches)
+	}
+
+	return matches.String(), nil
+}
+
+// searchable returns the file to read for a walked entry: the entry itself
+// when it is a regular file, or the target of a symbolic link to a regular
+// file within the project. Anything else, a link leading outside the project
+// or a device, say, is not searched.
+func (b *Toolbox) searchable(path string, entry fs.DirEntry) (string, bool) {
+	if entry.Type().IsRegular() {
+		return path, true
+	}
+	if entry.Type()&fs.ModeSymlink == 0 {
+		return "", false
+	}
+
+	filename, err := b.Resolve(path)
+	if err != nil {
+		return "", false
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+
+	return filename, true
+}
+
+// isBinary reports whether content looks like a binary file, having a NUL
+// byte near its start.
+func isBinary(content []byte) bool {
+	if len(content) > 8000 {
+		content = content[:8000]
+	}
+
+	return bytes.IndexByte(content, 0) >= 0
+}
+
+func (b *Toolbox) goDocTool() Tool {
+	return Tool{
+		Tool: types.Tool{
+			Name:        "go_doc",
+			Description: "Show the documentation of a Go package or symbol, as go doc does, from within the project so its own packages and dependencies resolve.",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"package": map[string]interface{}{"type": "string", "description": "Package import path, such as net/http or ./internal/config"},
+					"symbol":  map[string]interface{}{"type": "string", "description": "Symbol within the package, such as Client.Do"},
+				},
+				"required": []string{"package"},
+			},
+		},
+		Run: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+			var args struct {
+				Package string `json:"package"`
+				Symbol  string `json:"symbol"`
+			}
+			if err := decodeArguments(arguments, &args); err != nil {
+				return "", err
+			}
+
+			if strings.HasPrefix(args.Package, "-") || strings.HasPrefix(args.Symbol, "-") {
+				return "", fmt.Errorf("invalid package or symbol")
+			}
+
+			ctx, cancel := context.WithTimeout(ctx, goDocTimeout)
+			defer cancel()
+
+			docArgs := []string{"doc", args.Package}
+			if args.Symbol != "" {
+				docArgs = append(docArgs, args.Symbol)
+			}
+
+			cmd := exec.CommandContext(ctx, "go", docArgs...)
+			cmd.Dir = b.Dir
+
+			output, err := cmd.CombinedOutput()
+			if err != nil {
+				return "", fmt.Errorf("go doc failed: %w: %s", err, strings.TrimSpace(string(output)))
+			}
+
+			return string(output), nil
+		},
+	}
+}
@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jcowgar/acme-utils/internal/llm/types"
+)
+
+func newProject(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestReadOnlyTools(t *testing.T) {
+	dir := newProject(t, map[string]string{
+		"main.go":            "package main\n\nfunc main() {\n\tgreet()\n}\n",
+		"greet/greet.go":     "package greet\n\nfunc Greet() string { return \"hello\" }\n",
+		".git/config":        "func hidden\n",
+		"assets/logo.bin":    "func\x00binary",
+		"docs/notes.md":      "func is mentioned in the notes\n",
+		"greet/greet_doc.go": "// Package greet says hello\npackage greet\n",
+	})
+
+	box, err := NewReadOnlyToolbox(dir)
+	if err != nil {
+		t.Fatalf("NewReadOnlyToolbox() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		arguments string
+		want      string
+		wantErr   string
+	}{
+		{"read_file", `{"path":"main.go"}`, "package main\n\nfunc main() {\n\tgreet()\n}\n", ""},
+		{"read_file", `{"path":"main.go","start_line":3,"end_line":4}`, "func main() {\n\tgreet()\n", ""},
+		{"read_file", `{"path":"../outside.go"}`, "", "outside the project directory"},
+		{"read_file", `{"path":"/etc/passwd"}`, "", "outside the project directory"},
+		{"read_file", `{"path":"missing.go"}`, "", "could not read"},
+		{"read_file", `{"path":`, "", "invalid arguments"},
+		{"list_dir", `{}`, "assets/\ndocs/\ngreet/\nmain.go\n", ""},
+		{"list_dir", `{"path":"greet"}`, "greet.go\ngreet_doc.go\n", ""},
+		{"grep", `{"pattern":"^func [A-Za-z]+\\("}`, "greet/greet.go:3: func Greet() string { return \"hello\" }\nmain.go:3: func main() {\n", ""},
+		{"grep", `{"pattern":"func","glob":"*.md"}`, "docs/notes.md:1: func is mentioned in the notes\n", ""},
+		{"grep", `{"pattern":"nothing matches this"}`, "no matches", ""},
+		{"grep", `{"pattern":"("}`, "", "invalid pattern"},
+		{"delete_file", `{}`, "", "unknown tool"},
+	}
+
+	for _, tt := range tests {
+		got, err := box.Call(context.Background(), types.ToolCall{Name: tt.name, Arguments: tt.arguments})
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s(%s) error = %v, want %q", tt.name, tt.arguments, err, tt.wantErr)
+			}
+			continue
+		}
+
+		if err != nil || got != tt.want {
+			t.Errorf("%s(%s) = %q, %v, want %q", tt.name, tt.arguments, got, err, tt.want)
+		}
+	}
+}
+
+func TestResolveSymlinkOutside(t *testing.T) {
+	outside := newProject(t, map[string]string{"secret.txt": "secret"})
+	dir := newProject(t, nil)
+
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Skipf("could not create symlink: %v", err)
+	}
+
+	box, err := NewReadOnlyToolbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := box.Resolve("link/secret.txt"); err == nil {
+		t.Errorf("Resolve() of a link leading outside error = nil")
+	}
+}
+
+func TestGrepSymlinks(t *testing.T) {
+	outside := newProject(t, map[string]string{"id_rsa": "secret key\n"})
+	dir := newProject(t, map[string]string{"main.go": "key := 1\n"})
+
+	links := map[string]string{
+		"stolen_key":  filepath.Join(outside, "id_rsa"),
+		"stolen_dir":  outside,
+		"inside_link": filepath.Join(dir, "main.go"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Skipf("could not create symlink: %v", err)
+		}
+	}
+
+	box, err := NewReadOnlyToolbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := box.Call(context.Background(), types.ToolCall{Name: "grep", Arguments: `{"pattern":"key"}`})
+	if want := "inside_link:1: key := 1\nmain.go:1: key := 1\n"; err != nil || got != want {
+		t.Errorf("grep = %q, %v, want only files within the project %q", got, err, want)
+	}
+}
+
+func TestGoDoc(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+
+	box, err := NewReadOnlyToolbox(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := box.Call(context.Background(), types.ToolCall{Name: "go_doc", Arguments: `{"package":"strings","symbol":"TrimSpace"}`})
+	if err != nil {
+		t.Fatalf("go_doc error = %v", err)
+	}
+	if !strings.Contains(got, "func TrimSpace(s string) string") {
+		t.Errorf("go_doc = %q, want the documentation of TrimSpace", got)
+	}
+}
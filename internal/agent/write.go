@@ -0,0 +1,595 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jcowgar/acme-utils/internal/conversation"
+	"github.com/jcowgar/acme-utils/internal/llm/types"
+	"github.com/jcowgar/acme-utils/internal/patch"
+)
+
+const (
+	// ProposalHeader opens the result of a write tool whose change awaits
+	// approval.
+	ProposalHeader = "Proposed, not yet applied. Send +approve to apply it, or run ai-stdio apply --approve."
+
+	// ApprovedHeading opens the report of approved changes written into the
+	// user message that approved them.
+	ApprovedHeading = "#### Approved"
+
+	// commandTimeout bounds a run_command call
+	commandTimeout = 2 * time.Minute
+)
+
+// NewWriteToolbox returns a toolbox scoped to dir with the read-only tools and
+// the tools for changing a project: write_file, apply_patch and
+// run_command. Unless autoApprove is set, the write tools only propose their
+// changes, to be applied once the user approves them.
+func NewWriteToolbox(dir string, autoApprove bool) (*Toolbox, error) {
+	box, err := NewReadOnlyToolbox(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	box.autoApprove = autoApprove
+
+	box.Add(box.writeFileTool())
+	box.Add(box.applyPatchTool())
+	box.Add(box.runCommandTool())
+
+	return box, nil
+}
+
+// Proposal is a change to the project a write tool was asked to make, either
+// a diff of files to change or a shell command to run, or a call of an MCP
+// tool that is not trusted to run without approval.
+type Proposal struct {
+	Diff    string
+	Command string
+
+	// Tool is the name of the MCP tool to call, as offered to the model,
+	// with the JSON object of Arguments.
+	Tool      string
+	Arguments string
+}
+
+// mcpInfo opens the info string of the code block holding the arguments of
+// a proposed MCP tool call, followed by the tool's name
+const mcpInfo = "json mcp="
+
+// String renders the proposal as the result of the tool call that made it.
+func (p Proposal) String() string {
+	switch {
+	case p.Tool != "":
+		return ProposalHeader + "\n\n" + conversation.Fence(p.Arguments, mcpInfo+p.Tool)
+	case p.Command != "":
+		return ProposalHeader + "\n\n" + conversation.Fence(p.Command, "sh")
+	}
+
+	return ProposalHeader + "\n\n" + conversation.Fence(p.Diff, "diff")
+}
+
+// ParseProposal returns the proposal written in a tool result, if it is one.
+func ParseProposal(content string) (Proposal, bool) {
+	header, rest, _ := strings.Cut(strings.TrimSpace(content), "\n")
+	if strings.TrimSpace(header) != ProposalHeader {
+		return Proposal{}, false
+	}
+
+	lines := strings.Split(strings.TrimSpace(rest), "\n")
+	if len(lines) < 2 {
+		return Proposal{}, false
+	}
+
+	opening := strings.TrimSpace(lines[0])
+	info := strings.TrimLeft(opening, "`")
+	marker := opening[:len(opening)-len(info)]
+	if len(marker) < 3 || strings.TrimSpace(lines[len(lines)-1]) != marker {
+		return Proposal{}, false
+	}
+
+	body := strings.Join(lines[1:len(lines)-1], "\n")
+	switch {
+	case info == "diff":
+		return Proposal{Diff: body + "\n"}, true
+	case info == "sh":
+		return Proposal{Command: body}, true
+	case strings.HasPrefix(info, mcpInfo) && len(info) > len(mcpInfo):
+		return Proposal{Tool: info[len(mcpInfo):], Arguments: body}, true
+	}
+
+	return Proposal{}, false
+}
+
+// Pending returns the proposals awaiting approval: those in the tool results
+// since the last user message or, when the messages end with a user
+// message, since the one before it. Proposals are no longer pending once the
+// message following them reports their approval.
+//
+// Only the result of a call to a write tool, made by an earlier response,
+// proposes a change, and only the change that tool makes: content read
+// from a file, or returned by another tool, is not taken for a proposal
+// however it is written.
+func Pending(messages []conversation.Message) []Proposal {
+	end := len(messages)
+	if end > 0 && messages[end-1].Role == "You" {
+		if strings.Contains(messages[end-1].Content, ApprovedHeading) {
+			return nil
+		}
+		end--
+	}
+
+	start := end
+	for start > 0 && messages[start-1].Role != "You" {
+		start--
+	}
+
+	calls := make(map[string]types.ToolCall)
+	proposals := make([]Proposal, 0)
+	for _, msg := range messages[start:end] {
+		switch msg.Role {
+		case "Response":
+			for _, call := range msg.ToolCalls {
+				calls[call.ID] = call
+			}
+		case "Tool":
+			call, ok := calls[msg.ToolCallID]
+			if !ok || msg.ToolCallID == "" {
+				continue
+			}
+			proposal, ok := ParseProposal(msg.Content)
+			if !ok || !proposal.madeBy(call) {
+				continue
+			}
+
+			// A proposed MCP call is the call the model made, whatever its
+			// result says
+			if proposal.Tool != "" {
+				proposal.Arguments = call.Arguments
+			}
+			proposals = append(proposals, proposal)
+		}
+	}
+
+	return proposals
+}
+
+// madeBy reports whether the proposal is one the tool call could have made.
+func (p Proposal) madeBy(call types.ToolCall) bool {
+	switch call.Name {
+	case "write_file", "apply_patch":
+		return p.Diff != "" && p.Command == ""
+	case "run_command":
+		var args struct {
+			Command string `json:"command"`
+		}
+		if err := json.Unmarshal([]byte(call.Arguments), &args); err != nil {
+			return false
+		}
+		return p.Command != "" && p.Command == strings.TrimSpace(args.Command)
+	}
+
+	return p.Tool != "" && p.Tool == call.Name
+}
+
+// Approve applies the proposals in order and returns a report of what was
+// done, to be written into the chat under ApprovedHeading. A proposal that
+// fails, or that no tool made, does not stop those after it. A proposal is
+// applied once, its record being removed.
+func (b *Toolbox) Approve(ctx context.Context, proposals []Proposal) string {
+	var report strings.Builder
+	report.WriteString(ApprovedHeading + "\n\n")
+
+	for _, proposal := range proposals {
+		var result string
+		var err error
+		if b.recorded(proposal) {
+			result, err = b.Apply(ctx, proposal)
+			b.forget(proposal)
+		} else {
+			err = errors.New("no tool proposed it, it was only written into the chat")
+		}
+		if err != nil {
+			result = "Failed: " + err.Error()
+		}
+
+		fmt.Fprintf(&report, "- %s\n", strings.ReplaceAll(strings.TrimSpace(result), "\n", "\n  "))
+	}
+
+	return report.String()
+}
+
+// propose applies the proposal when changes are approved automatically, or
+// returns it for the user to approve.
+func (b *Toolbox) propose(ctx context.Context, proposal Proposal) (string, error) {
+	if b.autoApprove {
+		return b.Apply(ctx, proposal)
+	}
+
+	if err := b.record(proposal); err != nil {
+		return "", err
+	}
+
+	return proposal.String(), nil
+}
+
+// proposalFilename returns the file recording the proposal, named by the
+// digest of the proposal as it reads in the chat and of the toolbox's
+// directory, so a proposal is only approved in the project it was made for.
+func (b *Toolbox) proposalFilename(proposal Proposal) string {
+	digest := sha256.New()
+	for _, part := range []string{b.Dir, strings.TrimSpace(proposal.Diff), strings.TrimSpace(proposal.Command), proposal.Tool, strings.TrimSpace(proposal.Arguments)} {
+		fmt.Fprintf(digest, "%d:%s", len(part), part)
+	}
+
+	return filepath.Join(b.ProposalDir, hex.EncodeToString(digest.Sum(nil)))
+}
+
+// record notes that a tool made the proposal, when proposals are recorded.
+func (b *Toolbox) record(proposal Proposal) error {
+	if b.ProposalDir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(b.ProposalDir, 0o700); err != nil {
+		return fmt.Errorf("could not create proposal directory: %w", err)
+	}
+	if err := os.WriteFile(b.proposalFilename(proposal), nil, 0o600); err != nil {
+		return fmt.Errorf("could not record proposal: %w", err)
+	}
+
+	return nil
+}
+
+// recorded reports whether a tool made the proposal, which is assumed when
+// proposals are not recorded.
+func (b *Toolbox) recorded(proposal Proposal) bool {
+	if b.ProposalDir == "" {
+		return true
+	}
+
+	_, err := os.Stat(b.proposalFilename(proposal))
+
+	return err == nil
+}
+
+// forget removes the record of an approved proposal.
+func (b *Toolbox) forget(proposal Proposal) {
+	if b.ProposalDir != "" {
+		os.Remove(b.proposalFilename(proposal))
+	}
+}
+
+// Apply makes the change of a proposal and describes what was done.
+func (b *Toolbox) Apply(ctx context.Context, proposal Proposal) (string, error) {
+	if proposal.Tool != "" {
+		return b.callMCPTool(ctx, proposal.Tool, proposal.Arguments)
+	}
+	if proposal.Command != "" {
+		return b.runCommand(ctx, proposal.Command)
+	}
+
+	changes, err := b.changes(proposal.Diff)
+	if err != nil {
+		return "", err
+	}
+
+	// Every file is checked, and backed up, before any is written
+	for _, change := range changes {
+		if err := b.backup(change); err != nil {
+			return "", err
+		}
+	}
+
+	done := make([]string, 0, len(changes))
+	for _, change := range changes {
+		switch {
+		case change.delete:
+			if err := os.Remove(change.filename); err != nil {
+				return "", fmt.Errorf("could not delete %s: %w", change.name, err)
+			}
+			done = append(done, "deleted "+change.name)
+		case !change.exists:
+			if err := os.MkdirAll(filepath.Dir(change.filename), 0o755); err != nil {
+				return "", fmt.Errorf("could not create directory for %s: %w", change.name, err)
+			}
+			if err := os.WriteFile(change.filename, []byte(change.newText), 0o644); err != nil {
+				return "", fmt.Errorf("could not create %s: %w", change.name, err)
+			}
+			done = append(done, "created "+change.name)
+		default:
+			if err := os.WriteFile(change.filename, []byte(change.newText), change.mode); err != nil {
+				return "", fmt.Errorf("could not write %s: %w", change.name, err)
+			}
+			done = append(done, "changed "+change.name)
+		}
+	}
+
+	return "Applied the diff: " + strings.Join(done, ", "), nil
+}
+
+// backup copies a file about to be changed or deleted into BackupDir, under
+// its path within the project.
+func (b *Toolbox) backup(change fileChange) error {
+	if b.BackupDir == "" || !change.exists {
+		return nil
+	}
+
+	filename := filepath.Join(b.BackupDir, change.name)
+	if err := os.MkdirAll(filepath.Dir(filename), 0o700); err != nil {
+		return fmt.Errorf("could not back up %s: %w", change.name, err)
+	}
+	if err := os.WriteFile(filename, []byte(change.oldText), change.mode); err != nil {
+		return fmt.Errorf("could not back up %s: %w", change.name, err)
+	}
+
+	return nil
+}
+
+// FileDiff returns the diff that writes content as the whole of a file of
+// the project, creating it if needed, or "" when the file already has it.
+func (b *Toolbox) FileDiff(path string, content string) (string, error) {
+	filename, err := b.Resolve(path)
+	if err != nil {
+		return "", err
+	}
+
+	exists := true
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		exists = false
+	} else if err != nil {
+		return "", fmt.Errorf("could not read %s: %w", path, err)
+	}
+
+	oldName, newName := diffNames(b.relative(filename), exists, false)
+
+	return patch.Diff(oldName, newName, string(data), content), nil
+}
+
+// NormalizeDiff checks that a diff applies to the project and returns it made
+// again from the files, so it shows exactly what applying it changes, or ""
+// when it changes nothing.
+func (b *Toolbox) NormalizeDiff(diff string) (string, error) {
+	changes, err := b.changes(diff)
+	if err != nil {
+		return "", err
+	}
+
+	var normalized strings.Builder
+	for _, change := range changes {
+		oldName, newName := diffNames(change.name, change.exists, change.delete)
+		normalized.WriteString(patch.Diff(oldName, newName, change.oldText, change.newText))
+	}
+
+	return normalized.String(), nil
+}
+
+// fileChange is the new content of a file changed by a diff.
+type fileChange struct {
+	filename string
+	name     string
+	exists   bool
+	delete   bool
+	mode     os.FileMode
+	oldText  string
+	newText  string
+}
+
+// changes works out the content of each file changed by a diff, refusing
+// files outside the project and hunks that no longer apply.
+func (b *Toolbox) changes(diff string) ([]fileChange, error) {
+	files, err := patch.Parse(diff)
+	if err != nil {
+		return nil, fmt.Errorf("invalid diff: %w", err)
+	}
+
+	changes := make([]fileChange, 0, len(files))
+	for _, file := range files {
+		filename, err := b.Resolve(file.Path())
+		if err != nil {
+			return nil, err
+		}
+
+		change := fileChange{filename: filename, name: b.relative(filename), delete: file.IsDelete()}
+
+		info, err := os.Stat(filename)
+		switch {
+		case err == nil && info.IsDir():
+			return nil, fmt.Errorf("%s is a directory", change.name)
+		case err == nil:
+			if file.IsNew() {
+				return nil, fmt.Errorf("%s already exists", change.name)
+			}
+
+			data, err := os.ReadFile(filename)
+			if err != nil {
+				return nil, fmt.Errorf("could not read %s: %w", change.name, err)
+			}
+			change.exists, change.mode, change.oldText = true, info.Mode().Perm(), string(data)
+		case errors.Is(err, os.ErrNotExist):
+			if !file.IsNew() {
+				return nil, fmt.Errorf("%s does not exist", change.name)
+			}
+		default:
+			return nil, fmt.Errorf("could not read %s: %w", change.name, err)
+		}
+
+		change.newText, err = file.Apply(change.oldText)
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// diffNames returns the names a diff gives the old and new sides of a file.
+func diffNames(name string, exists bool, delete bool) (string, string) {
+	oldName, newName := "a/"+filepath.ToSlash(name), "b/"+filepath.ToSlash(name)
+	if !exists {
+		oldName = patch.DevNull
+	}
+	if delete {
+		newName = patch.DevNull
+	}
+
+	return oldName, newName
+}
+
+// runCommand runs a shell command in the project directory, returning its
+// output and exit status.
+func (b *Toolbox) runCommand(ctx context.Context, command string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = b.Dir
+
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("%s did not finish within %s", command, commandTimeout)
+	}
+
+	status := 0
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		status = exitErr.ExitCode()
+	} else if err != nil {
+		return "", fmt.Errorf("could not run %s: %w", command, err)
+	}
+
+	text := string(output)
+	if len(text) > maxReadBytes {
+		text = text[:maxReadBytes] + fmt.Sprintf("\n[truncated at %d bytes]", maxReadBytes)
+	}
+
+	result := fmt.Sprintf("Ran `%s`, exit status %d", command, status)
+	if strings.TrimSpace(text) != "" {
+		result += ":\n\n" + conversation.Fence(text, "")
+	}
+
+	return result, nil
+}
+
+// proposalNote tells the model what becomes of the changes a write tool
+// makes.
+func (b *Toolbox) proposalNote() string {
+	if b.autoApprove {
+		return "The change is made at once."
+	}
+
+	return "The change is only proposed, it is made once the user approves it, so do not expect to see it until then."
+}
+
+func (b *Toolbox) writeFileTool() Tool {
+	return Tool{
+		Tool: types.Tool{
+			Name:        "write_file",
+			Description: "Write the whole content of a file of the project, creating it if needed. " + b.proposalNote(),
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"path":    map[string]interface{}{"type": "string", "description": "Path of the file, relative to the project directory"},
+					"content": map[string]interface{}{"type": "string", "description": "New content of the file"},
+				},
+				"required": []string{"path", "content"},
+			},
+		},
+		Run: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+			var args struct {
+				Path    string `json:"path"`
+				Content string `json:"content"`
+			}
+			if err := decodeArguments(arguments, &args); err != nil {
+				return "", err
+			}
+
+			diff, err := b.FileDiff(args.Path, args.Content)
+			if err != nil {
+				return "", err
+			}
+			if diff == "" {
+				return fmt.Sprintf("%s already has this content", args.Path), nil
+			}
+
+			return b.propose(ctx, Proposal{Diff: diff})
+		},
+	}
+}
+
+func (b *Toolbox) applyPatchTool() Tool {
+	return Tool{
+		Tool: types.Tool{
+			Name:        "apply_patch",
+			Description: "Change files of the project with a unified diff, as made by diff -u or git diff, with paths relative to the project directory. A file is created from /dev/null and deleted to /dev/null. " + b.proposalNote(),
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"patch": map[string]interface{}{"type": "string", "description": "The unified diff"},
+				},
+				"required": []string{"patch"},
+			},
+		},
+		Run: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+			var args struct {
+				Patch string `json:"patch"`
+			}
+			if err := decodeArguments(arguments, &args); err != nil {
+				return "", err
+			}
+
+			diff, err := b.NormalizeDiff(args.Patch)
+			if err != nil {
+				return "", err
+			}
+			if diff == "" {
+				return "the patch changes nothing", nil
+			}
+
+			return b.propose(ctx, Proposal{Diff: diff})
+		},
+	}
+}
+
+func (b *Toolbox) runCommandTool() Tool {
+	return Tool{
+		Tool: types.Tool{
+			Name:        "run_command",
+			Description: "Run a shell command in the project directory, such as a build or tests, returning its output and exit status. " + b.proposalNote(),
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"command": map[string]interface{}{"type": "string", "description": "The command, run with sh -c"},
+				},
+				"required": []string{"command"},
+			},
+		},
+		Run: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+			var args struct {
+				Command string `json:"command"`
+			}
+			if err := decodeArguments(arguments, &args); err != nil {
+				return "", err
+			}
+
+			if strings.TrimSpace(args.Command) == "" {
+				return "", fmt.Errorf("no command given")
+			}
+
+			return b.propose(ctx, Proposal{Command: strings.TrimSpace(args.Command)})
+		},
+	}
+}
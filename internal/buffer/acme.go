@@ -0,0 +1,151 @@
+package buffer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"9fans.net/go/plan9"
+	"9fans.net/go/plan9/client"
+)
+
+// AcmeWindow describes an open Acme window as listed in the acme index.
+type AcmeWindow struct {
+	ID         int
+	Name       string
+	IsDir      bool
+	IsModified bool
+}
+
+// AcmeSource reads buffers from the windows of a running Acme, through its
+// 9P file server.
+type AcmeSource struct {
+	mount func() (*client.Fsys, error)
+
+	once    sync.Once
+	fsys    *client.Fsys
+	fsysErr error
+}
+
+// RunningUnderAcme reports whether the process was started from an Acme
+// window, in which case Acme sets $winid.
+func RunningUnderAcme() bool {
+	return os.Getenv("winid") != ""
+}
+
+// NewAcmeSource returns a source for the Acme serving the current name
+// space. The file server is mounted on first use.
+func NewAcmeSource() *AcmeSource {
+	return &AcmeSource{
+		mount: func() (*client.Fsys, error) {
+			return client.MountService("acme")
+		},
+	}
+}
+
+// NewAcmeSourceFsys returns a source reading from an already mounted Acme
+// file server.
+func NewAcmeSourceFsys(fsys *client.Fsys) *AcmeSource {
+	return &AcmeSource{
+		mount: func() (*client.Fsys, error) {
+			return fsys, nil
+		},
+	}
+}
+
+func (s *AcmeSource) Name() string {
+	return "acme"
+}
+
+// Buffer returns the body of the Acme window named filename.
+func (s *AcmeSource) Buffer(filename string) (string, bool, error) {
+	windows, err := s.Windows()
+	if err != nil {
+		return "", false, err
+	}
+
+	for _, window := range windows {
+		if window.IsDir || window.Name != filename {
+			continue
+		}
+
+		body, err := s.readFile(fmt.Sprintf("%d/body", window.ID))
+		if err != nil {
+			return "", false, fmt.Errorf("could not read acme window %d: %w", window.ID, err)
+		}
+
+		return body, true, nil
+	}
+
+	return "", false, nil
+}
+
+// Windows lists the open Acme windows.
+func (s *AcmeSource) Windows() ([]AcmeWindow, error) {
+	index, err := s.readFile("index")
+	if err != nil {
+		return nil, fmt.Errorf("could not read acme index: %w", err)
+	}
+
+	windows := make([]AcmeWindow, 0)
+	for _, line := range strings.Split(index, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		window, err := parseAcmeIndexLine(line)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, window)
+	}
+
+	return windows, nil
+}
+
+// parseAcmeIndexLine parses a line of the acme index file: the window id,
+// tag length, body length, directory and modified flags, then the tag, which
+// starts with the window name.
+func parseAcmeIndexLine(line string) (AcmeWindow, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 6 {
+		return AcmeWindow{}, fmt.Errorf("invalid acme index line %q", line)
+	}
+
+	id, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return AcmeWindow{}, fmt.Errorf("invalid window id in acme index line %q", line)
+	}
+
+	return AcmeWindow{
+		ID:         id,
+		Name:       fields[5],
+		IsDir:      fields[3] == "1",
+		IsModified: fields[4] == "1",
+	}, nil
+}
+
+func (s *AcmeSource) readFile(name string) (string, error) {
+	s.once.Do(func() {
+		s.fsys, s.fsysErr = s.mount()
+	})
+	if s.fsysErr != nil {
+		return "", fmt.Errorf("could not mount acme: %w", s.fsysErr)
+	}
+
+	fid, err := s.fsys.Open(name, plan9.OREAD)
+	if err != nil {
+		return "", err
+	}
+	defer fid.Close()
+
+	data, err := io.ReadAll(fid)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
@@ -0,0 +1,192 @@
+package buffer
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"testing"
+
+	"9fans.net/go/plan9"
+	"9fans.net/go/plan9/client"
+)
+
+// fakeFileServer is a read-only 9P file server over a fixed set of files,
+// standing in for the one Acme provides.
+type fakeFileServer struct {
+	files map[string]string
+	fids  map[uint32]string
+}
+
+func (s *fakeFileServer) isDir(name string) bool {
+	if name == "" {
+		return true
+	}
+
+	for filename := range s.files {
+		if strings.HasPrefix(filename, name+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *fakeFileServer) qid(name string) plan9.Qid {
+	if s.isDir(name) {
+		return plan9.Qid{Type: plan9.QTDIR}
+	}
+
+	return plan9.Qid{}
+}
+
+func (s *fakeFileServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		tx, err := plan9.ReadFcall(conn)
+		if err != nil {
+			return
+		}
+
+		rx := s.respond(tx)
+		rx.Tag = tx.Tag
+		if err := plan9.WriteFcall(conn, rx); err != nil {
+			return
+		}
+	}
+}
+
+func (s *fakeFileServer) respond(tx *plan9.Fcall) *plan9.Fcall {
+	switch tx.Type {
+	case plan9.Tversion:
+		return &plan9.Fcall{Type: plan9.Rversion, Msize: tx.Msize, Version: "9P2000"}
+	case plan9.Tattach:
+		s.fids[tx.Fid] = ""
+		return &plan9.Fcall{Type: plan9.Rattach, Qid: s.qid("")}
+	case plan9.Twalk:
+		name := s.fids[tx.Fid]
+		qids := make([]plan9.Qid, 0, len(tx.Wname))
+		for _, elem := range tx.Wname {
+			name = path.Join(name, elem)
+			if _, ok := s.files[name]; !ok && !s.isDir(name) {
+				break
+			}
+			qids = append(qids, s.qid(name))
+		}
+		if len(qids) == len(tx.Wname) {
+			s.fids[tx.Newfid] = name
+		}
+		return &plan9.Fcall{Type: plan9.Rwalk, Wqid: qids}
+	case plan9.Topen:
+		return &plan9.Fcall{Type: plan9.Ropen, Qid: s.qid(s.fids[tx.Fid])}
+	case plan9.Tread:
+		content := s.files[s.fids[tx.Fid]]
+		if tx.Offset >= uint64(len(content)) {
+			return &plan9.Fcall{Type: plan9.Rread}
+		}
+		end := tx.Offset + uint64(tx.Count)
+		if end > uint64(len(content)) {
+			end = uint64(len(content))
+		}
+		return &plan9.Fcall{Type: plan9.Rread, Data: []byte(content[tx.Offset:end])}
+	case plan9.Tclunk:
+		delete(s.fids, tx.Fid)
+		return &plan9.Fcall{Type: plan9.Rclunk}
+	default:
+		return &plan9.Fcall{Type: plan9.Rerror, Ename: "not supported"}
+	}
+}
+
+func mountFakeAcme(t *testing.T, files map[string]string) *client.Fsys {
+	t.Helper()
+
+	serverConn, clientConn := net.Pipe()
+	server := &fakeFileServer{files: files, fids: make(map[uint32]string)}
+	go server.serve(serverConn)
+
+	conn, err := client.NewConn(clientConn)
+	if err != nil {
+		t.Fatalf("NewConn() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	fsys, err := conn.Attach(nil, "test", "")
+	if err != nil {
+		t.Fatalf("Attach() error = %v", err)
+	}
+
+	return fsys
+}
+
+// acmeIndexLine formats a line of the acme index file the way Acme does.
+func acmeIndexLine(id int, isDir int, isModified int, tag string) string {
+	return fmt.Sprintf("%11d %11d %11d %11d %11d %s\n", id, len(tag), 0, isDir, isModified, tag)
+}
+
+func TestAcmeSourceBuffer(t *testing.T) {
+	fsys := mountFakeAcme(t, map[string]string{
+		"index": acmeIndexLine(1, 0, 1, "/project/main.go Del Snarf Undo | Look ") +
+			acmeIndexLine(2, 1, 0, "/project/ Del Snarf Get | Look ") +
+			acmeIndexLine(3, 0, 0, "/project/+Errors Del Snarf | Look "),
+		"1/body": "package main // unsaved\n",
+		"2/body": "main.go\n",
+		"3/body": "",
+	})
+
+	source := NewAcmeSourceFsys(fsys)
+
+	windows, err := source.Windows()
+	if err != nil {
+		t.Fatalf("Windows() error = %v", err)
+	}
+	if len(windows) != 3 || windows[0].Name != "/project/main.go" || !windows[0].IsModified || !windows[1].IsDir {
+		t.Errorf("Windows() = %+v", windows)
+	}
+
+	content, ok, err := source.Buffer("/project/main.go")
+	if err != nil || !ok || content != "package main // unsaved\n" {
+		t.Errorf("Buffer(main.go) = %q, %v, %v, want the window body", content, ok, err)
+	}
+
+	if _, ok, err := source.Buffer("/project/"); ok || err != nil {
+		t.Errorf("Buffer() of a directory window = %v, %v, want not found", ok, err)
+	}
+
+	if _, ok, err := source.Buffer("/project/other.go"); ok || err != nil {
+		t.Errorf("Buffer() of an unopened file = %v, %v, want not found", ok, err)
+	}
+}
+
+func TestLookupCommandSource(t *testing.T) {
+	t.Cleanup(Reset)
+
+	Register(CommandSource{Command: `test "$1" = /project/open.go && echo "from editor"`})
+
+	content, ok, err := Lookup("/project/open.go")
+	if err != nil || !ok || content != "from editor\n" {
+		t.Errorf("Lookup(open.go) = %q, %v, %v", content, ok, err)
+	}
+
+	if _, ok, err := Lookup("/project/closed.go"); ok || err != nil {
+		t.Errorf("Lookup(closed.go) = %v, %v, want not found", ok, err)
+	}
+}
+
+func TestLookupFailingSource(t *testing.T) {
+	t.Cleanup(Reset)
+
+	Register(CommandSource{Command: "no-such-editor-command"})
+
+	if _, ok, err := Lookup("/project/open.go"); ok || err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Lookup() with a missing command = %v, %v, want a not found error", ok, err)
+	}
+
+	// A failing source does not hide a buffer another source has open
+	Register(CommandSource{Command: `echo "from editor"`})
+
+	content, ok, err := Lookup("/project/open.go")
+	if err != nil || !ok || content != "from editor\n" {
+		t.Errorf("Lookup() = %q, %v, %v, want the second source's buffer", content, ok, err)
+	}
+}
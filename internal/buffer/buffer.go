@@ -0,0 +1,65 @@
+package buffer
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Source supplies the content of open editor buffers, which may hold changes
+// that have not been saved to disk yet.
+type Source interface {
+	// Buffer returns the content of the buffer open for the absolute path
+	// filename. ok is false when the source has no buffer for the file.
+	Buffer(filename string) (content string, ok bool, err error)
+
+	// Name returns the source's name for identification
+	Name() string
+}
+
+var (
+	sourcesMu sync.RWMutex
+	sources   []Source
+)
+
+// Register adds a source to be consulted by Lookup. Sources are consulted in
+// the order they were registered.
+func Register(source Source) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+
+	sources = append(sources, source)
+}
+
+// Reset removes all registered sources.
+func Reset() {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+
+	sources = nil
+}
+
+// Lookup returns the content of the first buffer open for filename among
+// the registered sources. ok is false when no source has the file open, in
+// which case the caller should read it from disk. A source that fails does
+// not stop the others being consulted; its error is returned only when no
+// other source has the file open.
+func Lookup(filename string) (content string, ok bool, err error) {
+	sourcesMu.RLock()
+	defer sourcesMu.RUnlock()
+
+	var firstErr error
+	for _, source := range sources {
+		content, ok, err := source.Buffer(filename)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %w", source.Name(), err)
+			}
+			continue
+		}
+		if ok {
+			return content, true, nil
+		}
+	}
+
+	return "", false, firstErr
+}
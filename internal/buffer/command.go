@@ -0,0 +1,47 @@
+package buffer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// CommandSource asks an external command for the content of a buffer,
+// letting any editor that can be scripted supply its unsaved buffers.
+//
+// The command is run by sh with the absolute filename as $1. It should print
+// the buffer content and exit successfully, or exit with a non-zero status
+// when the file is not open. The statuses sh reserves for a command it could
+// not run, 126 and 127, are reported as errors.
+type CommandSource struct {
+	Command string
+}
+
+func (s CommandSource) Name() string {
+	return "command"
+}
+
+func (s CommandSource) Buffer(filename string) (string, bool, error) {
+	cmd := exec.Command("sh", "-c", s.Command, "sh", filename)
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	err := cmd.Run()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		switch exitErr.ExitCode() {
+		case 126:
+			return "", false, fmt.Errorf("buffer command %q could not be executed", s.Command)
+		case 127:
+			return "", false, fmt.Errorf("buffer command %q was not found", s.Command)
+		}
+		return "", false, nil
+	} else if err != nil {
+		return "", false, fmt.Errorf("could not run buffer command %q: %w", s.Command, err)
+	}
+
+	return stdout.String(), true, nil
+}
@@ -5,13 +5,18 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
-	"strings"
-
-	yaml "gopkg.in/yaml.v2"
+	"time"
 )
 
 type Config struct {
-	LLM        LLMConfig   `yaml:"llm"`
+	LLM       LLMConfig       `yaml:"llm"`
+	Redact    RedactConfig    `yaml:"redact"`
+	Resources ResourcesConfig `yaml:"resources"`
+	Buffers   BuffersConfig   `yaml:"buffers"`
+	Files     FilesConfig     `yaml:"files"`
+	Agent     AgentConfig     `yaml:"agent"`
+	MCP       MCPConfig       `yaml:"mcp"`
+	Serve     ServeConfig     `yaml:"serve"`
 }
 
 type LLMConfig struct {
@@ -20,46 +25,200 @@ type LLMConfig struct {
 	GlobIgnore      []string                  `yaml:"glob_ignore"`
 }
 
+// RedactConfig controls the scrubbing of secrets from content before it
+// is sent to a provider.
+type RedactConfig struct {
+	// Disabled turns off all redaction when true.
+	Disabled bool `yaml:"disabled"`
+
+	// DisabledDetectors lists built-in detectors to skip, e.g. high_entropy.
+	DisabledDetectors []string `yaml:"disabled_detectors"`
+
+	// Patterns are additional user supplied detectors.
+	Patterns []RedactPattern `yaml:"patterns"`
+}
+
+// RedactPattern is a named regular expression whose matches are redacted.
+type RedactPattern struct {
+	Name  string `yaml:"name"`
+	Regex string `yaml:"regex"`
+}
+
+// ResourcesConfig controls how reference material is fetched.
+type ResourcesConfig struct {
+	// Concurrency is the number of resources fetched at once, default 4.
+	Concurrency int `yaml:"concurrency"`
+
+	// Strict makes any resource that fails to fetch abort the send, rather
+	// than being reported and skipped.
+	Strict bool `yaml:"strict"`
+
+	// Snapshot freezes the resources fetched for each turn, so that later
+	// sends replay the content earlier answers were based on.
+	Snapshot bool `yaml:"snapshot"`
+
+	// SnapshotDir defaults to snapshots within the state directory.
+	SnapshotDir string `yaml:"snapshot_dir"`
+
+	URL URLConfig `yaml:"url"`
+}
+
+// URLConfig controls the fetching and caching of +url resources. Zero
+// values fall back to sensible defaults.
+type URLConfig struct {
+	// NoCache disables the on-disk cache entirely.
+	NoCache bool `yaml:"no_cache"`
+
+	// CacheDir defaults to ai-stdio/urls within the user cache directory.
+	CacheDir string `yaml:"cache_dir"`
+
+	// CacheTTL is how long a cached response is used without revalidating
+	// it with the server.
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+
+	// Timeout bounds each request, including redirects.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// MaxBytes caps the size of a response body, anything beyond is dropped.
+	MaxBytes int64 `yaml:"max_bytes"`
+
+	// MaxRedirects is the number of redirects followed before giving up.
+	MaxRedirects int `yaml:"max_redirects"`
+}
+
+// BuffersConfig selects the editors consulted for unsaved buffer content
+// when reading +file resources.
+type BuffersConfig struct {
+	// Acme is "auto" (the default) to read Acme windows when running under
+	// Acme, "on" to always read them or "off" to never read them.
+	Acme string `yaml:"acme"`
+
+	// Commands are run with the absolute filename as $1 and print the
+	// buffer content, exiting non-zero when the file is not open.
+	Commands []string `yaml:"commands"`
+}
+
+// FilesConfig controls the files attached by the +files directive.
+type FilesConfig struct {
+	// Source is "acme" for the files open in Acme, "list" for the files
+	// given with -files or -files-from, or "git" for the files modified in
+	// the git working tree. The default, "auto", uses a list when one is
+	// given, Acme when running under it, and git otherwise.
+	Source string `yaml:"source"`
+}
+
+// AgentConfig controls the agent mode of send, where the model reads the
+// project through tools instead of being given files up front.
+type AgentConfig struct {
+	// Enabled turns on agent mode, usually for a single chat with
+	// agent.enabled: true in its front matter.
+	Enabled bool `yaml:"enabled"`
+
+	// MaxSteps is the number of replies the model may make before it must
+	// answer, default 10.
+	MaxSteps int `yaml:"max_steps"`
+
+	// Write offers the model the write_file, apply_patch and run_command
+	// tools as well as the read-only ones.
+	Write bool `yaml:"write"`
+
+	// AutoApprove applies the changes of the write tools at once instead of
+	// proposing them for approval with +approve or ai-stdio apply --approve.
+	AutoApprove bool `yaml:"auto_approve"`
+}
+
+// MCPConfig declares the Model Context Protocol servers whose tools are
+// offered to the model in agent mode and whose resources chats attach with
+// +mcp server:uri.
+//
+// A server's tools may act on the world, so unless the server is trusted
+// they are only offered with agent.write, and each call is proposed for
+// approval, as the changes of the write tools are. Servers are started as
+// commands, so they are only taken from the global configuration file.
+type MCPConfig struct {
+	Servers map[string]MCPServerConfig `yaml:"servers"`
+}
+
+// MCPServerConfig is a server launched as a command speaking MCP over its
+// standard input and output.
+type MCPServerConfig struct {
+	Command string   `yaml:"command"`
+	Args    []string `yaml:"args"`
+
+	// Env adds variables to the server's environment. Values are expanded
+	// as provider params are, so secrets may come from $ENV:, $FILE: or
+	// $CMD:.
+	Env map[string]string `yaml:"env"`
+
+	// Tools limits the tools offered to the model to those named, every
+	// tool of the server being offered when empty.
+	Tools []string `yaml:"tools"`
+
+	// Trusted lets the model call the tools without approval, and without
+	// agent.write. Only trust a server whose offered tools cannot change
+	// anything.
+	Trusted bool `yaml:"trusted"`
+}
+
+// ServeConfig controls ai-stdio serve, which spends the keys of the
+// configured providers on behalf of other local tools.
+type ServeConfig struct {
+	// Token, when set, is the bearer token HTTP clients must send. It is
+	// expanded as provider params are. Without it, the HTTP API only
+	// answers on localhost.
+	Token string `yaml:"token"`
+
+	// Budgets cap the requests served for each provider, by the name it is
+	// configured under. Providers without a budget are not capped.
+	Budgets map[string]BudgetConfig `yaml:"budgets"`
+}
+
+// BudgetConfig caps the requests sent to a provider. A cap of 0 is no cap.
+// Budgets count requests, not tokens or spend, which the providers do not
+// report.
+type BudgetConfig struct {
+	RequestsPerHour int `yaml:"requests_per_hour"`
+	RequestsPerDay  int `yaml:"requests_per_day"`
+}
+
 type ProviderConfig struct {
 	Type   string                 `yaml:"type"`
 	Model  string                 `yaml:"model"`
 	Params map[string]interface{} `yaml:"params"`
+
+	// Aliases are other names a chat may select the provider by.
+	Aliases []string `yaml:"aliases"`
 }
 
+// Load returns the configuration resolved from the working directory,
+// without a chat layer.
 func Load() (Config, error) {
-	config_filename, err := getConfigFile("ai-stdio", "config.yaml")
+	dir, err := os.Getwd()
 	if err != nil {
-		return Config{}, fmt.Errorf("could not get configuration file path", err)
+		return Config{}, fmt.Errorf("could not get working directory: %v", err)
 	}
 
-	f, err := os.Open(config_filename)
+	resolved, err := Resolve(dir, nil)
 	if err != nil {
-		return Config{},
-			fmt.Errorf("could not open configuration file: %v", err)
+		return Config{}, err
 	}
 
-	c := Config{}
-	d := yaml.NewDecoder(f)
-	err = d.Decode(&c)
-	if err != nil {
-		return Config{},
-			fmt.Errorf("could not decode the configuration file: %v", err)
-	}
-
-	return c, nil
+	return resolved.Config, nil
 }
 
-// Expand a string value.
-//
-// If the value starts with $ENV: then it reads the value from the
-// environment key specified, otherwise, the value is simply returned.
-func ExpandString(value string) string {
-	if strings.HasPrefix(value, "$ENV:") {
-		envVar := strings.TrimPrefix(value, "$ENV:")
-		return os.Getenv(envVar)
+// StateDir returns the directory ai-stdio keeps its state in, honouring
+// XDG_STATE_HOME.
+func StateDir() (string, error) {
+	stateHome := os.Getenv("XDG_STATE_HOME")
+	if stateHome == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		stateHome = filepath.Join(homeDir, ".local/state")
 	}
 
-	return value
+	return filepath.Join(stateHome, "ai-stdio"), nil
 }
 
 // getConfigFile constructs the full path for a given application's config file.
@@ -0,0 +1,176 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var (
+	// interpolation matches ${VAR} references within a larger string
+	interpolation = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+	// commandOutputs caches the output of $CMD: references for the life of
+	// the process, so a password manager is asked only once
+	commandOutputs   = make(map[string]string)
+	commandOutputsMu sync.Mutex
+)
+
+// ExpandString expands a configuration value that refers to a secret.
+//
+//   - $ENV:NAME is the value of the environment variable NAME.
+//   - $FILE:path is the content of the file, without trailing whitespace. A
+//     leading ~/ is the home directory.
+//   - $CMD:command is the output of the shell command, without trailing
+//     whitespace. Each command is run once per process.
+//
+// Any other value has its ${NAME} references replaced by the environment
+// variables they name. An error is returned when a variable is unset, a
+// file cannot be read or a command fails, rather than expanding to an
+// empty secret. Resolve only lets such values come from the global
+// configuration file.
+func ExpandString(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "$ENV:"):
+		return lookupEnv(strings.TrimPrefix(value, "$ENV:"))
+	case strings.HasPrefix(value, "$FILE:"):
+		return readSecretFile(strings.TrimPrefix(value, "$FILE:"))
+	case strings.HasPrefix(value, "$CMD:"):
+		return runSecretCommand(strings.TrimPrefix(value, "$CMD:"))
+	}
+
+	var err error
+	expanded := interpolation.ReplaceAllStringFunc(value, func(reference string) string {
+		name := interpolation.FindStringSubmatch(reference)[1]
+
+		v, lookupErr := lookupEnv(name)
+		if lookupErr != nil && err == nil {
+			err = lookupErr
+		}
+
+		return v
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return expanded, nil
+}
+
+// isReference reports whether a configuration value, or any value within it,
+// is one ExpandString would expand rather than take literally.
+func isReference(value interface{}) bool {
+	switch v := value.(type) {
+	case string:
+		return strings.HasPrefix(v, "$ENV:") || strings.HasPrefix(v, "$FILE:") || strings.HasPrefix(v, "$CMD:") || interpolation.MatchString(v)
+	case []interface{}:
+		for _, item := range v {
+			if isReference(item) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ExpandParams returns a copy of provider params with every string, however
+// deeply nested, expanded by ExpandString.
+func ExpandParams(params map[string]interface{}) (map[string]interface{}, error) {
+	expanded := make(map[string]interface{}, len(params))
+	for key, value := range params {
+		v, err := expandValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("params.%s: %w", key, err)
+		}
+		expanded[key] = v
+	}
+
+	return expanded, nil
+}
+
+func expandValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return ExpandString(v)
+	case map[string]interface{}:
+		return ExpandParams(v)
+	case map[interface{}]interface{}:
+		expanded := make(map[interface{}]interface{}, len(v))
+		for key, item := range v {
+			e, err := expandValue(item)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %w", key, err)
+			}
+			expanded[key] = e
+		}
+		return expanded, nil
+	case []interface{}:
+		expanded := make([]interface{}, len(v))
+		for i, item := range v {
+			e, err := expandValue(item)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			expanded[i] = e
+		}
+		return expanded, nil
+	default:
+		return value, nil
+	}
+}
+
+func lookupEnv(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+
+	return value, nil
+}
+
+func readSecretFile(filename string) (string, error) {
+	if strings.HasPrefix(filename, "~/") {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("could not get home directory: %w", err)
+		}
+		filename = filepath.Join(homeDir, filename[2:])
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("could not read secret file: %w", err)
+	}
+
+	return strings.TrimRight(string(content), " \t\r\n"), nil
+}
+
+func runSecretCommand(command string) (string, error) {
+	commandOutputsMu.Lock()
+	defer commandOutputsMu.Unlock()
+
+	if output, ok := commandOutputs[command]; ok {
+		return output, nil
+	}
+
+	cmd := exec.Command("sh", "-c", command)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("secret command %q failed: %w: %s", command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	output := strings.TrimRight(stdout.String(), " \t\r\n")
+	commandOutputs[command] = output
+
+	return output, nil
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandString(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "secret")
+	writeFile(t, secretFile, "from-file\n")
+
+	t.Setenv("AI_STDIO_TEST_KEY", "from-env")
+	t.Setenv("AI_STDIO_TEST_HOST", "example.com")
+	t.Setenv("AI_STDIO_TEST_MISSING", "")
+	os.Unsetenv("AI_STDIO_TEST_MISSING")
+
+	tests := []struct {
+		value   string
+		want    string
+		wantErr string
+	}{
+		{"plain", "plain", ""},
+		{"$ENV:AI_STDIO_TEST_KEY", "from-env", ""},
+		{"$ENV:AI_STDIO_TEST_MISSING", "", "AI_STDIO_TEST_MISSING is not set"},
+		{"$FILE:" + secretFile, "from-file", ""},
+		{"$FILE:" + filepath.Join(dir, "absent"), "", "could not read secret file"},
+		{"$CMD:echo from-command", "from-command", ""},
+		{"$CMD:exit 3", "", "secret command"},
+		{"https://${AI_STDIO_TEST_HOST}/v1", "https://example.com/v1", ""},
+		{"Bearer ${AI_STDIO_TEST_MISSING}", "", "AI_STDIO_TEST_MISSING is not set"},
+	}
+
+	for _, tt := range tests {
+		got, err := ExpandString(tt.value)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ExpandString(%q) error = %v, want %q", tt.value, err, tt.wantErr)
+			}
+			continue
+		}
+
+		if err != nil || got != tt.want {
+			t.Errorf("ExpandString(%q) = %q, %v, want %q", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestExpandStringCachesCommands(t *testing.T) {
+	counter := filepath.Join(t.TempDir(), "runs")
+	command := "$CMD:echo run >> " + counter + "; echo secret"
+
+	for i := 0; i < 2; i++ {
+		if got, err := ExpandString(command); err != nil || got != "secret" {
+			t.Fatalf("ExpandString() = %q, %v", got, err)
+		}
+	}
+
+	runs, err := os.ReadFile(counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(runs), "run"); n != 1 {
+		t.Errorf("command ran %d times, want once", n)
+	}
+}
+
+func TestExpandParams(t *testing.T) {
+	t.Setenv("AI_STDIO_TEST_KEY", "from-env")
+	t.Setenv("AI_STDIO_TEST_MISSING", "")
+	os.Unsetenv("AI_STDIO_TEST_MISSING")
+
+	params := map[string]interface{}{
+		"api_key":     "$ENV:AI_STDIO_TEST_KEY",
+		"temperature": 0.2,
+		"headers":     map[interface{}]interface{}{"X-Key": "${AI_STDIO_TEST_KEY}"},
+	}
+
+	got, err := ExpandParams(params)
+	if err != nil {
+		t.Fatalf("ExpandParams() error = %v", err)
+	}
+
+	want := map[string]interface{}{
+		"api_key":     "from-env",
+		"temperature": 0.2,
+		"headers":     map[interface{}]interface{}{"X-Key": "from-env"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExpandParams() = %v, want %v", got, want)
+	}
+	if params["api_key"] != "$ENV:AI_STDIO_TEST_KEY" {
+		t.Errorf("ExpandParams() modified its argument")
+	}
+
+	_, err = ExpandParams(map[string]interface{}{"base_url": "$ENV:AI_STDIO_TEST_MISSING"})
+	if err == nil || !strings.Contains(err.Error(), "params.base_url") {
+		t.Errorf("ExpandParams() error = %v, want it to name the param", err)
+	}
+}
@@ -0,0 +1,429 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Configuration layers, from lowest to highest precedence.
+const (
+	LayerDefaults = "defaults"
+	LayerGlobal   = "global"
+	LayerProject  = "project"
+	LayerChat     = "chat"
+)
+
+// ProjectConfigFilename is the project level configuration file, found by
+// walking up from the working directory.
+const ProjectConfigFilename = ".ai-stdio.yaml"
+
+// defaultConfig is the built-in configuration every other layer overrides.
+const defaultConfig = `
+resources:
+  concurrency: 4
+  url:
+    cache_ttl: 1h
+    timeout: 30s
+    max_bytes: 2097152
+    max_redirects: 5
+buffers:
+  acme: auto
+files:
+  source: auto
+agent:
+  max_steps: 10
+`
+
+// defaultProviders is added to the built-in configuration when no other
+// layer configures a provider, so a local Ollama works without any setup.
+const defaultProviders = `
+llm:
+  default_provider: ollama
+  providers:
+    ollama:
+      type: ollama
+      model: llama3.2
+      params:
+        base_url: http://localhost:11434
+`
+
+// Layer is a single source of configuration values.
+type Layer struct {
+	Name string
+
+	// Path is the file the layer was read from, empty for the built-in
+	// defaults and the chat front matter.
+	Path string
+
+	// Found is false when the layer's file does not exist.
+	Found bool
+
+	values map[interface{}]interface{}
+
+	// refused are the dotted paths of the values the layer may not set,
+	// which were left out.
+	refused []string
+
+	// references are the dotted paths of the values left out for referring
+	// to a secret, which only the global file may do.
+	references []string
+}
+
+// Setting is a single effective configuration value and the layer it came
+// from.
+type Setting struct {
+	Path   string
+	Value  interface{}
+	Source string
+}
+
+// Resolved is the configuration produced by merging every layer.
+type Resolved struct {
+	Config Config
+	Layers []Layer
+
+	merged map[interface{}]interface{}
+}
+
+// Resolve merges the built-in defaults, the global configuration file, the
+// project configuration file found by walking up from dir and the chat front
+// matter, each overriding the ones before it.
+//
+// Only the global file is the user's own. The project file comes with the
+// project, so it may only set the projectSettings, and front matter keys
+// naming one of the chatSettings with a dotted path, such as
+// resources.snapshot, are applied, with model selecting the default
+// provider. Neither may refer to a secret with $ENV:, $FILE:, $CMD: or
+// ${NAME}, as that would read files and run commands. frontMatter may be
+// nil when there is no chat.
+func Resolve(dir string, frontMatter map[string]string) (*Resolved, error) {
+	layers := make([]Layer, 0, 4)
+
+	defaults := Layer{Name: LayerDefaults, Found: true}
+	if err := yaml.Unmarshal([]byte(defaultConfig), &defaults.values); err != nil {
+		return nil, fmt.Errorf("could not decode the default configuration: %v", err)
+	}
+	layers = append(layers, defaults)
+
+	globalFilename, err := getConfigFile("ai-stdio", "config.yaml")
+	if err != nil {
+		return nil, fmt.Errorf("could not get configuration file path: %v", err)
+	}
+	global, err := readLayer(LayerGlobal, globalFilename)
+	if err != nil {
+		return nil, err
+	}
+	layers = append(layers, global)
+
+	if projectFilename := findProjectConfig(dir); projectFilename != "" {
+		project, err := readLayer(LayerProject, projectFilename)
+		if err != nil {
+			return nil, err
+		}
+		project.restrict(projectSettings)
+		layers = append(layers, project)
+	} else {
+		layers = append(layers, Layer{Name: LayerProject})
+	}
+
+	layers = append(layers, frontMatterLayer(frontMatter))
+
+	return resolveLayers(layers)
+}
+
+// resolveLayers merges layers in order and decodes the result. The default
+// providers join the built-in layer when no other layer has any.
+func resolveLayers(layers []Layer) (*Resolved, error) {
+	if !hasProviders(layers) && len(layers) > 0 && layers[0].Name == LayerDefaults {
+		var providers map[interface{}]interface{}
+		if err := yaml.Unmarshal([]byte(defaultProviders), &providers); err != nil {
+			return nil, fmt.Errorf("could not decode the default providers: %v", err)
+		}
+		mergeValues(layers[0].values, providers)
+	}
+
+	merged := make(map[interface{}]interface{})
+	for _, layer := range layers {
+		mergeLayer(merged, layer.values)
+	}
+
+	data, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode the merged configuration: %v", err)
+	}
+
+	c := Config{}
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("could not decode the merged configuration: %v", err)
+	}
+
+	return &Resolved{Config: c, Layers: layers, merged: merged}, nil
+}
+
+// hasProviders reports whether any layer other than the built-in one
+// configures a provider.
+func hasProviders(layers []Layer) bool {
+	for _, layer := range layers {
+		if layer.Name == LayerDefaults {
+			continue
+		}
+
+		llm, _ := layer.values["llm"].(map[interface{}]interface{})
+		if providers, _ := llm["providers"].(map[interface{}]interface{}); len(providers) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readLayer reads a configuration file as a layer. A missing file is an
+// empty layer rather than an error.
+func readLayer(name string, filename string) (Layer, error) {
+	layer := Layer{Name: name, Path: filename}
+
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return layer, nil
+	} else if err != nil {
+		return layer, fmt.Errorf("could not open configuration file: %v", err)
+	}
+
+	if err := yaml.Unmarshal(data, &layer.values); err != nil {
+		return layer, fmt.Errorf("could not decode the configuration file %s: %v", filename, err)
+	}
+	layer.Found = true
+
+	return layer, nil
+}
+
+// findProjectConfig walks up from dir looking for the project configuration
+// file, returning its path or an empty string if there is none.
+func findProjectConfig(dir string) string {
+	for dir != "/" && dir != "." && dir != "" {
+		filename := filepath.Join(dir, ProjectConfigFilename)
+		if _, err := os.Stat(filename); err == nil {
+			return filename
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	return ""
+}
+
+// projectSettings are the configuration values a project's .ai-stdio.yaml
+// may set. Cloning a repository must not run commands, start MCP servers,
+// turn on writing to the project or off redaction, or send a provider's
+// key elsewhere, so all of those come from the global file only.
+var projectSettings = map[string]bool{
+	"llm.default_provider":        true,
+	"llm.glob_ignore":             true,
+	"resources.concurrency":       true,
+	"resources.strict":            true,
+	"resources.snapshot":          true,
+	"resources.url.no_cache":      true,
+	"resources.url.cache_ttl":     true,
+	"resources.url.timeout":       true,
+	"resources.url.max_bytes":     true,
+	"resources.url.max_redirects": true,
+	"buffers.acme":                true,
+	"files.source":                true,
+	"agent.enabled":               true,
+	"agent.max_steps":             true,
+}
+
+// restrict leaves out the values of the layer other than the allowed ones,
+// recording them as refused, and those referring to a secret. Unknown keys
+// are kept, for Validate to report as such.
+func (l *Layer) restrict(allowed map[string]bool) {
+	unknown := unknownKeys("", l.values, reflect.TypeOf(Config{}))
+
+	values := make(map[string]interface{})
+	flattenValues("", l.values, values)
+
+	kept := make(map[interface{}]interface{})
+	for path, value := range values {
+		switch {
+		case underAny(path, unknown):
+			setPath(kept, strings.Split(path, "."), value)
+		case !allowed[path]:
+			l.refused = append(l.refused, path)
+		case isReference(value):
+			l.references = append(l.references, path)
+		default:
+			setPath(kept, strings.Split(path, "."), value)
+		}
+	}
+	sort.Strings(l.refused)
+	sort.Strings(l.references)
+
+	l.values = kept
+}
+
+// underAny reports whether path is one of paths or below one of them.
+func underAny(path string, paths []string) bool {
+	for _, p := range paths {
+		if path == p || strings.HasPrefix(path, p+".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// chatSettings are the configuration values a chat may set in its front
+// matter. A chat file may come from anywhere, so it is limited to what
+// shapes a single chat: it cannot run commands, turn on writing to the
+// project, or send a provider's key elsewhere.
+var chatSettings = map[string]bool{
+	"llm.default_provider":        true,
+	"resources.concurrency":       true,
+	"resources.strict":            true,
+	"resources.snapshot":          true,
+	"resources.url.no_cache":      true,
+	"resources.url.cache_ttl":     true,
+	"resources.url.timeout":       true,
+	"resources.url.max_bytes":     true,
+	"resources.url.max_redirects": true,
+	"files.source":                true,
+	"agent.enabled":               true,
+	"agent.max_steps":             true,
+}
+
+// frontMatterLayer builds the chat layer from the front matter keys that name
+// chat settings. Any other key is left to the chat itself.
+func frontMatterLayer(frontMatter map[string]string) Layer {
+	layer := Layer{Name: LayerChat, Found: frontMatter != nil, values: make(map[interface{}]interface{})}
+
+	for key, value := range frontMatter {
+		path := key
+		if key == "model" {
+			path = "llm.default_provider"
+		}
+
+		if !chatSettings[path] {
+			continue
+		}
+
+		// Decode the value as YAML so booleans and numbers keep their types
+		var decoded interface{}
+		if err := yaml.Unmarshal([]byte(value), &decoded); err != nil || decoded == nil {
+			decoded = value
+		}
+
+		if isReference(decoded) {
+			layer.references = append(layer.references, path)
+			continue
+		}
+
+		setPath(layer.values, strings.Split(path, "."), decoded)
+	}
+	sort.Strings(layer.references)
+
+	return layer
+}
+
+func setPath(values map[interface{}]interface{}, path []string, value interface{}) {
+	for _, key := range path[:len(path)-1] {
+		child, ok := values[key].(map[interface{}]interface{})
+		if !ok {
+			child = make(map[interface{}]interface{})
+			values[key] = child
+		}
+		values = child
+	}
+
+	values[path[len(path)-1]] = value
+}
+
+// mergeValues merges src into dst. Maps are merged key by key, any other
+// value, including a list, replaces the value beneath it.
+func mergeValues(dst map[interface{}]interface{}, src map[interface{}]interface{}) {
+	for key, value := range src {
+		srcMap, srcIsMap := value.(map[interface{}]interface{})
+		dstMap, dstIsMap := dst[key].(map[interface{}]interface{})
+
+		if srcIsMap && dstIsMap {
+			mergeValues(dstMap, srcMap)
+		} else if srcIsMap {
+			copied := make(map[interface{}]interface{})
+			mergeValues(copied, srcMap)
+			dst[key] = copied
+		} else {
+			dst[key] = value
+		}
+	}
+}
+
+// mergeLayer merges the values of a layer into merged. A provider is taken
+// whole from the last layer configuring it, so that its key is never sent to
+// the base_url of another layer.
+func mergeLayer(merged map[interface{}]interface{}, values map[interface{}]interface{}) {
+	mergeValues(merged, values)
+
+	llm, _ := values["llm"].(map[interface{}]interface{})
+	providers, _ := llm["providers"].(map[interface{}]interface{})
+	if len(providers) == 0 {
+		return
+	}
+
+	mergedProviders := merged["llm"].(map[interface{}]interface{})["providers"].(map[interface{}]interface{})
+	for name, provider := range providers {
+		if fields, ok := provider.(map[interface{}]interface{}); ok {
+			copied := make(map[interface{}]interface{})
+			mergeValues(copied, fields)
+			provider = copied
+		}
+		mergedProviders[name] = provider
+	}
+}
+
+// flattenValues maps the dotted path of every leaf value to the value.
+func flattenValues(prefix string, values map[interface{}]interface{}, out map[string]interface{}) {
+	for key, value := range values {
+		path := fmt.Sprint(key)
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+
+		if child, ok := value.(map[interface{}]interface{}); ok && len(child) > 0 {
+			flattenValues(path, child, out)
+		} else {
+			out[path] = value
+		}
+	}
+}
+
+// Settings returns every effective value, sorted by path, along with the
+// layer that set it.
+func (r *Resolved) Settings() []Setting {
+	merged := make(map[string]interface{})
+	flattenValues("", r.merged, merged)
+
+	sources := make(map[string]string)
+	for _, layer := range r.Layers {
+		values := make(map[string]interface{})
+		flattenValues("", layer.values, values)
+		for path := range values {
+			sources[path] = layer.Name
+		}
+	}
+
+	settings := make([]Setting, 0, len(merged))
+	for path, value := range merged {
+		settings = append(settings, Setting{Path: path, Value: value, Source: sources[path]})
+	}
+
+	sort.Slice(settings, func(i, j int) bool {
+		return settings[i].Path < settings[j].Path
+	})
+
+	return settings
+}
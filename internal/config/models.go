@@ -0,0 +1,162 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// UnknownProviderError is returned when a name selects no configured
+// provider, with the names that come closest.
+type UnknownProviderError struct {
+	Name        string
+	Suggestions []string
+}
+
+func (e *UnknownProviderError) Error() string {
+	if len(e.Suggestions) == 0 {
+		return fmt.Sprintf("unknown model %q", e.Name)
+	}
+
+	return fmt.Sprintf("unknown model %q, did you mean %s?", e.Name, strings.Join(quote(e.Suggestions), " or "))
+}
+
+// ResolveProvider finds the provider a chat selects by name, returning its
+// key in Providers along with its configuration.
+//
+// The name is matched against the provider keys, then their aliases, then,
+// ignoring case, the keys, aliases and model names. A name matching nothing
+// returns an *UnknownProviderError suggesting similar names.
+func (c LLMConfig) ResolveProvider(name string) (string, ProviderConfig, error) {
+	if provider, ok := c.Providers[name]; ok {
+		return name, provider, nil
+	}
+
+	keys := c.providerKeys()
+
+	for _, key := range keys {
+		for _, alias := range c.Providers[key].Aliases {
+			if alias == name {
+				return key, c.Providers[key], nil
+			}
+		}
+	}
+
+	matches := make([]string, 0)
+	for _, key := range keys {
+		for _, candidate := range c.providerNames(key, true) {
+			if strings.EqualFold(candidate, name) {
+				matches = append(matches, key)
+				break
+			}
+		}
+	}
+	if len(matches) == 1 {
+		return matches[0], c.Providers[matches[0]], nil
+	} else if len(matches) > 1 {
+		return "", ProviderConfig{}, &UnknownProviderError{Name: name, Suggestions: matches}
+	}
+
+	return "", ProviderConfig{}, &UnknownProviderError{Name: name, Suggestions: c.suggestProviders(name)}
+}
+
+// providerKeys returns the provider keys sorted.
+func (c LLMConfig) providerKeys() []string {
+	keys := make([]string, 0, len(c.Providers))
+	for key := range c.Providers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// providerNames returns the key and aliases of a provider and, optionally,
+// its model name.
+func (c LLMConfig) providerNames(key string, withModel bool) []string {
+	provider := c.Providers[key]
+
+	names := append([]string{key}, provider.Aliases...)
+	if withModel && provider.Model != "" {
+		names = append(names, provider.Model)
+	}
+
+	return names
+}
+
+// suggestProviders returns the keys and aliases close to name, either
+// sharing a prefix with it or within a few edits of it, closest first.
+func (c LLMConfig) suggestProviders(name string) []string {
+	type suggestion struct {
+		name     string
+		distance int
+	}
+
+	lower := strings.ToLower(name)
+	suggestions := make([]suggestion, 0)
+
+	for _, key := range c.providerKeys() {
+		for _, candidate := range c.providerNames(key, false) {
+			candidateLower := strings.ToLower(candidate)
+			distance := levenshtein(lower, candidateLower)
+
+			maxDistance := len(candidate) / 3
+			if maxDistance < 2 {
+				maxDistance = 2
+			}
+
+			if distance <= maxDistance || strings.HasPrefix(lower, candidateLower) || strings.HasPrefix(candidateLower, lower) {
+				suggestions = append(suggestions, suggestion{name: candidate, distance: distance})
+			}
+		}
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return suggestions[i].distance < suggestions[j].distance
+	})
+
+	names := make([]string, 0, 3)
+	for _, s := range suggestions {
+		if len(names) == 3 {
+			break
+		}
+		names = append(names, s.name)
+	}
+
+	return names
+}
+
+// levenshtein returns the number of single character insertions, deletions
+// and substitutions turning a into b.
+func levenshtein(a string, b string) int {
+	ar, br := []rune(a), []rune(b)
+
+	previous := make([]int, len(br)+1)
+	current := make([]int, len(br)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ar); i++ {
+		current[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(br)]
+}
+
+func quote(names []string) []string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = fmt.Sprintf("%q", name)
+	}
+
+	return quoted
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestResolveProvider(t *testing.T) {
+	cfg := LLMConfig{
+		Providers: map[string]ProviderConfig{
+			"claude":      {Type: "openai", Model: "anthropic/claude-3.5-sonnet", Aliases: []string{"sonnet"}},
+			"gpt-4o-mini": {Type: "openai", Model: "openai/gpt-4o-mini"},
+			"ollama":      {Type: "ollama", Model: "qwen2.5-coder:32b"},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		want        string
+		suggestions []string
+	}{
+		{"claude", "claude", nil},
+		{"sonnet", "claude", nil},
+		{"Claude", "claude", nil},
+		{"SONNET", "claude", nil},
+		{"openai/GPT-4o-mini", "gpt-4o-mini", nil},
+		{"claude-3.5", "", []string{"claude"}},
+		{"olama", "", []string{"ollama"}},
+		{"gpt4o-mini", "", []string{"gpt-4o-mini"}},
+		{"mistral", "", []string{}},
+	}
+
+	for _, tt := range tests {
+		got, provider, err := cfg.ResolveProvider(tt.name)
+		if tt.suggestions == nil {
+			if err != nil || got != tt.want || provider.Type == "" {
+				t.Errorf("ResolveProvider(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+			}
+			continue
+		}
+
+		var unknown *UnknownProviderError
+		if !errors.As(err, &unknown) {
+			t.Errorf("ResolveProvider(%q) error = %v, want an UnknownProviderError", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(unknown.Suggestions, tt.suggestions) {
+			t.Errorf("ResolveProvider(%q) suggestions = %v, want %v", tt.name, unknown.Suggestions, tt.suggestions)
+		}
+	}
+}
+
+func TestUnknownProviderErrorMessage(t *testing.T) {
+	err := &UnknownProviderError{Name: "claude-3.5", Suggestions: []string{"claude", "sonnet"}}
+
+	want := `unknown model "claude-3.5", did you mean "claude" or "sonnet"?`
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
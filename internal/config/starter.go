@@ -0,0 +1,174 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultOllamaURL is where a local Ollama listens unless OLLAMA_HOST says
+// otherwise.
+const DefaultOllamaURL = "http://localhost:11434"
+
+// Environment is what a starter configuration is tailored to.
+type Environment struct {
+	// OllamaURL is the address of a running Ollama, empty when none answered.
+	OllamaURL string
+
+	// OllamaModels are the models the running Ollama has pulled.
+	OllamaModels []string
+
+	// OpenAIKey and OpenRouterKey report whether OPENAI_API_KEY and
+	// OPENROUTER_API_KEY are set.
+	OpenAIKey     bool
+	OpenRouterKey bool
+}
+
+// GlobalConfigFile returns the path of the global configuration file.
+func GlobalConfigFile() (string, error) {
+	return getConfigFile("ai-stdio", "config.yaml")
+}
+
+// DetectEnvironment looks for a running Ollama, at OLLAMA_HOST or the
+// default address, and for provider API keys in the environment.
+func DetectEnvironment(ctx context.Context) Environment {
+	env := Environment{
+		OpenAIKey:     os.Getenv("OPENAI_API_KEY") != "",
+		OpenRouterKey: os.Getenv("OPENROUTER_API_KEY") != "",
+	}
+
+	ollamaURL := DefaultOllamaURL
+	if host := os.Getenv("OLLAMA_HOST"); host != "" {
+		ollamaURL = host
+		if !strings.Contains(ollamaURL, "://") {
+			ollamaURL = "http://" + ollamaURL
+		}
+	}
+	ollamaURL = strings.TrimRight(ollamaURL, "/")
+
+	if models, err := ollamaModels(ctx, ollamaURL); err == nil {
+		env.OllamaURL = ollamaURL
+		env.OllamaModels = models
+	}
+
+	return env
+}
+
+func ollamaModels(ctx context.Context, baseURL string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, err
+	}
+
+	models := make([]string, 0, len(tags.Models))
+	for _, model := range tags.Models {
+		models = append(models, model.Name)
+	}
+
+	return models, nil
+}
+
+// Starter returns a commented starter configuration with a provider for each
+// service found in env. The first found becomes the default; a local Ollama
+// is configured, commented out, when nothing was found.
+func Starter(env Environment) string {
+	var b strings.Builder
+	var defaultProvider string
+
+	b.WriteString("# ai-stdio configuration, see `ai-stdio config show --resolved` for the\n")
+	b.WriteString("# effective values and `ai-stdio doctor` to check them.\n")
+	b.WriteString("#\n")
+	b.WriteString("# Secrets may be given as $ENV:NAME, $FILE:path or $CMD:command, and\n")
+	b.WriteString("# ${NAME} is replaced by the environment variable anywhere in a value.\n\n")
+
+	var providers strings.Builder
+	if env.OllamaURL != "" {
+		model := "llama3.2"
+		if len(env.OllamaModels) > 0 {
+			model = env.OllamaModels[0]
+		}
+
+		defaultProvider = "ollama"
+		fmt.Fprintf(&providers, "    # A local Ollama, found running at %s\n", env.OllamaURL)
+		fmt.Fprintf(&providers, "    ollama:\n      type: ollama\n      model: %s\n      params:\n        base_url: %s\n", model, env.OllamaURL)
+		if len(env.OllamaModels) > 1 {
+			fmt.Fprintf(&providers, "      # Also pulled: %s\n", strings.Join(env.OllamaModels[1:], ", "))
+		}
+	}
+
+	if env.OpenAIKey {
+		if defaultProvider == "" {
+			defaultProvider = "openai"
+		}
+		providers.WriteString("    # OpenAI, using OPENAI_API_KEY from the environment\n")
+		providers.WriteString("    openai:\n      type: openai\n      model: gpt-4o-mini\n      params:\n        api_key: $ENV:OPENAI_API_KEY\n")
+	}
+
+	if env.OpenRouterKey {
+		if defaultProvider == "" {
+			defaultProvider = "openrouter"
+		}
+		providers.WriteString("    # OpenRouter, using OPENROUTER_API_KEY from the environment\n")
+		providers.WriteString("    openrouter:\n      type: openai\n      model: openai/gpt-4o-mini\n      params:\n        base_url: https://openrouter.ai/api/v1\n        api_key: $ENV:OPENROUTER_API_KEY\n")
+	}
+
+	b.WriteString("llm:\n")
+	if defaultProvider == "" {
+		b.WriteString("  # No running Ollama or API keys were found. Uncomment and adjust a\n")
+		b.WriteString("  # provider below, until then the built-in local Ollama is used.\n")
+		b.WriteString("  #\n")
+		b.WriteString("  # default_provider: ollama\n")
+		b.WriteString("  # providers:\n")
+		b.WriteString("  #   ollama:\n  #     type: ollama\n  #     model: llama3.2\n  #     params:\n")
+		fmt.Fprintf(&b, "  #       base_url: %s\n", DefaultOllamaURL)
+	} else {
+		fmt.Fprintf(&b, "  # The provider used when a chat names no model\n  default_provider: %s\n", defaultProvider)
+		b.WriteString("  providers:\n")
+		b.WriteString(providers.String())
+	}
+
+	b.WriteString("  # Files matching these patterns are left out of +files and +glob\n")
+	b.WriteString("  glob_ignore:\n    - _test.go\n\n")
+
+	b.WriteString("# Secrets found in content are replaced before it is sent\n")
+	b.WriteString("redact:\n  disabled: false\n\n")
+
+	b.WriteString("resources:\n")
+	b.WriteString("  # Resources fetched at once\n  concurrency: 4\n")
+	b.WriteString("  # Fail the send when a resource cannot be fetched\n  strict: false\n")
+	b.WriteString("  # Replay the resources fetched for earlier turns\n  snapshot: false\n\n")
+
+	b.WriteString("# Read unsaved buffers from Acme: auto, on or off\n")
+	b.WriteString("buffers:\n  acme: auto\n\n")
+
+	b.WriteString("# Where +files finds open files: auto, acme, list or git\n")
+	b.WriteString("files:\n  source: auto\n")
+
+	return b.String()
+}
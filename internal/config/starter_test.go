@@ -0,0 +1,69 @@
+package config
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestDetectEnvironment(t *testing.T) {
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"models":[{"name":"qwen2.5-coder:7b"},{"name":"llama3.2:latest"}]}`))
+	}))
+	defer ollama.Close()
+
+	t.Setenv("OLLAMA_HOST", ollama.URL)
+	t.Setenv("OPENAI_API_KEY", "")
+	t.Setenv("OPENROUTER_API_KEY", "sk-or-test")
+
+	got := DetectEnvironment(context.Background())
+	want := Environment{
+		OllamaURL:     ollama.URL,
+		OllamaModels:  []string{"qwen2.5-coder:7b", "llama3.2:latest"},
+		OpenRouterKey: true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DetectEnvironment() = %+v, want %+v", got, want)
+	}
+
+	ollama.Close()
+	if got := DetectEnvironment(context.Background()); got.OllamaURL != "" {
+		t.Errorf("DetectEnvironment() OllamaURL = %q with Ollama stopped, want none", got.OllamaURL)
+	}
+}
+
+func TestStarter(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "sk-test")
+	t.Setenv("OPENROUTER_API_KEY", "sk-or-test")
+
+	tests := []struct {
+		env             Environment
+		defaultProvider string
+		providers       int
+	}{
+		{Environment{}, "", 0},
+		{Environment{OllamaURL: "http://localhost:11434", OllamaModels: []string{"qwen2.5-coder:7b", "llama3.2:latest"}}, "ollama", 1},
+		{Environment{OpenAIKey: true, OpenRouterKey: true}, "openai", 2},
+		{Environment{OllamaURL: "http://localhost:11434", OpenRouterKey: true}, "ollama", 2},
+	}
+
+	for _, tt := range tests {
+		c := Config{}
+		if err := yaml.UnmarshalStrict([]byte(Starter(tt.env)), &c); err != nil {
+			t.Fatalf("Starter(%+v) is not valid: %v", tt.env, err)
+		}
+		if c.LLM.DefaultProvider != tt.defaultProvider || len(c.LLM.Providers) != tt.providers {
+			t.Errorf("Starter(%+v) = %q with %d providers, want %q with %d", tt.env, c.LLM.DefaultProvider, len(c.LLM.Providers), tt.defaultProvider, tt.providers)
+		}
+
+		if tt.providers > 0 {
+			if problems := c.Validate(); len(problems) != 0 {
+				t.Errorf("Starter(%+v) problems = %v", tt.env, problems)
+			}
+		}
+	}
+}
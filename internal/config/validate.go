@@ -0,0 +1,305 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Problem is a mistake found in the configuration, with how to fix it.
+type Problem struct {
+	// Path is the dotted path of the offending value.
+	Path string
+
+	// Source is the layer, or the file of the layer, the value came from.
+	Source string
+
+	Message string
+	Fix     string
+}
+
+func (p Problem) String() string {
+	s := p.Path + ": " + p.Message
+	if p.Source != "" {
+		s += " (" + p.Source + ")"
+	}
+
+	return s
+}
+
+// Validate checks every layer for keys the configuration does not know and
+// the merged configuration for missing, invalid or unresolvable values.
+func (r *Resolved) Validate() []Problem {
+	problems := make([]Problem, 0)
+
+	for _, layer := range r.Layers {
+		source := layer.Name
+		if layer.Path != "" {
+			source = layer.Path
+		}
+
+		for _, path := range unknownKeys("", layer.values, reflect.TypeOf(Config{})) {
+			problems = append(problems, Problem{
+				Path:    path,
+				Source:  source,
+				Message: "unknown key",
+				Fix:     "remove it or check its spelling and indentation",
+			})
+		}
+
+		for _, path := range layer.refused {
+			problems = append(problems, Problem{
+				Path:    path,
+				Source:  source,
+				Message: "ignored, only the global configuration may set it",
+				Fix:     "move it to the global configuration file if you trust it",
+			})
+		}
+
+		for _, path := range layer.references {
+			problems = append(problems, Problem{
+				Path:    path,
+				Source:  source,
+				Message: "ignored, only the global configuration may refer to secrets",
+				Fix:     "write the value itself, or move it to the global configuration file",
+			})
+		}
+	}
+
+	return append(problems, r.Config.Validate()...)
+}
+
+// Validate checks the configuration for missing, invalid or unresolvable
+// values.
+func (c Config) Validate() []Problem {
+	problems := make([]Problem, 0)
+	add := func(path string, message string, fix string) {
+		problems = append(problems, Problem{Path: path, Message: message, Fix: fix})
+	}
+
+	names := make([]string, 0, len(c.LLM.Providers))
+	for name := range c.LLM.Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(names) == 0 {
+		add("llm.providers", "no providers are configured", "add a provider with a type and model under llm.providers")
+	} else if c.LLM.DefaultProvider == "" {
+		add("llm.default_provider", "no default provider", "set it to one of: "+strings.Join(names, ", "))
+	} else if _, _, err := c.LLM.ResolveProvider(c.LLM.DefaultProvider); err != nil {
+		add("llm.default_provider", err.Error(), "set it to one of: "+strings.Join(names, ", "))
+	}
+
+	claimed := make(map[string]string)
+	for _, name := range names {
+		claimed[name] = name
+	}
+	for _, name := range names {
+		for _, alias := range c.LLM.Providers[name].Aliases {
+			if owner, ok := claimed[alias]; ok && owner != name {
+				add("llm.providers."+name+".aliases", fmt.Sprintf("%q is already the name or an alias of %s", alias, owner), "remove one of them")
+			}
+			claimed[alias] = name
+		}
+	}
+
+	for _, name := range names {
+		provider := c.LLM.Providers[name]
+		path := "llm.providers." + name
+
+		if provider.Type == "" {
+			add(path+".type", "missing", "set it to the kind of provider, such as ollama or openai")
+		}
+		if provider.Model == "" {
+			add(path+".model", "missing", "set it to the name of the model to use")
+		}
+
+		params := make([]string, 0, len(provider.Params))
+		for key := range provider.Params {
+			params = append(params, key)
+		}
+		sort.Strings(params)
+
+		for _, key := range params {
+			value, ok := provider.Params[key].(string)
+			if !ok {
+				continue
+			}
+			paramPath := path + ".params." + key
+
+			// Commands are left to run when the provider is created
+			if strings.HasPrefix(value, "$CMD:") {
+				continue
+			}
+
+			expanded, err := ExpandString(value)
+			if err != nil {
+				add(paramPath, err.Error(), "set the variable or create the file it refers to before running ai-stdio")
+				continue
+			}
+
+			if strings.HasSuffix(key, "url") {
+				if err := checkURL(expanded); err != nil {
+					add(paramPath, err.Error(), "use an absolute http or https URL, such as http://localhost:11434")
+				}
+			}
+		}
+	}
+
+	for i, pattern := range c.Redact.Patterns {
+		path := fmt.Sprintf("redact.patterns[%d]", i)
+		if pattern.Name == "" {
+			add(path+".name", "missing", "name the pattern, it appears in the redaction placeholder")
+		}
+		if _, err := regexp.Compile(pattern.Regex); err != nil {
+			add(path+".regex", fmt.Sprintf("invalid regular expression: %v", err), "fix the expression, quoting it in single quotes")
+		}
+	}
+
+	if c.Resources.Concurrency < 0 {
+		add("resources.concurrency", "must not be negative", "set it to the number of resources to fetch at once")
+	}
+
+	if c.Agent.MaxSteps < 0 {
+		add("agent.max_steps", "must not be negative", "set it to the number of replies the model may make")
+	}
+
+	if c.Agent.AutoApprove && !c.Agent.Write {
+		add("agent.auto_approve", "has no effect without agent.write", "set agent.write: true or remove agent.auto_approve")
+	}
+
+	servers := make([]string, 0, len(c.MCP.Servers))
+	for name := range c.MCP.Servers {
+		servers = append(servers, name)
+	}
+	sort.Strings(servers)
+
+	for _, name := range servers {
+		if c.MCP.Servers[name].Command == "" {
+			add("mcp.servers."+name+".command", "missing", "set it to the program that runs the server")
+		}
+		if strings.Contains(name, ":") {
+			add("mcp.servers."+name, "the name contains a colon, which +mcp separates the server from the URI with", "rename the server")
+		}
+	}
+
+	if c.Serve.Token != "" && !strings.HasPrefix(c.Serve.Token, "$CMD:") {
+		if _, err := ExpandString(c.Serve.Token); err != nil {
+			add("serve.token", err.Error(), "set the variable or create the file it refers to before running ai-stdio serve")
+		}
+	}
+
+	budgets := make([]string, 0, len(c.Serve.Budgets))
+	for name := range c.Serve.Budgets {
+		budgets = append(budgets, name)
+	}
+	sort.Strings(budgets)
+
+	for _, name := range budgets {
+		path := "serve.budgets." + name
+		if _, ok := c.LLM.Providers[name]; !ok {
+			add(path, "no provider is configured by this name", "name the budget after one of: "+strings.Join(names, ", "))
+		}
+
+		budget := c.Serve.Budgets[name]
+		if budget.RequestsPerHour < 0 || budget.RequestsPerDay < 0 {
+			add(path, "requests must not be negative", "set the number of requests to allow, or 0 for no cap")
+		}
+	}
+
+	if !oneOf(c.Buffers.Acme, "", "auto", "on", "off") {
+		add("buffers.acme", fmt.Sprintf("invalid value %q", c.Buffers.Acme), "set it to auto, on or off")
+	}
+
+	if !oneOf(c.Files.Source, "", "auto", "acme", "list", "git") {
+		add("files.source", fmt.Sprintf("invalid value %q", c.Files.Source), "set it to auto, acme, list or git")
+	}
+
+	return problems
+}
+
+func checkURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %v", err)
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid URL %q", value)
+	}
+
+	return nil
+}
+
+func oneOf(value string, allowed ...string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+
+	return false
+}
+
+// unknownKeys returns the dotted paths of the keys in values that have no
+// matching field in t, following nested sections, maps and lists.
+func unknownKeys(prefix string, values map[interface{}]interface{}, t reflect.Type) []string {
+	unknown := make([]string, 0)
+
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if tag != "" && tag != "-" {
+			fields[tag] = t.Field(i).Type
+		}
+	}
+
+	for key, value := range values {
+		name := fmt.Sprint(key)
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+
+		fieldType, ok := fields[name]
+		if !ok {
+			unknown = append(unknown, path)
+			continue
+		}
+
+		switch fieldType.Kind() {
+		case reflect.Struct:
+			if child, ok := value.(map[interface{}]interface{}); ok {
+				unknown = append(unknown, unknownKeys(path, child, fieldType)...)
+			}
+		case reflect.Map:
+			if fieldType.Elem().Kind() != reflect.Struct {
+				continue
+			}
+			children, _ := value.(map[interface{}]interface{})
+			for childKey, childValue := range children {
+				if child, ok := childValue.(map[interface{}]interface{}); ok {
+					unknown = append(unknown, unknownKeys(fmt.Sprintf("%s.%v", path, childKey), child, fieldType.Elem())...)
+				}
+			}
+		case reflect.Slice:
+			if fieldType.Elem().Kind() != reflect.Struct {
+				continue
+			}
+			items, _ := value.([]interface{})
+			for i, item := range items {
+				if child, ok := item.(map[interface{}]interface{}); ok {
+					unknown = append(unknown, unknownKeys(fmt.Sprintf("%s[%d]", path, i), child, fieldType.Elem())...)
+				}
+			}
+		}
+	}
+
+	sort.Strings(unknown)
+
+	return unknown
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"os"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	configHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	t.Setenv("AI_STDIO_TEST_UNSET", "")
+	os.Unsetenv("AI_STDIO_TEST_UNSET")
+
+	writeFile(t, configHome+"/ai-stdio/config.yaml", `
+llm:
+  default_provider: locl
+  providers:
+    local:
+      type: ollama
+      model: llama3
+      aliases: [remote]
+      params:
+        base_url: localhost:11434
+    remote:
+      type: openai
+      params:
+        api_key: $ENV:AI_STDIO_TEST_UNSET
+      temperature: 0.2
+redact:
+  patterns:
+    - name: token
+      regex: '[unclosed'
+      flags: i
+buffers:
+  acme: sometimes
+resource:
+  strict: true
+agent:
+  auto_approve: true
+mcp:
+  servers:
+    issues:
+      args: [serve]
+serve:
+  token: $ENV:AI_STDIO_TEST_UNSET
+  budgets:
+    remot:
+      requests_per_hour: 10
+    local:
+      requests_per_day: -1
+`)
+
+	resolved, err := Resolve(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+
+	got := make(map[string]string)
+	for _, problem := range resolved.Validate() {
+		got[problem.Path] = problem.Message
+		if problem.Fix == "" {
+			t.Errorf("problem %v has no fix", problem)
+		}
+	}
+
+	want := []string{
+		"llm.default_provider",
+		"llm.providers.local.aliases",
+		"llm.providers.local.params.base_url",
+		"llm.providers.remote.model",
+		"llm.providers.remote.params.api_key",
+		"llm.providers.remote.temperature",
+		"redact.patterns[0].regex",
+		"redact.patterns[0].flags",
+		"buffers.acme",
+		"resource",
+		"agent.auto_approve",
+		"mcp.servers.issues.command",
+		"serve.token",
+		"serve.budgets.remot",
+		"serve.budgets.local",
+	}
+	for _, path := range want {
+		if _, ok := got[path]; !ok {
+			t.Errorf("Validate() did not report %s, got %v", path, got)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("Validate() = %v, want problems with %v", got, want)
+	}
+}
+
+func TestValidateDefaults(t *testing.T) {
+	c := Config{
+		LLM: LLMConfig{
+			DefaultProvider: "local",
+			Providers: map[string]ProviderConfig{
+				"local": {Type: "ollama", Model: "llama3", Params: map[string]interface{}{"base_url": "http://localhost:11434"}},
+			},
+		},
+	}
+
+	if problems := c.Validate(); len(problems) != 0 {
+		t.Errorf("Validate() = %v, want no problems", problems)
+	}
+}
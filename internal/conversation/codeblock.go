@@ -0,0 +1,133 @@
+package conversation
+
+import (
+	"strings"
+)
+
+// CodeBlock is a fenced code block of a message.
+type CodeBlock struct {
+	// Language is the first word of the info string, such as go or diff.
+	Language string
+
+	// Path is the file the block is annotated with, by file=, path= or
+	// filename= in the info string or as language:path.
+	Path string
+
+	Content string
+}
+
+// IsDiff reports whether the block is a unified diff rather than the content
+// of a file.
+func (b CodeBlock) IsDiff() bool {
+	if b.Language == "diff" || b.Language == "patch" {
+		return true
+	}
+
+	return b.Path == "" && strings.HasPrefix(b.Content, "--- ") && strings.Contains(b.Content, "\n+++ ")
+}
+
+// CodeBlocks returns the fenced code blocks of the message.
+func (m *Message) CodeBlocks() []CodeBlock {
+	return ParseCodeBlocks(m.Content)
+}
+
+// ParseCodeBlocks returns the fenced code blocks of markdown text, in order.
+// Blocks are fenced by three or more backticks or tildes, and closed by a
+// fence of the same character at least as long, so a block may hold shorter
+// fences. A block left open runs to the end of the text.
+func ParseCodeBlocks(text string) []CodeBlock {
+	blocks := make([]CodeBlock, 0)
+
+	var block *CodeBlock
+	var content strings.Builder
+	fenceChar, fenceLen := byte(0), 0
+
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		if block == nil {
+			char, length, info, ok := parseFence(line)
+			if !ok {
+				continue
+			}
+
+			language, path := parseInfo(info)
+			block = &CodeBlock{Language: language, Path: path}
+			fenceChar, fenceLen = char, length
+			content.Reset()
+			continue
+		}
+
+		if char, length, info, ok := parseFence(line); ok && char == fenceChar && length >= fenceLen && info == "" {
+			block.Content = content.String()
+			blocks = append(blocks, *block)
+			block = nil
+			continue
+		}
+
+		content.WriteString(line + "\n")
+	}
+
+	if block != nil {
+		block.Content = content.String()
+		blocks = append(blocks, *block)
+	}
+
+	return blocks
+}
+
+// parseFence returns the character and length of the fence a line opens or
+// closes a code block with, and the info string following it.
+func parseFence(line string) (byte, int, string, bool) {
+	// A fence may be indented by up to three spaces
+	indent := len(line) - len(strings.TrimLeft(line, " "))
+	if indent > 3 {
+		return 0, 0, "", false
+	}
+	line = line[indent:]
+
+	if len(line) < 3 || (line[0] != '`' && line[0] != '~') {
+		return 0, 0, "", false
+	}
+
+	char := line[0]
+	length := len(line) - len(strings.TrimLeft(line, string(char)))
+	if length < 3 {
+		return 0, 0, "", false
+	}
+
+	info := strings.TrimSpace(line[length:])
+	if char == '`' && strings.Contains(info, "`") {
+		return 0, 0, "", false
+	}
+
+	return char, length, info, true
+}
+
+// parseInfo returns the language and file annotation of an info string, such
+// as "go file=internal/x.go" or "go:internal/x.go".
+func parseInfo(info string) (string, string) {
+	fields := strings.Fields(info)
+	if len(fields) == 0 {
+		return "", ""
+	}
+
+	language, path := fields[0], ""
+	if strings.Contains(language, "=") {
+		language = ""
+	} else if lang, p, ok := strings.Cut(language, ":"); ok {
+		language, path = lang, p
+	}
+
+	for _, field := range fields {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+
+		switch key {
+		case "file", "path", "filename":
+			path = strings.Trim(value, `"'`)
+		}
+	}
+
+	return language, path
+}
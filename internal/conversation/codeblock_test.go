@@ -0,0 +1,88 @@
+package conversation
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCodeBlocks(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []CodeBlock
+	}{
+		{
+			name: "none",
+			text: "Just text, with `inline code`.\n",
+			want: []CodeBlock{},
+		},
+		{
+			name: "language and file",
+			text: "Here:\n\n```go file=internal/x.go\npackage x\n```\n\nand\n\n```\nplain\n```\n",
+			want: []CodeBlock{
+				{Language: "go", Path: "internal/x.go", Content: "package x\n"},
+				{Content: "plain\n"},
+			},
+		},
+		{
+			name: "path forms",
+			text: "```go:a.go\na\n```\n```python path=\"b.py\"\nb\n```\n```filename=c.txt\nc\n```\n",
+			want: []CodeBlock{
+				{Language: "go", Path: "a.go", Content: "a\n"},
+				{Language: "python", Path: "b.py", Content: "b\n"},
+				{Path: "c.txt", Content: "c\n"},
+			},
+		},
+		{
+			name: "nested fence",
+			text: "````markdown file=README.md\n# Title\n\n```sh\nmake\n```\n````\n",
+			want: []CodeBlock{
+				{Language: "markdown", Path: "README.md", Content: "# Title\n\n```sh\nmake\n```\n"},
+			},
+		},
+		{
+			name: "tildes and indentation",
+			text: "~~~diff\n--- a/x\n+++ b/x\n  ~~~\n",
+			want: []CodeBlock{
+				{Language: "diff", Content: "--- a/x\n+++ b/x\n"},
+			},
+		},
+		{
+			name: "unclosed",
+			text: "```go\nfunc f() {\n",
+			want: []CodeBlock{
+				{Language: "go", Content: "func f() {\n"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseCodeBlocks(tt.text)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseCodeBlocks() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCodeBlockIsDiff(t *testing.T) {
+	tests := []struct {
+		name  string
+		block CodeBlock
+		want  bool
+	}{
+		{"diff language", CodeBlock{Language: "diff", Content: "-a\n+b\n"}, true},
+		{"unlabelled diff", CodeBlock{Content: "--- a/x.go\n+++ b/x.go\n@@ -1 +1 @@\n"}, true},
+		{"file content", CodeBlock{Language: "go", Path: "x.go", Content: "package x\n"}, false},
+		{"diff-like file", CodeBlock{Path: "x.patch", Content: "--- a/x.go\n+++ b/x.go\n"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.block.IsDiff(); got != tt.want {
+				t.Errorf("IsDiff() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"strings"
 	"time"
+
+	"github.com/jcowgar/acme-utils/internal/llm/types"
 )
 
 const (
@@ -23,15 +25,18 @@ type ReferenceMaterial struct {
 
 // Conversation represents the entire chat interaction
 type Conversation struct {
-	Title             string
-	Prompt            string
-	Model             string
-	ProjectDirectory  string
-	Parameters        map[string]interface{}
-	Messages          []Message
-	ReferenceMaterial []ReferenceMaterial
-	IncludeFiles      bool
-	ResourceRequests  []ResourceRequest
+	Title  string
+	Prompt string // The system prompt, from the Prompt section
+
+	// Template names the prompt template used when there is no Prompt
+	// section
+	Template string
+
+	Model            string
+	ProjectDirectory string
+	Parameters       map[string]interface{}
+	Messages         []Message
+	IncludeFiles     bool
 }
 
 // Message represents a single message in the conversation
@@ -39,34 +44,192 @@ type Message struct {
 	Role      string    // "user" or "assistant"
 	Content   string    // The actual message content
 	Timestamp time.Time // Optional, for future use
+
+	// ResourceRequests are the resource directives written in this message.
+	ResourceRequests []ResourceRequest
+
+	// ReferenceMaterial is the content fetched for this message's requests.
+	ReferenceMaterial []ReferenceMaterial
+
+	// IncludeFiles is set when the message contains the +files directive.
+	IncludeFiles bool
+
+	// Refresh is set when the message contains the +refresh directive,
+	// asking for snapshotted resources of earlier turns to be re-read.
+	Refresh bool
+
+	// Approve is set when the message contains the +approve directive,
+	// approving the changes and commands proposed in the previous turn.
+	Approve bool
+
+	// ToolCalls are the tools a response asks to be called.
+	ToolCalls []types.ToolCall
+
+	// ToolCallID is the call a Tool message is the result of.
+	ToolCallID string
 }
 
-// AddReferenceMaterial adds a new resource to the conversation
-func (c *Conversation) AddReferenceMaterial(typ string, name string, content string) {
-	if c.ReferenceMaterial == nil {
-		c.ReferenceMaterial = make([]ReferenceMaterial, 0)
-	}
-	c.ReferenceMaterial = append(c.ReferenceMaterial, ReferenceMaterial{
+// AddReferenceMaterial attaches a new resource to the message
+func (m *Message) AddReferenceMaterial(typ string, name string, content string) {
+	m.ReferenceMaterial = append(m.ReferenceMaterial, ReferenceMaterial{
 		Name:    name,
 		Content: content,
 		Typ:     typ,
 	})
 }
 
+// Text returns the message content without its directive lines. Only the
+// user's messages hold directives, the text of responses and tool results
+// is kept whole.
+func (m *Message) Text() string {
+	if m.Role != "You" {
+		return strings.TrimSpace(m.Content)
+	}
+
+	lines := strings.Split(m.Content, "\n")
+	kept := make([]string, 0, len(lines))
+
+	for _, line := range lines {
+		if !isDirective(line) {
+			kept = append(kept, line)
+		}
+	}
+
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
+
+// isDirective reports whether a line is an instruction to ai-stdio rather
+// than text for the model.
+func isDirective(line string) bool {
+	if parseResourceDirective(line) != nil {
+		return true
+	}
+
+	switch strings.TrimSpace(line) {
+	case "+files", "+refresh", "+approve":
+		return true
+	}
+
+	return false
+}
+
+// containsLine reports whether content has a line consisting only of line
+func containsLine(content string, line string) bool {
+	for _, l := range strings.Split(content, "\n") {
+		if strings.TrimSpace(l) == line {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseResourceDirective returns the resource request for a +file, +url,
+// +glob or +mcp line, or nil if the line is not one.
+func parseResourceDirective(line string) ResourceRequest {
+	if strings.HasPrefix(line, "+file ") {
+		return FileResourceRequest{
+			Filename: strings.TrimPrefix(line, "+file "),
+		}
+	} else if strings.HasPrefix(line, "+url ") {
+		return URLResourceRequest{
+			URL: strings.TrimPrefix(line, "+url "),
+		}
+	} else if strings.HasPrefix(line, "+glob ") {
+		return FileGlobResourceRequest{
+			Pattern: strings.TrimPrefix(line, "+glob "),
+		}
+	} else if strings.HasPrefix(line, "+mcp ") {
+		server, uri, ok := strings.Cut(strings.TrimSpace(strings.TrimPrefix(line, "+mcp ")), ":")
+		if ok && server != "" && uri != "" {
+			return MCPResourceRequest{Server: server, URI: uri}
+		}
+	}
+
+	return nil
+}
+
 // ParseContent parses the markdown content and returns a Conversation
 func ParseContent(content string) (*Conversation, error) {
 	scanner := bufio.NewScanner(strings.NewReader(content))
 	conv := &Conversation{
-		Messages:          make([]Message, 0),
-		Parameters:        make(map[string]interface{}),
-		ReferenceMaterial: make([]ReferenceMaterial, 0),
-		IncludeFiles:      false,
+		Messages:     make([]Message, 0),
+		Parameters:   make(map[string]interface{}),
+		IncludeFiles: false,
 	}
 
 	var currentRole string
 	var currentContent strings.Builder
+	var currentRequests []ResourceRequest
+	var currentToolCalls []types.ToolCall
+	var currentToolCallID string
 	inFrontMatter := false
 
+	// The tool call whose arguments are being read, and the fence of the
+	// code block holding them or a tool result
+	var toolCall *types.ToolCall
+	var toolCallArguments strings.Builder
+	codeFence := ""
+
+	// saveMessage appends the message being accumulated, if any, along with
+	// the resource requests made since the previous message was saved
+	saveMessage := func() {
+		if currentRole == "" || (currentContent.Len() == 0 && len(currentToolCalls) == 0) {
+			return
+		}
+
+		if currentRole == "Prompt" {
+			conv.Prompt = strings.TrimSpace(currentContent.String())
+			currentContent.Reset()
+			currentRequests = nil
+			return
+		}
+
+		message := Message{
+			Role:             currentRole,
+			Content:          strings.TrimSpace(currentContent.String()),
+			Timestamp:        time.Now(),
+			ResourceRequests: currentRequests,
+			ToolCalls:        currentToolCalls,
+		}
+
+		if currentRole == "Tool" {
+			message.Content = unfence(message.Content)
+			message.ToolCallID = currentToolCallID
+		}
+
+		// Check for "+files" in user messages
+		if currentRole == "You" && strings.Contains(message.Content, "+files") {
+			message.IncludeFiles = true
+			conv.IncludeFiles = true
+		}
+
+		if currentRole == "You" && containsLine(message.Content, "+refresh") {
+			message.Refresh = true
+		}
+
+		if currentRole == "You" && containsLine(message.Content, "+approve") {
+			message.Approve = true
+		}
+
+		conv.Messages = append(conv.Messages, message)
+		currentContent.Reset()
+		currentRequests = nil
+		currentToolCalls = nil
+	}
+
+	// finishToolCall records the tool call being read with its arguments
+	finishToolCall := func() {
+		toolCall.Arguments = strings.TrimSpace(toolCallArguments.String())
+		if toolCall.Arguments == "" {
+			toolCall.Arguments = "{}"
+		}
+
+		currentToolCalls = append(currentToolCalls, *toolCall)
+		toolCall = nil
+		toolCallArguments.Reset()
+	}
+
 	for scanner.Scan() {
 		line := scanner.Text()
 
@@ -90,6 +253,8 @@ func ParseContent(content string) (*Conversation, error) {
 					conv.Model = value
 				} else if key == "project_directory" {
 					conv.ProjectDirectory = value
+				} else if key == "template" {
+					conv.Template = value
 				} else {
 					conv.Parameters[key] = value
 				}
@@ -97,19 +262,63 @@ func ParseContent(content string) (*Conversation, error) {
 			continue
 		}
 
-		// Handle resource requests
-		if strings.HasPrefix(line, "+file ") {
-			conv.ResourceRequests = append(conv.ResourceRequests, FileResourceRequest{
-				Filename: strings.TrimPrefix(line, "+file "),
-			})
-		} else if strings.HasPrefix(line, "+url ") {
-			conv.ResourceRequests = append(conv.ResourceRequests, URLResourceRequest{
-				URL: strings.TrimPrefix(line, "+url "),
-			})
-		} else if strings.HasPrefix(line, "+glob") {
-			conv.ResourceRequests = append(conv.ResourceRequests, FileGlobResourceRequest{
-				Pattern: strings.TrimPrefix(line, "+glob "),
-			})
+		// Read the arguments of a tool call, the code block following its
+		// heading
+		if toolCall != nil {
+			if codeFence == "" {
+				if marker := fenceMarker(line); marker != "" {
+					codeFence = marker
+					continue
+				} else if strings.TrimSpace(line) == "" {
+					continue
+				}
+
+				// A call without arguments, the line belongs to the response
+				finishToolCall()
+			} else if strings.TrimSpace(line) == codeFence {
+				codeFence = ""
+				finishToolCall()
+				continue
+			} else {
+				toolCallArguments.WriteString(line + "\n")
+				continue
+			}
+		}
+
+		// Tool results are kept verbatim within their code block, headings
+		// and all
+		if currentRole == "Tool" {
+			if codeFence == "" && strings.TrimSpace(currentContent.String()) == "" {
+				codeFence = fenceMarker(line)
+			} else if codeFence != "" {
+				if strings.TrimSpace(line) == codeFence {
+					codeFence = ""
+				}
+				currentContent.WriteString(line + "\n")
+				continue
+			}
+		}
+
+		if currentRole == "Response" {
+			if name, id, ok := parseToolCallHeading(line); ok {
+				toolCall = &types.ToolCall{ID: id, Name: name}
+				continue
+			}
+		}
+
+		if id, ok := parseToolResultHeading(line); ok {
+			saveMessage()
+			currentRole = "Tool"
+			currentToolCallID = id
+			continue
+		}
+
+		// Handle resource requests, which belong to the message they are
+		// written in. Responses and tool results cannot request resources.
+		if currentRole != "Response" && currentRole != "Tool" {
+			if req := parseResourceDirective(line); req != nil {
+				currentRequests = append(currentRequests, req)
+			}
 		}
 
 		// Handle title (first level heading)
@@ -118,17 +327,16 @@ func ParseContent(content string) (*Conversation, error) {
 			continue
 		}
 
+		// Handle the system prompt, which precedes the messages
+		if strings.HasPrefix(line, "## Prompt") && len(conv.Messages) == 0 && currentRole == "" {
+			currentRole = "Prompt"
+			continue
+		}
+
 		// Handle message start (second level heading)
 		if strings.HasPrefix(line, "## You") {
 			// Save previous message if exists
-			if currentRole != "" && currentContent.Len() > 0 {
-				conv.Messages = append(conv.Messages, Message{
-					Role:      currentRole,
-					Content:   strings.TrimSpace(currentContent.String()),
-					Timestamp: time.Now(),
-				})
-				currentContent.Reset()
-			}
+			saveMessage()
 			currentRole = "You"
 			continue
 		}
@@ -136,19 +344,7 @@ func ParseContent(content string) (*Conversation, error) {
 		// Handle response (third level heading)
 		if strings.HasPrefix(line, "### Response") {
 			// Save previous message if exists
-			if currentRole != "" && currentContent.Len() > 0 {
-				messageContent := strings.TrimSpace(currentContent.String())
-				conv.Messages = append(conv.Messages, Message{
-					Role:      currentRole,
-					Content:   messageContent,
-					Timestamp: time.Now(),
-				})
-				// Check for "+files" in user messages
-				if currentRole == "You" && strings.Contains(messageContent, "+files") {
-					conv.IncludeFiles = true
-				}
-				currentContent.Reset()
-			}
+			saveMessage()
 			currentRole = "Response"
 			continue
 		}
@@ -160,18 +356,10 @@ func ParseContent(content string) (*Conversation, error) {
 	}
 
 	// Add the last message if exists
-	if currentRole != "" && currentContent.Len() > 0 {
-		messageContent := strings.TrimSpace(currentContent.String())
-		conv.Messages = append(conv.Messages, Message{
-			Role:      currentRole,
-			Content:   messageContent,
-			Timestamp: time.Now(),
-		})
-		// Check for "+files" in the last user message
-		if currentRole == "You" && strings.Contains(messageContent, "+files") {
-			conv.IncludeFiles = true
-		}
+	if toolCall != nil {
+		finishToolCall()
 	}
+	saveMessage()
 
 	if len(conv.Messages) == 0 {
 		return nil, errors.New("no messages found in content")
@@ -180,6 +368,55 @@ func ParseContent(content string) (*Conversation, error) {
 	return conv, nil
 }
 
+// FrontMatter returns the keys and values of the front matter leading chat
+// content, or nil when there is none.
+func FrontMatter(content string) map[string]string {
+	lines := strings.Split(content, "\n")
+	if len(lines) == 0 || lines[0] != "---" {
+		return nil
+	}
+
+	frontMatter := make(map[string]string)
+	for _, line := range lines[1:] {
+		if line == "---" {
+			break
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) == 2 {
+			frontMatter[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+
+	return frontMatter
+}
+
+// SetFrontMatter returns chat content with the front matter key set to
+// value, adding the key, or the front matter itself, when missing.
+func SetFrontMatter(content string, key string, value string) string {
+	setting := fmt.Sprintf("%s: %s", key, value)
+	lines := strings.Split(content, "\n")
+
+	if len(lines) == 0 || lines[0] != "---" {
+		return "---\n" + setting + "\n---\n" + content
+	}
+
+	for i := 1; i < len(lines); i++ {
+		if lines[i] == "---" {
+			lines = append(lines[:i], append([]string{setting}, lines[i:]...)...)
+			break
+		}
+
+		parts := strings.SplitN(lines[i], ":", 2)
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) == key {
+			lines[i] = setting
+			break
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
 // GetLastUserMessage returns the content of the last user message
 func (c *Conversation) GetLastUserMessage() (string, error) {
 	for i := len(c.Messages) - 1; i >= 0; i-- {
@@ -205,11 +442,14 @@ func (c *Conversation) String() string {
 	var sb strings.Builder
 
 	// Write front matter only if model or parameters exist
-	if c.Model != "" || len(c.Parameters) > 0 {
+	if c.Model != "" || c.Template != "" || len(c.Parameters) > 0 {
 		sb.WriteString("---\n")
 		if c.Model != "" {
 			sb.WriteString(fmt.Sprintf("model: %s\n", c.Model))
 		}
+		if c.Template != "" {
+			sb.WriteString(fmt.Sprintf("template: %s\n", c.Template))
+		}
 		for key, value := range c.Parameters {
 			sb.WriteString(fmt.Sprintf("%s: %v\n", key, value))
 		}
@@ -221,14 +461,28 @@ func (c *Conversation) String() string {
 		sb.WriteString("# " + c.Title + "\n\n")
 	}
 
+	if c.Prompt != "" {
+		sb.WriteString("## Prompt\n\n" + c.Prompt + "\n\n")
+	}
+
 	// Write messages
 	for _, msg := range c.Messages {
-		if msg.Role == "You" {
+		switch msg.Role {
+		case "You":
 			sb.WriteString("## You\n\n")
-		} else {
+		case "Tool":
+			sb.WriteString(FormatToolResult(msg.ToolCallID, msg.Content) + "\n")
+			continue
+		default:
 			sb.WriteString("### Response\n\n")
 		}
-		sb.WriteString(msg.Content + "\n\n")
+
+		if msg.Content != "" {
+			sb.WriteString(msg.Content + "\n\n")
+		}
+		for _, call := range msg.ToolCalls {
+			sb.WriteString(FormatToolCall(call) + "\n")
+		}
 	}
 
 	return sb.String()
@@ -0,0 +1,112 @@
+package conversation
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/jcowgar/acme-utils/internal/config"
+)
+
+const defaultFetchConcurrency = 4
+
+// FetchResult holds the outcome of fetching a single resource request.
+type FetchResult struct {
+	Request   ResourceRequest
+	Resources []Resource
+	Err       error
+}
+
+// FetchResources fetches requests concurrently, bounded by the configured
+// concurrency, and returns one result per request in the order given.
+//
+// A request identical to an earlier one is not fetched again, and a resource
+// already returned for an earlier request, such as a file named by both
+// +file and +glob, is dropped from later results.
+func FetchResources(cfg *config.Config, projectDirectory string, requests []ResourceRequest) []FetchResult {
+	results := make([]FetchResult, len(requests))
+	firstByKey := make(map[string]int)
+	jobs := make(chan int)
+
+	concurrency := cfg.Resources.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultFetchConcurrency
+	}
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				resources, err := requests[i].Fetch(cfg, projectDirectory)
+				results[i].Resources = resources
+				results[i].Err = err
+			}
+		}()
+	}
+
+	for i, req := range requests {
+		results[i].Request = req
+
+		key := req.Key(projectDirectory)
+		if _, seen := firstByKey[key]; seen {
+			continue
+		}
+		firstByKey[key] = i
+
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	removeDuplicateResources(results)
+
+	return results
+}
+
+// removeDuplicateResources keeps only the first occurrence of each resource
+// source across all results.
+func removeDuplicateResources(results []FetchResult) {
+	seen := make(map[string]bool)
+
+	for i := range results {
+		unique := make([]Resource, 0, len(results[i].Resources))
+		for _, resource := range results[i].Resources {
+			if resource.Source != "" && seen[resource.Source] {
+				continue
+			}
+			seen[resource.Source] = true
+			unique = append(unique, resource)
+		}
+		results[i].Resources = unique
+	}
+}
+
+// FetchErrors combines the errors of all failed results, or returns nil if
+// every request succeeded.
+func FetchErrors(results []FetchResult) error {
+	errs := make([]error, 0)
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", describeRequest(result.Request), result.Err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func describeRequest(req ResourceRequest) string {
+	switch r := req.(type) {
+	case FileResourceRequest:
+		return "+file " + r.Filename
+	case FileGlobResourceRequest:
+		return "+glob " + r.Pattern
+	case URLResourceRequest:
+		return "+url " + r.URL
+	case MCPResourceRequest:
+		return "+mcp " + r.Server + ":" + r.URI
+	default:
+		return fmt.Sprintf("%T", req)
+	}
+}
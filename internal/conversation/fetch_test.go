@@ -0,0 +1,82 @@
+package conversation
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jcowgar/acme-utils/internal/buffer"
+	"github.com/jcowgar/acme-utils/internal/config"
+)
+
+func TestFetchResources(t *testing.T) {
+	projectDir := t.TempDir()
+	for _, name := range []string{"a.go", "b.go", "c.txt"} {
+		if err := os.WriteFile(filepath.Join(projectDir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A directory matching the glob is left out
+	if err := os.Mkdir(filepath.Join(projectDir, "vendor.go"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{}
+	cfg.Resources.Concurrency = 2
+
+	requests := []ResourceRequest{
+		FileResourceRequest{Filename: "c.txt"},
+		FileResourceRequest{Filename: "b.go"},
+		FileGlobResourceRequest{Pattern: "*.go"},
+		FileResourceRequest{Filename: "missing.txt"},
+		FileResourceRequest{Filename: filepath.Join(projectDir, "c.txt")},
+	}
+
+	results := FetchResources(cfg, projectDir, requests)
+	if len(results) != len(requests) {
+		t.Fatalf("FetchResources() returned %d results, want %d", len(results), len(requests))
+	}
+
+	var names []string
+	for _, result := range results {
+		for _, resource := range result.Resources {
+			names = append(names, resource.Name)
+		}
+	}
+
+	want := "c.txt,b.go,a.go"
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("FetchResources() resources = %s, want %s", got, want)
+	}
+
+	err := FetchErrors(results)
+	if err == nil || !strings.Contains(err.Error(), "+file missing.txt") {
+		t.Errorf("FetchErrors() = %v, want error naming missing.txt", err)
+	}
+}
+
+func TestFetchErrorsNone(t *testing.T) {
+	results := []FetchResult{{Request: URLResourceRequest{URL: "http://example.com"}}}
+	if err := FetchErrors(results); err != nil {
+		t.Errorf("FetchErrors() = %v, want nil", err)
+	}
+}
+
+func TestFetchFileBufferFallback(t *testing.T) {
+	projectDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(projectDir, "main.go"), []byte("on disk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(buffer.Reset)
+	buffer.Register(buffer.CommandSource{Command: "exit 127"})
+
+	resources, err := FileResourceRequest{Filename: "main.go"}.Fetch(&config.Config{}, projectDir)
+	if err != nil {
+		t.Fatalf("Fetch() with a failing buffer source error = %v", err)
+	}
+	if len(resources) != 1 || resources[0].Content != "on disk" {
+		t.Errorf("Fetch() = %+v, want the file read from disk", resources)
+	}
+}
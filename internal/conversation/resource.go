@@ -1,26 +1,34 @@
 package conversation
 
 import (
+	"context"
 	"fmt"
-	"io"
-	"net/http"
+	"log"
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
 
-	"github.com/jaytaylor/html2text"
+	"github.com/jcowgar/acme-utils/internal/buffer"
 	"github.com/jcowgar/acme-utils/internal/config"
+	"github.com/jcowgar/acme-utils/internal/mcp"
 )
 
 type Resource struct {
 	ResourceType string
 	Name         string
 	Content      string
+
+	// Source is the absolute path or URL the content came from, which
+	// identifies the resource when removing duplicates.
+	Source string
 }
 
 type ResourceRequest interface {
 	Fetch(cfg *config.Config, projectDirectory string) ([]Resource, error)
+
+	// Key identifies what the request will fetch, so that the same request
+	// made twice is only fetched once.
+	Key(projectDirectory string) string
 }
 
 type FileResourceRequest struct {
@@ -35,12 +43,34 @@ type URLResourceRequest struct {
 	URL string
 }
 
-func (r FileResourceRequest) Fetch(cfg *config.Config, projectDirectory string) ([]Resource, error) {
-	// Should look for an open Acme window with this filename. If found, the content
-	// should be taken directly from the buffer as to have the latest content.
-	// The content may not have been saved yet. If it is not an open window, then it
-	// should be read from disk.
+// MCPResourceRequest is a resource of a configured MCP server, requested
+// with +mcp server:uri.
+type MCPResourceRequest struct {
+	Server string
+	URI    string
+}
+
+func (r FileResourceRequest) Key(projectDirectory string) string {
+	if filepath.IsAbs(r.Filename) {
+		return "file:" + filepath.Clean(r.Filename)
+	}
+
+	return "file:" + filepath.Join(projectDirectory, r.Filename)
+}
+
+func (r FileGlobResourceRequest) Key(projectDirectory string) string {
+	return "glob:" + filepath.Join(projectDirectory, r.Pattern)
+}
 
+func (r URLResourceRequest) Key(projectDirectory string) string {
+	return "url:" + r.URL
+}
+
+func (r MCPResourceRequest) Key(projectDirectory string) string {
+	return "mcp:" + r.Server + ":" + r.URI
+}
+
+func (r FileResourceRequest) Fetch(cfg *config.Config, projectDirectory string) ([]Resource, error) {
 	fullFilename := r.Filename
 	if !filepath.IsAbs(fullFilename) {
 		f, err := filepath.Abs(filepath.Join(projectDirectory, r.Filename))
@@ -56,7 +86,7 @@ func (r FileResourceRequest) Fetch(cfg *config.Config, projectDirectory string)
 		return []Resource{}, fmt.Errorf("failed to convert file path to relative: %w", err)
 	}
 
-	data, err := os.ReadFile(fullFilename)
+	data, err := readFile(fullFilename)
 	if err != nil {
 		return []Resource{}, fmt.Errorf("failed to read file: %w", err)
 	}
@@ -65,11 +95,32 @@ func (r FileResourceRequest) Fetch(cfg *config.Config, projectDirectory string)
 		Resource{
 			ResourceType: "file",
 			Name:         relativePath,
-			Content:      string(data),
+			Content:      data,
+			Source:       fullFilename,
 		},
 	}, nil
 }
 
+// readFile returns the content of filename, preferring an open editor
+// buffer, which may have unsaved changes, over the file on disk. When the
+// editors cannot be asked, such as Acme not running, the file is read from
+// disk.
+func readFile(filename string) (string, error) {
+	content, ok, err := buffer.Lookup(filename)
+	if err != nil {
+		log.Printf("reading %s from disk, could not look for an open buffer: %v", filename, err)
+	} else if ok {
+		return content, nil
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
 func (r FileGlobResourceRequest) Fetch(cfg *config.Config, projectDirectory string) ([]Resource, error) {
 	// Find all matching files on the file system and then create/execute many
 	// FileResourceRequest statements.
@@ -91,6 +142,11 @@ fileLoop:
 			}
 		}
 
+		// Directories and other special files matched are not material
+		if info, err := os.Stat(filename); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
 		fileRequest := FileResourceRequest{Filename: filename}
 		resource, err := fileRequest.Fetch(cfg, projectDirectory)
 		if err != nil {
@@ -104,61 +160,32 @@ fileLoop:
 }
 
 func (r URLResourceRequest) Fetch(cfg *config.Config, projectDirectory string) ([]Resource, error) {
-	content, err := fetchURLWithTimeout(r.URL)
+	content, err := newURLFetcher(cfg.Resources.URL).Fetch(r.URL)
 	if err != nil {
 		return []Resource{}, fmt.Errorf("could not fetch URL: %w", err)
 	}
 
-	content, err = htmlToText(content)
-	if err != nil {
-		return []Resource{}, fmt.Errorf("could not convert HTML to text: %w", err)
-	}
-
 	return []Resource{
-		Resource{ResourceType: "url", Name: r.URL, Content: content},
+		Resource{ResourceType: "url", Name: r.URL, Content: content, Source: r.URL},
 	}, nil
 }
 
-func fetchURLWithTimeout(url string) (string, error) {
-	// Create client with timeout
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-	}
-
-	// Create request
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
-	}
-
-	// Set some common headers
-	req.Header.Set("User-Agent", "Mozilla/5.0")
+func (r MCPResourceRequest) Fetch(cfg *config.Config, projectDirectory string) ([]Resource, error) {
+	ctx := context.Background()
 
-	// Make the request
-	resp, err := client.Do(req)
+	client, err := mcp.Shared(cfg.MCP.Servers).Client(ctx, r.Server)
 	if err != nil {
-		return "", fmt.Errorf("failed to fetch URL: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// Check status code
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("bad status: %s", resp.Status)
+		return []Resource{}, err
 	}
 
-	// Read body
-	body, err := io.ReadAll(resp.Body)
+	content, err := client.ReadResource(ctx, r.URI)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %w", err)
+		return []Resource{}, fmt.Errorf("could not read resource: %w", err)
 	}
 
-	return string(body), nil
-}
+	name := r.Server + ":" + r.URI
 
-func htmlToText(html string) (string, error) {
-	text, err := html2text.FromString(html)
-	if err != nil {
-		return "", fmt.Errorf("failed to convert HTML to text: %w", err)
-	}
-	return text, nil
+	return []Resource{
+		Resource{ResourceType: "mcp", Name: name, Content: content, Source: "mcp:" + name},
+	}, nil
 }
@@ -0,0 +1,169 @@
+package conversation
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jcowgar/acme-utils/internal/llm/types"
+)
+
+const (
+	toolCallHeading   = "#### Tool Call "
+	toolResultHeading = "### Tool Result "
+)
+
+// FormatToolCall renders a tool call as written within a response: a
+// heading naming the tool and the call, followed by the arguments.
+func FormatToolCall(call types.ToolCall) string {
+	arguments := call.Arguments
+	if strings.TrimSpace(arguments) == "" {
+		arguments = "{}"
+	}
+
+	return fmt.Sprintf("%s%s %s\n\n%s\n", toolCallHeading, call.Name, call.ID, Fence(arguments, "json"))
+}
+
+// FormatToolResult renders the result of a tool call as a section of its
+// own, following the response that made the call.
+func FormatToolResult(id string, content string) string {
+	return fmt.Sprintf("%s%s\n\n%s\n", toolResultHeading, id, Fence(content, ""))
+}
+
+// parseToolCallHeading returns the tool and call named by a tool call
+// heading.
+func parseToolCallHeading(line string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(line, toolCallHeading)
+	if !ok {
+		return "", "", false
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) != 2 {
+		return "", "", false
+	}
+
+	return fields[0], fields[1], true
+}
+
+// parseToolResultHeading returns the call named by a tool result heading.
+func parseToolResultHeading(line string) (string, bool) {
+	rest, ok := strings.CutPrefix(line, toolResultHeading)
+	if !ok || strings.TrimSpace(rest) == "" {
+		return "", false
+	}
+
+	return strings.TrimSpace(rest), true
+}
+
+// Fence wraps text in a code block whose fence is longer than any run of
+// backticks within the text, so the text cannot close it early.
+func Fence(text string, info string) string {
+	marker := "```"
+	for strings.Contains(text, marker) {
+		marker += "`"
+	}
+
+	return fmt.Sprintf("%s%s\n%s\n%s", marker, info, strings.TrimRight(text, "\n"), marker)
+}
+
+// fenceMarker returns the run of backticks opening a code block, or an
+// empty string when line does not open one.
+func fenceMarker(line string) string {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "```") {
+		return ""
+	}
+
+	return trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, "`"))]
+}
+
+// unfence returns the content of text when it is a single code block.
+func unfence(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	if len(lines) < 2 {
+		return text
+	}
+
+	marker := fenceMarker(lines[0])
+	if marker == "" || strings.TrimSpace(lines[len(lines)-1]) != marker {
+		return text
+	}
+
+	return strings.Join(lines[1:len(lines)-1], "\n")
+}
+
+// EscapeToolHeadings returns text written by the model with every line that
+// would read as the heading of a tool call or result escaped, so a response
+// cannot pass itself off as a call or its result.
+func EscapeToolHeadings(text string) string {
+	var sb strings.Builder
+
+	escaper := NewToolHeadingEscaper(&sb)
+	escaper.Write([]byte(text))
+	escaper.Flush()
+
+	return sb.String()
+}
+
+// ToolHeadingEscaper writes text streamed from the model, escaping the lines
+// EscapeToolHeadings does. The start of a line that may be a heading is held
+// until it is known, so Flush must be called once the text ends.
+type ToolHeadingEscaper struct {
+	w io.Writer
+
+	// line is the start of the current line while it is held, and known
+	// whether the current line has been decided and written
+	line  []byte
+	known bool
+}
+
+// NewToolHeadingEscaper returns an escaper writing to w.
+func NewToolHeadingEscaper(w io.Writer) *ToolHeadingEscaper {
+	return &ToolHeadingEscaper{w: w}
+}
+
+func (e *ToolHeadingEscaper) Write(p []byte) (int, error) {
+	var out []byte
+
+	for _, c := range p {
+		if e.known {
+			out = append(out, c)
+			e.known = c != '\n'
+			continue
+		}
+
+		e.line = append(e.line, c)
+		line := string(e.line)
+
+		switch {
+		case strings.HasPrefix(line, toolCallHeading) || strings.HasPrefix(line, toolResultHeading):
+			out = append(out, '\\')
+		case c != '\n' && (strings.HasPrefix(toolCallHeading, line) || strings.HasPrefix(toolResultHeading, line)):
+			continue
+		}
+
+		out = append(out, e.line...)
+		e.line = e.line[:0]
+		e.known = c != '\n'
+	}
+
+	if _, err := e.w.Write(out); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Flush writes the line being held, if any.
+func (e *ToolHeadingEscaper) Flush() error {
+	if len(e.line) == 0 {
+		return nil
+	}
+
+	_, err := e.w.Write(e.line)
+	e.line = e.line[:0]
+	e.known = true
+
+	return err
+}
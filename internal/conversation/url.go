@@ -0,0 +1,421 @@
+package conversation
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jaytaylor/html2text"
+	"github.com/jcowgar/acme-utils/internal/config"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	defaultURLCacheTTL     = time.Hour
+	defaultURLTimeout      = 30 * time.Second
+	defaultURLMaxBytes     = 2 * 1024 * 1024
+	defaultURLMaxRedirects = 5
+)
+
+// urlEntry is a fetched URL as stored in the cache.
+type urlEntry struct {
+	URL          string    `json:"url"`
+	FinalURL     string    `json:"final_url"`
+	ContentType  string    `json:"content_type"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Truncated    bool      `json:"truncated,omitempty"`
+	FetchedAt    time.Time `json:"fetched_at"`
+	Body         []byte    `json:"body"`
+}
+
+// urlFetcher retrieves URLs, caching their bodies on disk and converting
+// them to text suitable for reference material.
+type urlFetcher struct {
+	client   *http.Client
+	cacheDir string
+	cacheTTL time.Duration
+	maxBytes int64
+	now      func() time.Time
+}
+
+func newURLFetcher(cfg config.URLConfig) *urlFetcher {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultURLTimeout
+	}
+
+	maxRedirects := cfg.MaxRedirects
+	if maxRedirects <= 0 {
+		maxRedirects = defaultURLMaxRedirects
+	}
+
+	f := &urlFetcher{
+		client: &http.Client{
+			Timeout: timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) > maxRedirects {
+					return fmt.Errorf("stopped after %d redirects", maxRedirects)
+				}
+				return nil
+			},
+		},
+		cacheTTL: cfg.CacheTTL,
+		maxBytes: cfg.MaxBytes,
+		now:      time.Now,
+	}
+
+	if f.cacheTTL <= 0 {
+		f.cacheTTL = defaultURLCacheTTL
+	}
+	if f.maxBytes <= 0 {
+		f.maxBytes = defaultURLMaxBytes
+	}
+
+	if !cfg.NoCache {
+		f.cacheDir = cfg.CacheDir
+		if f.cacheDir == "" {
+			if userCacheDir, err := os.UserCacheDir(); err == nil {
+				f.cacheDir = filepath.Join(userCacheDir, "ai-stdio", "urls")
+			}
+		}
+	}
+
+	return f
+}
+
+// Fetch returns the text content of url, using the cache when it is fresh
+// and revalidating it with the server when it is stale.
+func (f *urlFetcher) Fetch(url string) (string, error) {
+	cached := f.loadCached(url)
+	if cached != nil && f.now().Sub(cached.FetchedAt) < f.cacheTTL {
+		return renderURLEntry(cached)
+	}
+
+	entry, err := f.get(url, cached)
+	if err != nil {
+		return "", err
+	}
+
+	f.storeCached(entry)
+
+	return renderURLEntry(entry)
+}
+
+// get requests url from the server. When a previous entry is given the
+// request is made conditional and the previous entry is returned, refreshed,
+// if the server reports it has not been modified.
+func (f *urlFetcher) get(url string, previous *urlEntry) (*urlEntry, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Set some common headers
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("Accept", "text/html, text/markdown, text/plain, application/json;q=0.9, */*;q=0.5")
+	if previous != nil {
+		if previous.ETag != "" {
+			req.Header.Set("If-None-Match", previous.ETag)
+		}
+		if previous.LastModified != "" {
+			req.Header.Set("If-Modified-Since", previous.LastModified)
+		}
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch URL: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && previous != nil {
+		refreshed := *previous
+		refreshed.FetchedAt = f.now()
+		return &refreshed, nil
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("bad status: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	truncated := int64(len(body)) > f.maxBytes
+	if truncated {
+		body = body[:f.maxBytes]
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+
+	return &urlEntry{
+		URL:          url,
+		FinalURL:     resp.Request.URL.String(),
+		ContentType:  contentType,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Truncated:    truncated,
+		FetchedAt:    f.now(),
+		Body:         body,
+	}, nil
+}
+
+func (f *urlFetcher) cacheFilename(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(f.cacheDir, hex.EncodeToString(sum[:])+".json")
+}
+
+// loadCached returns the cached entry for url, or nil if there is none.
+// A corrupt cache entry is treated as missing.
+func (f *urlFetcher) loadCached(url string) *urlEntry {
+	if f.cacheDir == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(f.cacheFilename(url))
+	if err != nil {
+		return nil
+	}
+
+	var entry urlEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.URL != url {
+		return nil
+	}
+
+	return &entry
+}
+
+// storeCached writes entry to the cache, readable by the user only as pages
+// may be private. Failing to cache is not fatal to fetching, so errors are
+// ignored.
+func (f *urlFetcher) storeCached(entry *urlEntry) {
+	if f.cacheDir == "" {
+		return
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	if err := os.MkdirAll(f.cacheDir, 0700); err != nil {
+		return
+	}
+
+	_ = os.WriteFile(f.cacheFilename(entry.URL), data, 0600)
+}
+
+// renderURLEntry converts a fetched body to text according to its content
+// type.
+func renderURLEntry(entry *urlEntry) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(entry.ContentType)
+	if err != nil {
+		mediaType = strings.TrimSpace(strings.SplitN(entry.ContentType, ";", 2)[0])
+	}
+
+	var content string
+
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		content, err = htmlToText(entry.Body)
+		if err != nil {
+			return "", err
+		}
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		content = prettyJSON(entry.Body)
+	case strings.HasPrefix(mediaType, "text/"),
+		mediaType == "application/xml",
+		mediaType == "application/yaml",
+		mediaType == "application/x-yaml",
+		mediaType == "application/javascript":
+		content = string(entry.Body)
+	default:
+		return "", fmt.Errorf("unsupported content type: %s", entry.ContentType)
+	}
+
+	if entry.FinalURL != "" && entry.FinalURL != entry.URL {
+		content = fmt.Sprintf("(redirected to %s)\n\n%s", entry.FinalURL, content)
+	}
+
+	if entry.Truncated {
+		content += fmt.Sprintf("\n\n[content truncated after %d bytes]", len(entry.Body))
+	}
+
+	return content, nil
+}
+
+// prettyJSON indents a JSON document, returning it unchanged if it does not
+// parse.
+func prettyJSON(body []byte) string {
+	var out bytes.Buffer
+	if err := json.Indent(&out, body, "", "  "); err != nil {
+		return string(body)
+	}
+
+	return out.String()
+}
+
+func htmlToText(body []byte) (string, error) {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse HTML: %w", err)
+	}
+
+	title := findHTMLTitle(doc)
+	content := extractMainContent(doc)
+
+	text, err := html2text.FromHTMLNode(content)
+	if err != nil {
+		return "", fmt.Errorf("failed to convert HTML to text: %w", err)
+	}
+
+	if title != "" {
+		text = "# " + title + "\n\n" + text
+	}
+
+	return text, nil
+}
+
+// boilerplateElements are removed before extracting the main content.
+var boilerplateElements = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Nav:      true,
+	atom.Header:   true,
+	atom.Footer:   true,
+	atom.Aside:    true,
+	atom.Form:     true,
+	atom.Iframe:   true,
+	atom.Svg:      true,
+	atom.Button:   true,
+}
+
+// boilerplateRoles are ARIA landmark roles that never hold the main content.
+var boilerplateRoles = map[string]bool{
+	"navigation":    true,
+	"banner":        true,
+	"contentinfo":   true,
+	"complementary": true,
+	"search":        true,
+}
+
+// extractMainContent strips navigation and other page furniture from doc
+// and returns the node most likely to hold the main content: a <main>
+// element, the largest <article>, or failing those the <body>.
+func extractMainContent(doc *html.Node) *html.Node {
+	removeBoilerplate(doc)
+
+	if main := findFirstElement(doc, func(n *html.Node) bool {
+		return n.DataAtom == atom.Main || htmlAttribute(n, "role") == "main"
+	}); main != nil {
+		return main
+	}
+
+	var best *html.Node
+	bestLength := 0
+	walkElements(doc, func(n *html.Node) {
+		if n.DataAtom != atom.Article {
+			return
+		}
+		if length := textLength(n); length > bestLength {
+			best, bestLength = n, length
+		}
+	})
+	if best != nil {
+		return best
+	}
+
+	if body := findFirstElement(doc, func(n *html.Node) bool { return n.DataAtom == atom.Body }); body != nil {
+		return body
+	}
+
+	return doc
+}
+
+func removeBoilerplate(n *html.Node) {
+	for child := n.FirstChild; child != nil; {
+		next := child.NextSibling
+
+		if child.Type == html.ElementNode &&
+			(boilerplateElements[child.DataAtom] || boilerplateRoles[htmlAttribute(child, "role")]) {
+			n.RemoveChild(child)
+		} else {
+			removeBoilerplate(child)
+		}
+
+		child = next
+	}
+}
+
+func findHTMLTitle(doc *html.Node) string {
+	title := findFirstElement(doc, func(n *html.Node) bool { return n.DataAtom == atom.Title })
+	if title == nil || title.FirstChild == nil {
+		return ""
+	}
+
+	return strings.TrimSpace(title.FirstChild.Data)
+}
+
+func findFirstElement(n *html.Node, match func(*html.Node) bool) *html.Node {
+	if n.Type == html.ElementNode && match(n) {
+		return n
+	}
+
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if found := findFirstElement(child, match); found != nil {
+			return found
+		}
+	}
+
+	return nil
+}
+
+func walkElements(n *html.Node, visit func(*html.Node)) {
+	if n.Type == html.ElementNode {
+		visit(n)
+	}
+
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		walkElements(child, visit)
+	}
+}
+
+func textLength(n *html.Node) int {
+	if n.Type == html.TextNode {
+		return len(strings.TrimSpace(n.Data))
+	}
+
+	length := 0
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		length += textLength(child)
+	}
+
+	return length
+}
+
+func htmlAttribute(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,184 @@
+package conversation
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jcowgar/acme-utils/internal/config"
+)
+
+func TestURLFetcherContentTypes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(`<html><head><title>Docs</title><script>var x = 1;</script></head><body>
+<nav><a href="/">Home</a> | <a href="/about">About us</a></nav>
+<main><h1>Installing</h1><p>Run the installer.</p></main>
+<footer>Copyright Example Corp</footer>
+</body></html>`))
+		case "/data":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"name":"ai-stdio","tags":["go"]}`))
+		case "/readme":
+			w.Header().Set("Content-Type", "text/markdown")
+			w.Write([]byte("# Readme\n\n* item\n"))
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte{0x89, 'P', 'N', 'G'})
+		case "/missing":
+			http.NotFound(w, r)
+		case "/moved":
+			http.Redirect(w, r, "/readme", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name         string
+		path         string
+		wantContains []string
+		wantMissing  []string
+		wantErr      bool
+	}{
+		{
+			name:         "html extracts main content",
+			path:         "/page",
+			wantContains: []string{"# Docs", "Installing", "Run the installer."},
+			wantMissing:  []string{"About us", "Copyright", "var x"},
+		},
+		{
+			name:         "json is pretty printed",
+			path:         "/data",
+			wantContains: []string{"{\n  \"name\": \"ai-stdio\",\n  \"tags\": [\n    \"go\"\n  ]\n}"},
+		},
+		{
+			name:         "markdown passes through",
+			path:         "/readme",
+			wantContains: []string{"# Readme\n\n* item\n"},
+		},
+		{
+			name:         "redirects are followed",
+			path:         "/moved",
+			wantContains: []string{"(redirected to " + server.URL + "/readme)", "# Readme"},
+		},
+		{
+			name:    "binary content is rejected",
+			path:    "/image",
+			wantErr: true,
+		},
+		{
+			name:    "not found is an error",
+			path:    "/missing",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetcher := newURLFetcher(config.URLConfig{NoCache: true})
+
+			got, err := fetcher.Fetch(server.URL + tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Fetch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			for _, want := range tt.wantContains {
+				if !strings.Contains(got, want) {
+					t.Errorf("Fetch() = %q, want it to contain %q", got, want)
+				}
+			}
+			for _, unwanted := range tt.wantMissing {
+				if strings.Contains(got, unwanted) {
+					t.Errorf("Fetch() = %q, want it not to contain %q", got, unwanted)
+				}
+			}
+		})
+	}
+}
+
+func TestURLFetcherCache(t *testing.T) {
+	requests := 0
+	revalidations := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			revalidations++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("cached body"))
+	}))
+	defer server.Close()
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	cacheDir := filepath.Join(t.TempDir(), "urls")
+	fetcher := newURLFetcher(config.URLConfig{CacheDir: cacheDir, CacheTTL: time.Minute})
+	fetcher.now = func() time.Time { return now }
+
+	fetch := func() {
+		t.Helper()
+		got, err := fetcher.Fetch(server.URL)
+		if err != nil {
+			t.Fatalf("Fetch() error = %v", err)
+		}
+		if got != "cached body" {
+			t.Fatalf("Fetch() = %q, want %q", got, "cached body")
+		}
+	}
+
+	fetch()
+	fetch()
+	if requests != 1 {
+		t.Errorf("requests within TTL = %d, want 1", requests)
+	}
+
+	for filename, want := range map[string]os.FileMode{cacheDir: 0700, fetcher.cacheFilename(server.URL): 0600} {
+		info, err := os.Stat(filename)
+		if err != nil {
+			t.Fatalf("Stat() error = %v", err)
+		}
+		if perm := info.Mode().Perm(); perm != want {
+			t.Errorf("%s permissions = %v, want %v", filepath.Base(filename), perm, want)
+		}
+	}
+
+	now = now.Add(2 * time.Minute)
+	fetch()
+	if requests != 2 || revalidations != 1 {
+		t.Errorf("requests after TTL = %d (%d revalidated), want 2 (1 revalidated)", requests, revalidations)
+	}
+
+	fetch()
+	if requests != 2 {
+		t.Errorf("requests after revalidation = %d, want 2", requests)
+	}
+}
+
+func TestURLFetcherSizeCap(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strings.Repeat("a", 100)))
+	}))
+	defer server.Close()
+
+	fetcher := newURLFetcher(config.URLConfig{NoCache: true, MaxBytes: 10})
+
+	got, err := fetcher.Fetch(server.URL)
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+
+	want := strings.Repeat("a", 10) + "\n\n[content truncated after 10 bytes]"
+	if got != want {
+		t.Errorf("Fetch() = %q, want %q", got, want)
+	}
+}
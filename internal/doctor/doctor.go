@@ -0,0 +1,152 @@
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jcowgar/acme-utils/internal/config"
+	"github.com/jcowgar/acme-utils/internal/llm"
+	"github.com/jcowgar/acme-utils/internal/llm/types"
+)
+
+// Status is the outcome of a check.
+type Status string
+
+const (
+	OK   Status = "ok"
+	Warn Status = "warn"
+	Fail Status = "fail"
+)
+
+// Check is the result of checking one part of the setup, with how to fix it
+// when it is not OK.
+type Check struct {
+	Name    string
+	Status  Status
+	Message string
+	Fix     string
+}
+
+// ProviderTimeout bounds the time spent contacting each provider.
+var ProviderTimeout = 10 * time.Second
+
+// Run checks the configuration layers, validates the resolved configuration
+// and contacts every configured provider.
+func Run(ctx context.Context, resolved *config.Resolved) []Check {
+	checks := make([]Check, 0)
+
+	for _, layer := range resolved.Layers {
+		switch {
+		case layer.Found && layer.Path != "":
+			checks = append(checks, Check{Name: "config", Status: OK, Message: fmt.Sprintf("%s configuration %s", layer.Name, layer.Path)})
+		case layer.Name == config.LayerGlobal:
+			checks = append(checks, Check{
+				Name:    "config",
+				Status:  Warn,
+				Message: fmt.Sprintf("no global configuration at %s", layer.Path),
+				Fix:     "run: ai-stdio config init",
+			})
+		}
+	}
+
+	problems := resolved.Validate()
+	for _, problem := range problems {
+		checks = append(checks, Check{Name: "config", Status: Fail, Message: problem.String(), Fix: problem.Fix})
+	}
+	if len(problems) == 0 {
+		checks = append(checks, Check{Name: "config", Status: OK, Message: "configuration is valid"})
+	}
+
+	names := make([]string, 0, len(resolved.Config.LLM.Providers))
+	for name := range resolved.Config.LLM.Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		checks = append(checks, checkProvider(ctx, name, resolved.Config.LLM.Providers[name]))
+	}
+
+	return checks
+}
+
+// Failed reports whether any check failed.
+func Failed(checks []Check) bool {
+	for _, check := range checks {
+		if check.Status == Fail {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkProvider creates the provider and, when it can list its models,
+// confirms the service answers and offers the configured model.
+func checkProvider(ctx context.Context, name string, providerConfig config.ProviderConfig) Check {
+	check := Check{Name: "provider " + name}
+
+	if providerConfig.Type == "" || providerConfig.Model == "" {
+		check.Status = Fail
+		check.Message = "not checked, the provider is incomplete"
+		check.Fix = "set its type and model"
+		return check
+	}
+
+	provider, err := llm.NewProvider(providerConfig.Type, providerConfig)
+	if err != nil {
+		check.Status = Fail
+		check.Message = err.Error()
+		check.Fix = fmt.Sprintf("check the type and params of llm.providers.%s", name)
+		return check
+	}
+
+	lister, ok := provider.(types.ModelLister)
+	if !ok {
+		check.Status = OK
+		check.Message = fmt.Sprintf("%s %s configured, reachability not checked", providerConfig.Type, providerConfig.Model)
+		return check
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ProviderTimeout)
+	defer cancel()
+
+	models, err := lister.ListModels(ctx)
+	if err != nil {
+		check.Status = Fail
+		check.Message = fmt.Sprintf("unreachable: %v", err)
+		check.Fix = fmt.Sprintf("start the %s service or correct llm.providers.%s.params", providerConfig.Type, name)
+		return check
+	}
+
+	if !hasModel(models, providerConfig.Model) {
+		check.Status = Warn
+		check.Message = fmt.Sprintf("reachable, but model %s is not available", providerConfig.Model)
+		if providerConfig.Type == "ollama" {
+			check.Fix = fmt.Sprintf("run: ollama pull %s", providerConfig.Model)
+		} else {
+			check.Fix = fmt.Sprintf("set llm.providers.%s.model to an available model", name)
+		}
+		return check
+	}
+
+	check.Status = OK
+	check.Message = fmt.Sprintf("reachable, model %s is available", providerConfig.Model)
+
+	return check
+}
+
+// hasModel reports whether model is among models, where an untagged Ollama
+// model name means its latest tag.
+func hasModel(models []string, model string) bool {
+	for _, m := range models {
+		if m == model || (!strings.Contains(model, ":") && m == model+":latest") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,73 @@
+package doctor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jcowgar/acme-utils/internal/config"
+)
+
+func TestRun(t *testing.T) {
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tags" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"models":[{"name":"llama3:latest"}]}`))
+	}))
+	defer ollama.Close()
+
+	openai := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"list","data":[{"id":"gpt-4o","object":"model"}]}`))
+	}))
+	defer openai.Close()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	resolved, err := config.Resolve(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	resolved.Config.LLM = config.LLMConfig{
+		DefaultProvider: "local",
+		Providers: map[string]config.ProviderConfig{
+			"local":   {Type: "ollama", Model: "llama3", Params: map[string]interface{}{"base_url": ollama.URL}},
+			"missing": {Type: "ollama", Model: "mistral", Params: map[string]interface{}{"base_url": ollama.URL}},
+			"remote":  {Type: "openai", Model: "gpt-4o", Params: map[string]interface{}{"api_key": "test", "base_url": openai.URL + "/v1"}},
+			"down":    {Type: "ollama", Model: "llama3", Params: map[string]interface{}{"base_url": down.URL}},
+		},
+	}
+
+	statuses := make(map[string]Status)
+	for _, check := range Run(context.Background(), resolved) {
+		if check.Name != "config" {
+			statuses[check.Name] = check.Status
+		}
+		if check.Status != OK && check.Fix == "" {
+			t.Errorf("check %s %q has no fix", check.Name, check.Message)
+		}
+	}
+
+	want := map[string]Status{
+		"provider local":   OK,
+		"provider missing": Warn,
+		"provider remote":  OK,
+		"provider down":    Fail,
+	}
+	for name, status := range want {
+		if statuses[name] != status {
+			t.Errorf("%s status = %q, want %q", name, statuses[name], status)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// Version is the JSON-RPC version every message carries.
+const Version = "2.0"
+
+// Error codes defined by JSON-RPC.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// ErrClosed is returned by calls made on, or waiting on, a closed connection.
+var ErrClosed = errors.New("connection closed")
+
+// Error is the error of a failed request, as sent in its response.
+type Error struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// MethodNotFound returns the error for a request of an unknown method.
+func MethodNotFound(method string) *Error {
+	return &Error{Code: CodeMethodNotFound, Message: "method not found: " + method}
+}
+
+// message is any request, notification or response.
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Request is a request or notification received from the other side.
+type Request struct {
+	// ID is nil for a notification, which has no response.
+	ID     json.RawMessage
+	Method string
+	Params json.RawMessage
+}
+
+// IsNotification reports whether the request expects no response.
+func (r *Request) IsNotification() bool {
+	return r.ID == nil
+}
+
+// DecodeParams decodes the parameters of the request into v, returning an
+// invalid params error when they do not fit.
+func (r *Request) DecodeParams(v interface{}) error {
+	if len(r.Params) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(r.Params, v); err != nil {
+		return &Error{Code: CodeInvalidParams, Message: fmt.Sprintf("invalid params: %v", err)}
+	}
+
+	return nil
+}
+
+// Handler answers a request from the other side. The result is sent as the
+// response, and an error as an error response, a *Error as it is and any
+// other as an internal error. Both are ignored for notifications.
+type Handler func(ctx context.Context, conn *Conn, req *Request) (interface{}, error)
+
+// Conn is a connection over which either side may make requests of the
+// other.
+//
+// Requests received are handled concurrently, each with a context cancelled
+// by Cancel or Close. Notifications are handled in the
+// order received, before the next message is read, so they should return
+// quickly.
+type Conn struct {
+	stream  Stream
+	handler Handler
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	writeMu sync.Mutex
+
+	mu       sync.Mutex
+	closing  bool
+	nextID   int64
+	pending  map[string]chan *message
+	handling map[string]context.CancelFunc
+
+	done chan struct{}
+	err  error
+}
+
+// NewConn starts reading requests and responses from stream, answering
+// requests with handler, which may be nil when the other side makes none.
+func NewConn(stream Stream, handler Handler) *Conn {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c := &Conn{
+		stream:   stream,
+		handler:  handler,
+		ctx:      ctx,
+		cancel:   cancel,
+		pending:  make(map[string]chan *message),
+		handling: make(map[string]context.CancelFunc),
+		done:     make(chan struct{}),
+	}
+
+	go c.read()
+
+	return c
+}
+
+// Call sends a request and waits for its response, decoding the result into
+// result unless it is nil. An error response is returned as a *Error.
+func (c *Conn) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	c.mu.Lock()
+	c.nextID++
+	id := json.RawMessage(strconv.FormatInt(c.nextID, 10))
+	response := make(chan *message, 1)
+	c.pending[string(id)] = response
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, string(id))
+		c.mu.Unlock()
+	}()
+
+	if err := c.send(&message{ID: id, Method: method}, params); err != nil {
+		return err
+	}
+
+	select {
+	case msg := <-response:
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if result == nil || len(msg.Result) == 0 {
+			return nil
+		}
+		if err := json.Unmarshal(msg.Result, result); err != nil {
+			return fmt.Errorf("could not decode %s result: %w", method, err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.done:
+		return ErrClosed
+	}
+}
+
+// Notify sends a notification, which has no response.
+func (c *Conn) Notify(method string, params interface{}) error {
+	return c.send(&message{Method: method}, params)
+}
+
+// Cancel cancels the context of the request being handled with the given
+// id, if it is still being handled.
+func (c *Conn) Cancel(id json.RawMessage) {
+	c.mu.Lock()
+	cancel, ok := c.handling[string(id)]
+	c.mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+}
+
+// Close closes the connection and its stream.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	c.closing = true
+	c.mu.Unlock()
+
+	c.cancel()
+	return c.stream.Close()
+}
+
+// Done is closed once the connection is closed.
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+// Wait waits for the connection to close, returning the error that closed
+// it, or nil when the other side simply hung up.
+func (c *Conn) Wait() error {
+	<-c.done
+
+	if errors.Is(c.err, io.EOF) || errors.Is(c.err, ErrClosed) {
+		return nil
+	}
+
+	return c.err
+}
+
+func (c *Conn) send(msg *message, params interface{}) error {
+	msg.JSONRPC = Version
+
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return fmt.Errorf("could not encode %s params: %w", msg.Method, err)
+		}
+		msg.Params = data
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("could not encode message: %w", err)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	select {
+	case <-c.done:
+		return ErrClosed
+	default:
+	}
+
+	return c.stream.WriteMessage(data)
+}
+
+func (c *Conn) respond(id json.RawMessage, result interface{}, err error) {
+	msg := &message{ID: id}
+
+	if err != nil {
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &Error{Code: CodeInternalError, Message: err.Error()}
+		}
+		msg.Error = rpcErr
+	} else {
+		data, encodeErr := json.Marshal(result)
+		if encodeErr != nil {
+			msg.Error = &Error{Code: CodeInternalError, Message: fmt.Sprintf("could not encode result: %v", encodeErr)}
+		} else {
+			msg.Result = data
+		}
+	}
+
+	// A failed write means the connection is closing, which read reports
+	_ = c.send(msg, nil)
+}
+
+// read reads messages until the stream ends, dispatching each.
+func (c *Conn) read() {
+	var wg sync.WaitGroup
+
+	for {
+		data, err := c.stream.ReadMessage()
+		if err != nil {
+			c.mu.Lock()
+			// Reading a stream closed by Close fails however the stream
+			// reports it
+			if c.closing {
+				err = ErrClosed
+			}
+			c.err = err
+			c.mu.Unlock()
+			break
+		}
+
+		var msg message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.respond(json.RawMessage("null"), nil, &Error{Code: CodeParseError, Message: fmt.Sprintf("parse error: %v", err)})
+			continue
+		}
+
+		if msg.Method == "" {
+			c.mu.Lock()
+			response, ok := c.pending[string(msg.ID)]
+			c.mu.Unlock()
+			if ok {
+				response <- &msg
+			}
+			continue
+		}
+
+		req := &Request{ID: msg.ID, Method: msg.Method, Params: msg.Params}
+		if req.IsNotification() {
+			if c.handler != nil {
+				c.handler(c.ctx, c, req)
+			}
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(c.ctx)
+		c.mu.Lock()
+		c.handling[string(req.ID)] = cancel
+		c.mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				c.mu.Lock()
+				delete(c.handling, string(req.ID))
+				c.mu.Unlock()
+				cancel()
+			}()
+
+			if c.handler == nil {
+				c.respond(req.ID, nil, MethodNotFound(req.Method))
+				return
+			}
+
+			result, err := c.handler(ctx, c, req)
+			c.respond(req.ID, result, err)
+		}()
+	}
+
+	// Requests already received are answered when the other side has only
+	// stopped sending, Close cancels them
+	wg.Wait()
+	c.cancel()
+	close(c.done)
+}
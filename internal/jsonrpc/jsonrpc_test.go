@@ -0,0 +1,187 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pipe returns the streams of the two ends of a connection.
+func pipe() (Stream, Stream) {
+	aReader, bWriter := io.Pipe()
+	bReader, aWriter := io.Pipe()
+
+	return NewLineStream(aReader, aWriter), NewLineStream(bReader, bWriter)
+}
+
+func TestConn(t *testing.T) {
+	clientStream, serverStream := pipe()
+
+	notified := make(chan string, 1)
+	server := NewConn(serverStream, func(ctx context.Context, conn *Conn, req *Request) (interface{}, error) {
+		switch req.Method {
+		case "add":
+			var params []int
+			if err := req.DecodeParams(&params); err != nil {
+				return nil, err
+			}
+			return params[0] + params[1], nil
+		case "fail":
+			return nil, errors.New("it failed")
+		case "wait":
+			<-ctx.Done()
+			return nil, ctx.Err()
+		case "note":
+			var params string
+			req.DecodeParams(&params)
+			notified <- params
+			return nil, nil
+		}
+		return nil, MethodNotFound(req.Method)
+	})
+	defer server.Close()
+
+	client := NewConn(clientStream, nil)
+	defer client.Close()
+
+	ctx := context.Background()
+
+	var sum int
+	if err := client.Call(ctx, "add", []int{2, 3}, &sum); err != nil || sum != 5 {
+		t.Errorf("Call(add) = %d, %v, want 5", sum, err)
+	}
+
+	tests := []struct {
+		method string
+		params interface{}
+		code   int
+	}{
+		{"fail", nil, CodeInternalError},
+		{"missing", nil, CodeMethodNotFound},
+		{"add", "not numbers", CodeInvalidParams},
+	}
+	for _, tt := range tests {
+		var rpcErr *Error
+		err := client.Call(ctx, tt.method, tt.params, nil)
+		if !errors.As(err, &rpcErr) || rpcErr.Code != tt.code {
+			t.Errorf("Call(%s) error = %v, want code %d", tt.method, err, tt.code)
+		}
+	}
+
+	if err := client.Notify("note", "hello"); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	select {
+	case got := <-notified:
+		if got != "hello" {
+			t.Errorf("notification = %q, want hello", got)
+		}
+	case <-time.After(time.Second):
+		t.Error("notification was not handled")
+	}
+
+	// A call given up on by the caller returns at once
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := client.Call(timeout, "wait", nil, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Call(wait) error = %v, want deadline exceeded", err)
+	}
+}
+
+func TestConnCancel(t *testing.T) {
+	clientStream, serverStream := pipe()
+
+	started := make(chan json.RawMessage, 1)
+	server := NewConn(serverStream, func(ctx context.Context, conn *Conn, req *Request) (interface{}, error) {
+		started <- req.ID
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	defer server.Close()
+
+	client := NewConn(clientStream, nil)
+	defer client.Close()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- client.Call(context.Background(), "wait", nil, nil)
+	}()
+
+	server.Cancel(<-started)
+
+	select {
+	case err := <-result:
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) {
+			t.Errorf("Call() error = %v, want an error response", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cancelled request did not finish")
+	}
+}
+
+func TestConnClosed(t *testing.T) {
+	clientStream, serverStream := pipe()
+
+	// The other side hangs up without answering
+	NewConn(serverStream, func(ctx context.Context, conn *Conn, req *Request) (interface{}, error) {
+		conn.Close()
+		return nil, nil
+	})
+
+	client := NewConn(clientStream, nil)
+
+	result := make(chan error, 1)
+	go func() {
+		result <- client.Call(context.Background(), "never", nil, nil)
+	}()
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("Call() error = %v, want ErrClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("call did not finish when the connection closed")
+	}
+
+	if err := client.Wait(); err != nil {
+		t.Errorf("Wait() = %v, want nil", err)
+	}
+}
+
+func TestHeaderStream(t *testing.T) {
+	input := "Content-Length: 7\r\n\r\n{\"a\":1}" +
+		"Content-Type: application/vscode-jsonrpc; charset=utf-8\r\ncontent-length: 2\r\n\r\n[]" +
+		"Content-Length: 4\r\n\r\nnull"
+
+	var output bytes.Buffer
+	stream := NewHeaderStream(strings.NewReader(input), &output)
+
+	for _, want := range []string{`{"a":1}`, `[]`, `null`} {
+		data, err := stream.ReadMessage()
+		if err != nil || string(data) != want {
+			t.Errorf("ReadMessage() = %q, %v, want %q", data, err, want)
+		}
+	}
+	if _, err := stream.ReadMessage(); err != io.EOF {
+		t.Errorf("ReadMessage() at the end error = %v, want io.EOF", err)
+	}
+
+	if err := stream.WriteMessage([]byte(`{"b":2}`)); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+	if got, want := output.String(), "Content-Length: 7\r\n\r\n{\"b\":2}"; got != want {
+		t.Errorf("WriteMessage() wrote %q, want %q", got, want)
+	}
+
+	bad := NewHeaderStream(strings.NewReader("Content-Length: lots\r\n\r\n{}"), io.Discard)
+	if _, err := bad.ReadMessage(); err == nil {
+		t.Errorf("ReadMessage() with an invalid length succeeded, want an error")
+	}
+}
@@ -0,0 +1,123 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"sync"
+)
+
+// Stream reads and writes whole messages over some framing.
+type Stream interface {
+	ReadMessage() ([]byte, error)
+	WriteMessage(data []byte) error
+	Close() error
+}
+
+// closers closes the reader and writer of a stream once, those that can be
+// closed.
+type closers struct {
+	list []io.Closer
+	once sync.Once
+}
+
+func newClosers(r io.Reader, w io.Writer) *closers {
+	c := &closers{}
+	for _, rw := range []interface{}{w, r} {
+		if closer, ok := rw.(io.Closer); ok {
+			c.list = append(c.list, closer)
+		}
+	}
+
+	return c
+}
+
+func (c *closers) Close() error {
+	var err error
+	c.once.Do(func() {
+		for _, closer := range c.list {
+			if closeErr := closer.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+	})
+
+	return err
+}
+
+// lineStream frames each message as a single line of JSON.
+type lineStream struct {
+	*closers
+	reader *bufio.Reader
+	writer io.Writer
+}
+
+// NewLineStream returns a stream of messages one per line, as used over
+// standard input and output. Closing the stream closes r and w if they can
+// be closed.
+func NewLineStream(r io.Reader, w io.Writer) Stream {
+	return &lineStream{closers: newClosers(r, w), reader: bufio.NewReader(r), writer: w}
+}
+
+func (s *lineStream) ReadMessage() ([]byte, error) {
+	for {
+		line, err := s.reader.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			return line, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (s *lineStream) WriteMessage(data []byte) error {
+	_, err := s.writer.Write(append(data, '\n'))
+	return err
+}
+
+// headerStream frames each message with a Content-Length header.
+type headerStream struct {
+	*closers
+	reader *textproto.Reader
+	writer io.Writer
+}
+
+// NewHeaderStream returns a stream of messages each preceded by headers
+// giving its Content-Length, as the Language Server Protocol frames them.
+// Closing the stream closes r and w if they can be closed.
+func NewHeaderStream(r io.Reader, w io.Writer) Stream {
+	return &headerStream{closers: newClosers(r, w), reader: textproto.NewReader(bufio.NewReader(r)), writer: w}
+}
+
+func (s *headerStream) ReadMessage() ([]byte, error) {
+	header, err := s.reader.ReadMIMEHeader()
+	if err != nil {
+		if len(header) == 0 && errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("invalid message header: %w", err)
+	}
+
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(s.reader.R, data); err != nil {
+		return nil, fmt.Errorf("could not read message: %w", err)
+	}
+
+	return data, nil
+}
+
+func (s *headerStream) WriteMessage(data []byte) error {
+	message := append([]byte(fmt.Sprintf("Content-Length: %d\r\n\r\n", len(data))), data...)
+	_, err := s.writer.Write(message)
+	return err
+}
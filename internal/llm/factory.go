@@ -9,7 +9,15 @@ import (
 )
 
 // NewProvider creates a new LLM provider based on the provider type
+//
+// Secret references in the params, such as $ENV:NAME, are expanded first.
 func NewProvider(providerType string, cfg config.ProviderConfig) (Provider, error) {
+	params, err := config.ExpandParams(cfg.Params)
+	if err != nil {
+		return nil, fmt.Errorf("could not expand provider configuration: %w", err)
+	}
+	cfg.Params = params
+
 	switch providerType {
 	case "ollama":
 		model := cfg.Model
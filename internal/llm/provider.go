@@ -16,3 +16,9 @@ type Message = types.Message
 
 // For convenience, expose the Provider interface from types package
 type Provider = types.Provider
+
+// For convenience, expose the tool calling types from types package
+type (
+	Tool     = types.Tool
+	ToolCall = types.ToolCall
+)
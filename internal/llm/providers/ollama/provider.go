@@ -2,9 +2,13 @@ package ollama
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/jcowgar/acme-utils/internal/llm/types"
 	ollamaapi "github.com/ollama/ollama/api"
@@ -39,6 +43,94 @@ func (p *Provider) Name() string {
 }
 
 func (p *Provider) Chat(ctx context.Context, messages []types.Message) (string, error) {
+	stream := false
+	req := p.chatRequest(messages, stream)
+
+	var response *ollamaapi.ChatResponse
+	responseHandler := func(r ollamaapi.ChatResponse) error {
+		response = &r
+		return nil
+	}
+
+	if err := p.client.Chat(ctx, req, responseHandler); err != nil {
+		return "", fmt.Errorf("ollama chat failed: %w", err)
+	}
+
+	return response.Message.Content, nil
+}
+
+func (p *Provider) ChatStream(ctx context.Context, messages []types.Message, onChunk func(chunk string) error) (string, error) {
+	stream := true
+	req := p.chatRequest(messages, stream)
+
+	var response strings.Builder
+	responseHandler := func(r ollamaapi.ChatResponse) error {
+		response.WriteString(r.Message.Content)
+		return onChunk(r.Message.Content)
+	}
+
+	if err := p.client.Chat(ctx, req, responseHandler); err != nil {
+		return "", fmt.Errorf("ollama chat failed: %w", err)
+	}
+
+	return response.String(), nil
+}
+
+func (p *Provider) ChatTools(ctx context.Context, messages []types.Message, tools []types.Tool) (types.Message, error) {
+	stream := false
+	req := p.chatRequest(messages, stream)
+
+	ollamaTools, err := convertTools(tools)
+	if err != nil {
+		return types.Message{}, err
+	}
+	req.Tools = ollamaTools
+
+	var response *ollamaapi.ChatResponse
+	responseHandler := func(r ollamaapi.ChatResponse) error {
+		response = &r
+		return nil
+	}
+
+	if err := p.client.Chat(ctx, req, responseHandler); err != nil {
+		return types.Message{}, fmt.Errorf("ollama chat failed: %w", err)
+	}
+
+	reply := types.Message{Role: "assistant", Content: response.Message.Content}
+	for _, call := range response.Message.ToolCalls {
+		arguments, err := json.Marshal(call.Function.Arguments)
+		if err != nil {
+			return types.Message{}, fmt.Errorf("could not encode tool call arguments: %w", err)
+		}
+
+		id, err := newToolCallID()
+		if err != nil {
+			return types.Message{}, err
+		}
+
+		reply.ToolCalls = append(reply.ToolCalls, types.ToolCall{
+			ID:        id,
+			Name:      call.Function.Name,
+			Arguments: string(arguments),
+		})
+	}
+
+	return reply, nil
+}
+
+// newToolCallID returns a random identifier for a tool call. Ollama does not
+// identify calls, and they must not repeat across the replies of a
+// conversation for each result to match its call.
+func newToolCallID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not identify tool call: %w", err)
+	}
+
+	return "call_" + hex.EncodeToString(b), nil
+}
+
+func (p *Provider) chatRequest(messages []types.Message, stream bool) *ollamaapi.ChatRequest {
 	// Convert messages to Ollama format
 	ollamaMessages := make([]ollamaapi.Message, len(messages))
 	for i, msg := range messages {
@@ -46,25 +138,63 @@ func (p *Provider) Chat(ctx context.Context, messages []types.Message) (string,
 			Role:    msg.Role,
 			Content: msg.Content,
 		}
+
+		for _, call := range msg.ToolCalls {
+			var arguments ollamaapi.ToolCallFunctionArguments
+			if err := json.Unmarshal([]byte(call.Arguments), &arguments); err != nil {
+				// Pass arguments the model got wrong back as they were
+				arguments = ollamaapi.ToolCallFunctionArguments{"arguments": call.Arguments}
+			}
+
+			ollamaMessages[i].ToolCalls = append(ollamaMessages[i].ToolCalls, ollamaapi.ToolCall{
+				Function: ollamaapi.ToolCallFunction{Name: call.Name, Arguments: arguments},
+			})
+		}
 	}
 
-	stream := false
-	req := &ollamaapi.ChatRequest{
+	return &ollamaapi.ChatRequest{
 		Model:    p.model,
 		Messages: ollamaMessages,
 		Stream:   &stream,
 		Options:  map[string]interface{}{"num_ctx": 8192},
 	}
+}
 
-	var response *ollamaapi.ChatResponse
-	responseHandler := func(r ollamaapi.ChatResponse) error {
-		response = &r
-		return nil
+// convertTools converts tool definitions to the Ollama tools API
+func convertTools(tools []types.Tool) (ollamaapi.Tools, error) {
+	ollamaTools := make(ollamaapi.Tools, len(tools))
+	for i, tool := range tools {
+		ollamaTools[i] = ollamaapi.Tool{
+			Type: "function",
+			Function: ollamaapi.ToolFunction{
+				Name:        tool.Name,
+				Description: tool.Description,
+			},
+		}
+
+		// The API takes a fixed shape of schema, so decode ours into it
+		schema, err := json.Marshal(tool.Parameters)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode parameters of tool %s: %w", tool.Name, err)
+		}
+		if err := json.Unmarshal(schema, &ollamaTools[i].Function.Parameters); err != nil {
+			return nil, fmt.Errorf("unsupported parameters of tool %s: %w", tool.Name, err)
+		}
 	}
 
-	if err := p.client.Chat(ctx, req, responseHandler); err != nil {
-		return "", fmt.Errorf("ollama chat failed: %w", err)
+	return ollamaTools, nil
+}
+
+func (p *Provider) ListModels(ctx context.Context) ([]string, error) {
+	response, err := p.client.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ollama list failed: %w", err)
 	}
 
-	return response.Message.Content, nil
+	models := make([]string, 0, len(response.Models))
+	for _, model := range response.Models {
+		models = append(models, model.Name)
+	}
+
+	return models, nil
 }
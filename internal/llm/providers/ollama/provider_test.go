@@ -0,0 +1,89 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jcowgar/acme-utils/internal/llm/types"
+)
+
+func TestChatTools(t *testing.T) {
+	var request map[string]interface{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("could not decode request: %v", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"model":"llama3.2","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"read_file","arguments":{"path":"main.go"}}}]},"done":true}` + "\n"))
+	}))
+	defer server.Close()
+
+	provider, err := New("llama3.2", map[string]interface{}{"base_url": server.URL})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tools := []types.Tool{{
+		Name:        "read_file",
+		Description: "Read a file",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"path": map[string]interface{}{"type": "string", "description": "File to read"},
+			},
+			"required": []string{"path"},
+		},
+	}}
+	messages := []types.Message{
+		{Role: "user", Content: "What is in go.mod?"},
+		{Role: "assistant", ToolCalls: []types.ToolCall{{ID: "call_1", Name: "read_file", Arguments: `{"path":"go.mod"}`}}},
+		{Role: "tool", ToolCallID: "call_1", Content: "module example"},
+	}
+
+	reply, err := provider.ChatTools(context.Background(), messages, tools)
+	if err != nil {
+		t.Fatalf("ChatTools() error = %v", err)
+	}
+
+	if len(reply.ToolCalls) != 1 || !strings.HasPrefix(reply.ToolCalls[0].ID, "call_") {
+		t.Fatalf("ChatTools() = %+v, want an identified call", reply)
+	}
+	want := []types.ToolCall{{ID: reply.ToolCalls[0].ID, Name: "read_file", Arguments: `{"path":"main.go"}`}}
+	if !reflect.DeepEqual(reply.ToolCalls, want) {
+		t.Errorf("ChatTools() = %+v, want the call to read_file", reply)
+	}
+
+	// Calls of later replies are identified apart from earlier ones
+	again, err := provider.ChatTools(context.Background(), messages, tools)
+	if err != nil {
+		t.Fatalf("ChatTools() error = %v", err)
+	}
+	if len(again.ToolCalls) != 1 || again.ToolCalls[0].ID == reply.ToolCalls[0].ID {
+		t.Errorf("ChatTools() ids = %+v then %+v, want them to differ", reply.ToolCalls, again.ToolCalls)
+	}
+
+	if request["stream"] != false {
+		t.Errorf("request stream = %v, want false", request["stream"])
+	}
+
+	sentTools := request["tools"].([]interface{})
+	function := sentTools[0].(map[string]interface{})["function"].(map[string]interface{})
+	parameters := function["parameters"].(map[string]interface{})
+	if function["name"] != "read_file" || parameters["required"].([]interface{})[0] != "path" {
+		t.Errorf("request tools = %v", sentTools)
+	}
+
+	sentMessages := request["messages"].([]interface{})
+	call := sentMessages[1].(map[string]interface{})["tool_calls"].([]interface{})[0].(map[string]interface{})
+	arguments := call["function"].(map[string]interface{})["arguments"].(map[string]interface{})
+	if arguments["path"] != "go.mod" || sentMessages[2].(map[string]interface{})["role"] != "tool" {
+		t.Errorf("request messages = %v, want the earlier call and its result", sentMessages)
+	}
+}
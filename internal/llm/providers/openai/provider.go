@@ -2,9 +2,11 @@ package openai
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"strings"
 
-	"github.com/jcowgar/acme-utils/internal/config"
 	"github.com/jcowgar/acme-utils/internal/llm/types"
 	"github.com/sashabaranov/go-openai"
 )
@@ -19,7 +21,6 @@ func New(model string, params map[string]interface{}) (*Provider, error) {
 	if !ok {
 		return nil, fmt.Errorf("api_key not found in config params")
 	}
-	apiKey = config.ExpandString(apiKey)
 
 	openAiConfig := openai.DefaultConfig(apiKey)
 	baseURL, ok := params["base_url"].(string)
@@ -39,36 +40,143 @@ func (p *Provider) Name() string {
 }
 
 func (p *Provider) Chat(ctx context.Context, messages []types.Message) (string, error) {
-	// Convert messages to OpenAI format
-	openaiMessages := make([]openai.ChatCompletionMessage, len(messages))
-	for i, msg := range messages {
-		role := msg.Role
-		if role == "user" {
-			role = "user"
-		} else if role == "assistant" {
-			role = "assistant"
+	resp, err := p.client.CreateChatCompletion(
+		ctx,
+		openai.ChatCompletionRequest{
+			Model:    p.model,
+			Messages: convertMessages(messages),
+		},
+	)
+	if err != nil {
+		return "", fmt.Errorf("OpenAI API error: %w", err)
+	}
+
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("no response choices returned")
+	}
+
+	return resp.Choices[0].Message.Content, nil
+}
+
+func (p *Provider) ChatStream(ctx context.Context, messages []types.Message, onChunk func(chunk string) error) (string, error) {
+	stream, err := p.client.CreateChatCompletionStream(
+		ctx,
+		openai.ChatCompletionRequest{
+			Model:    p.model,
+			Messages: convertMessages(messages),
+			Stream:   true,
+		},
+	)
+	if err != nil {
+		return "", fmt.Errorf("OpenAI API error: %w", err)
+	}
+	defer stream.Close()
+
+	var response strings.Builder
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return "", fmt.Errorf("OpenAI stream error: %w", err)
 		}
 
-		openaiMessages[i] = openai.ChatCompletionMessage{
-			Role:    role,
-			Content: msg.Content,
+		if len(resp.Choices) == 0 {
+			continue
+		}
+
+		chunk := resp.Choices[0].Delta.Content
+		response.WriteString(chunk)
+		if err := onChunk(chunk); err != nil {
+			return "", err
 		}
 	}
 
+	return response.String(), nil
+}
+
+func (p *Provider) ChatTools(ctx context.Context, messages []types.Message, tools []types.Tool) (types.Message, error) {
 	resp, err := p.client.CreateChatCompletion(
 		ctx,
 		openai.ChatCompletionRequest{
 			Model:    p.model,
-			Messages: openaiMessages,
+			Messages: convertMessages(messages),
+			Tools:    convertTools(tools),
 		},
 	)
 	if err != nil {
-		return "", fmt.Errorf("OpenAI API error: %w", err)
+		return types.Message{}, fmt.Errorf("OpenAI API error: %w", err)
 	}
 
 	if len(resp.Choices) == 0 {
-		return "", fmt.Errorf("no response choices returned")
+		return types.Message{}, fmt.Errorf("no response choices returned")
 	}
 
-	return resp.Choices[0].Message.Content, nil
+	choice := resp.Choices[0].Message
+	reply := types.Message{Role: "assistant", Content: choice.Content}
+	for _, call := range choice.ToolCalls {
+		reply.ToolCalls = append(reply.ToolCalls, types.ToolCall{
+			ID:        call.ID,
+			Name:      call.Function.Name,
+			Arguments: call.Function.Arguments,
+		})
+	}
+
+	return reply, nil
+}
+
+// convertMessages converts messages to OpenAI format
+func convertMessages(messages []types.Message) []openai.ChatCompletionMessage {
+	openaiMessages := make([]openai.ChatCompletionMessage, len(messages))
+	for i, msg := range messages {
+		openaiMessages[i] = openai.ChatCompletionMessage{
+			Role:       msg.Role,
+			Content:    msg.Content,
+			ToolCallID: msg.ToolCallID,
+		}
+
+		for _, call := range msg.ToolCalls {
+			openaiMessages[i].ToolCalls = append(openaiMessages[i].ToolCalls, openai.ToolCall{
+				ID:   call.ID,
+				Type: openai.ToolTypeFunction,
+				Function: openai.FunctionCall{
+					Name:      call.Name,
+					Arguments: call.Arguments,
+				},
+			})
+		}
+	}
+
+	return openaiMessages
+}
+
+// convertTools converts tool definitions to OpenAI functions
+func convertTools(tools []types.Tool) []openai.Tool {
+	openaiTools := make([]openai.Tool, len(tools))
+	for i, tool := range tools {
+		openaiTools[i] = openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		}
+	}
+
+	return openaiTools
+}
+
+func (p *Provider) ListModels(ctx context.Context) ([]string, error) {
+	list, err := p.client.ListModels(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("OpenAI API error: %w", err)
+	}
+
+	models := make([]string, 0, len(list.Models))
+	for _, model := range list.Models {
+		models = append(models, model.ID)
+	}
+
+	return models, nil
 }
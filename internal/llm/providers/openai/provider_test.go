@@ -0,0 +1,71 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/jcowgar/acme-utils/internal/llm/types"
+)
+
+func TestChatTools(t *testing.T) {
+	var request map[string]interface{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("could not decode request: %v", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"","tool_calls":[
+			{"id":"call_abc","type":"function","function":{"name":"read_file","arguments":"{\"path\":\"main.go\"}"}}
+		]}}]}`))
+	}))
+	defer server.Close()
+
+	provider, err := New("gpt-4o", map[string]interface{}{"api_key": "test", "base_url": server.URL})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tools := []types.Tool{{
+		Name:        "read_file",
+		Description: "Read a file",
+		Parameters: map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"path": map[string]interface{}{"type": "string"}},
+			"required":   []string{"path"},
+		},
+	}}
+	messages := []types.Message{
+		{Role: "user", Content: "What is in go.mod?"},
+		{Role: "assistant", ToolCalls: []types.ToolCall{{ID: "call_1", Name: "read_file", Arguments: `{"path":"go.mod"}`}}},
+		{Role: "tool", ToolCallID: "call_1", Content: "module example"},
+	}
+
+	reply, err := provider.ChatTools(context.Background(), messages, tools)
+	if err != nil {
+		t.Fatalf("ChatTools() error = %v", err)
+	}
+
+	want := []types.ToolCall{{ID: "call_abc", Name: "read_file", Arguments: `{"path":"main.go"}`}}
+	if reply.Role != "assistant" || !reflect.DeepEqual(reply.ToolCalls, want) {
+		t.Errorf("ChatTools() = %+v, want the call to read_file", reply)
+	}
+
+	sentTools := request["tools"].([]interface{})
+	function := sentTools[0].(map[string]interface{})["function"].(map[string]interface{})
+	if function["name"] != "read_file" || function["parameters"] == nil {
+		t.Errorf("request tools = %v", sentTools)
+	}
+
+	sentMessages := request["messages"].([]interface{})
+	call := sentMessages[1].(map[string]interface{})["tool_calls"].([]interface{})[0].(map[string]interface{})
+	result := sentMessages[2].(map[string]interface{})
+	if call["id"] != "call_1" || result["role"] != "tool" || result["tool_call_id"] != "call_1" {
+		t.Errorf("request messages = %v, want the earlier call and its result", sentMessages)
+	}
+}
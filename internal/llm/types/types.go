@@ -4,15 +4,55 @@ import "context"
 
 // Message represents a chat message with standardized roles
 type Message struct {
-	Role    string // "user" or "assistant"
+	Role    string // "system", "user", "assistant" or "tool"
 	Content string
+
+	// ToolCalls are the tools an assistant message asks to be called
+	ToolCalls []ToolCall
+
+	// ToolCallID is the call a tool message carries the result of
+	ToolCallID string
+}
+
+// Tool describes a function the model may ask to call
+type Tool struct {
+	Name        string
+	Description string
+
+	// Parameters is the JSON schema of the function's arguments object
+	Parameters map[string]interface{}
+}
+
+// ToolCall is a model's request to call a tool
+type ToolCall struct {
+	ID   string
+	Name string
+
+	// Arguments is the JSON object of arguments
+	Arguments string
 }
 
 // Provider defines the interface that all LLM providers must implement
 type Provider interface {
 	// Chat sends a conversation to the LLM and returns the response
 	Chat(ctx context.Context, messages []Message) (string, error)
-	
+
+	// ChatStream sends a conversation to the LLM, calling onChunk with each
+	// piece of the response as it arrives, and returns the full response
+	ChatStream(ctx context.Context, messages []Message, onChunk func(chunk string) error) (string, error)
+
+	// ChatTools sends a conversation to the LLM, offering it tools, and
+	// returns its reply, whose ToolCalls are the tools it asks to call. The
+	// results are sent back as tool messages in a later call.
+	ChatTools(ctx context.Context, messages []Message, tools []Tool) (Message, error)
+
 	// Name returns the provider's name for identification
 	Name() string
 }
+
+// ModelLister is implemented by providers that can list the models their
+// service offers.
+type ModelLister interface {
+	// ListModels returns the names of the available models
+	ListModels(ctx context.Context) ([]string, error)
+}
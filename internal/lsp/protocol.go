@@ -0,0 +1,157 @@
+package lsp
+
+import (
+	"encoding/json"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// Position is a position in a document, its character counted in UTF-16
+// code units as LSP does by default.
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// Range is a span of a document, its end exclusive.
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// TextEdit replaces a range of a document with new text.
+type TextEdit struct {
+	Range   Range  `json:"range"`
+	NewText string `json:"newText"`
+}
+
+// WorkspaceEdit is a set of edits to documents, by URI.
+type WorkspaceEdit struct {
+	Changes map[string][]TextEdit `json:"changes"`
+}
+
+// Command is a command the client asks the server to execute.
+type Command struct {
+	Title     string        `json:"title"`
+	Command   string        `json:"command"`
+	Arguments []interface{} `json:"arguments,omitempty"`
+}
+
+// CodeAction is an action offered on a range of a document.
+type CodeAction struct {
+	Title   string   `json:"title"`
+	Kind    string   `json:"kind,omitempty"`
+	Command *Command `json:"command,omitempty"`
+}
+
+// Message types of window/showMessage.
+const (
+	MessageError   = 1
+	MessageWarning = 2
+	MessageInfo    = 3
+)
+
+type textDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type didOpenParams struct {
+	TextDocument struct {
+		URI        string `json:"uri"`
+		LanguageID string `json:"languageId"`
+		Version    int    `json:"version"`
+		Text       string `json:"text"`
+	} `json:"textDocument"`
+}
+
+type didChangeParams struct {
+	TextDocument struct {
+		URI     string `json:"uri"`
+		Version int    `json:"version"`
+	} `json:"textDocument"`
+	ContentChanges []struct {
+		Range *Range `json:"range"`
+		Text  string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type didCloseParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+}
+
+type codeActionParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Range        Range                  `json:"range"`
+}
+
+type executeCommandParams struct {
+	Command   string            `json:"command"`
+	Arguments []json.RawMessage `json:"arguments"`
+}
+
+type applyEditResult struct {
+	Applied       bool   `json:"applied"`
+	FailureReason string `json:"failureReason,omitempty"`
+}
+
+// offset returns the byte offset of a position in text, clamped to the end
+// of its line and of the text.
+func offset(text string, pos Position) int {
+	i := 0
+	for line := 0; line < pos.Line; line++ {
+		next := strings.IndexByte(text[i:], '\n')
+		if next < 0 {
+			return len(text)
+		}
+		i += next + 1
+	}
+
+	units := 0
+	for i < len(text) && units < pos.Character {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if r == '\n' {
+			break
+		}
+		units += utf16Len(r)
+		i += size
+	}
+
+	return i
+}
+
+// endPosition returns the position of the end of text.
+func endPosition(text string) Position {
+	line := strings.Count(text, "\n")
+	last := text[strings.LastIndexByte(text, '\n')+1:]
+
+	units := 0
+	for _, r := range last {
+		units += utf16Len(r)
+	}
+
+	return Position{Line: line, Character: units}
+}
+
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// uriPath returns the file a file:// URI names, or "" for any other URI.
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+
+	return filepath.Clean(filepath.FromSlash(u.Path))
+}
+
+// pathURI returns the file:// URI of an absolute filename.
+func pathURI(filename string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(filename)}).String()
+}
@@ -0,0 +1,462 @@
+// Package lsp is a Language Server Protocol front end, offering the model to
+// editors as code actions on a selection and as a command sending the chat.
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jcowgar/acme-utils/internal/jsonrpc"
+)
+
+// CommandPrefix starts the name of every command the server executes.
+const CommandPrefix = "ai-stdio."
+
+// SendChatCommand sends the chat file, adding the response to it.
+const SendChatCommand = CommandPrefix + "sendChat"
+
+// ErrNoShutdown is returned by Serve when the client exits without asking
+// the server to shut down first.
+var ErrNoShutdown = errors.New("exit without shutdown")
+
+// ResultMode is what becomes of the output of an action.
+type ResultMode int
+
+const (
+	// ShowResult shows the output as a message.
+	ShowResult ResultMode = iota
+
+	// ReplaceSelection replaces the selection with the output.
+	ReplaceSelection
+
+	// InsertAfterSelection inserts the output after the selection.
+	InsertAfterSelection
+)
+
+// Action is a code action offered on a selection, executed as the command
+// CommandPrefix followed by its name.
+type Action struct {
+	Name   string
+	Title  string
+	Kind   string
+	Result ResultMode
+}
+
+// ServerInfo is what the server calls itself.
+type ServerInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Server answers an editor over LSP. Actions run, and chats are sent, in the
+// background once their command is acknowledged, as a model takes longer to
+// answer than editors wait for a response. Their output is applied as an
+// edit, or shown, once it arrives.
+type Server struct {
+	Info ServerInfo
+
+	// Actions are offered on any selection.
+	Actions []Action
+
+	// Run runs the named action over a selection in a language, as the
+	// editor identifies it, returning the output.
+	Run func(ctx context.Context, action string, selection string, language string) (string, error)
+
+	// ChatFile is the chat sent by SendChatCommand, which is offered as an
+	// action within it. Sending is not offered when it is "".
+	ChatFile string
+
+	// SendChat sends the content of the chat, returning what to add to the
+	// end of it, or "" when there is nothing to send.
+	SendChat func(ctx context.Context, content string) (string, error)
+
+	mu        sync.Mutex
+	documents map[string]*document
+	shutdown  bool
+
+	// work is the actions and sends running in the background, cancelled
+	// by ctx once the connection closes
+	work   sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// document is a document open in the editor, as synchronised by it.
+type document struct {
+	text     string
+	language string
+	version  int
+}
+
+// NewServer returns a server calling itself info, offering nothing yet.
+func NewServer(info ServerInfo) *Server {
+	return &Server{Info: info, documents: make(map[string]*document)}
+}
+
+// Serve answers the editor over stream until it exits, returning
+// ErrNoShutdown when it does so without shutting the server down.
+func (s *Server) Serve(stream jsonrpc.Stream) error {
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	err := jsonrpc.NewConn(stream, s.handle).Wait()
+
+	s.cancel()
+	s.work.Wait()
+
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.shutdown {
+		return ErrNoShutdown
+	}
+
+	return nil
+}
+
+func (s *Server) handle(ctx context.Context, conn *jsonrpc.Conn, req *jsonrpc.Request) (interface{}, error) {
+	switch req.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       map[string]interface{}{"openClose": true, "change": 1},
+				"codeActionProvider":     true,
+				"executeCommandProvider": map[string]interface{}{"commands": s.commands()},
+			},
+			"serverInfo": s.Info,
+		}, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.mu.Lock()
+		s.shutdown = true
+		s.mu.Unlock()
+		return nil, nil
+	case "exit":
+		conn.Close()
+		return nil, nil
+	case "$/cancelRequest":
+		var params struct {
+			ID json.RawMessage `json:"id"`
+		}
+		if err := req.DecodeParams(&params); err == nil {
+			conn.Cancel(params.ID)
+		}
+		return nil, nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := req.DecodeParams(&params); err != nil {
+			return nil, err
+		}
+		doc := params.TextDocument
+		s.mu.Lock()
+		s.documents[doc.URI] = &document{text: doc.Text, language: doc.LanguageID, version: doc.Version}
+		s.mu.Unlock()
+		return nil, nil
+	case "textDocument/didChange":
+		return nil, s.didChange(req)
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := req.DecodeParams(&params); err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		delete(s.documents, params.TextDocument.URI)
+		s.mu.Unlock()
+		return nil, nil
+	case "textDocument/codeAction":
+		var params codeActionParams
+		if err := req.DecodeParams(&params); err != nil {
+			return nil, err
+		}
+		return s.codeActions(params), nil
+	case "workspace/executeCommand":
+		var params executeCommandParams
+		if err := req.DecodeParams(&params); err != nil {
+			return nil, err
+		}
+		return nil, s.execute(conn, params)
+	}
+
+	return nil, jsonrpc.MethodNotFound(req.Method)
+}
+
+// OpenFiles returns the files open in the editor, for +files.
+func (s *Server) OpenFiles() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files := make([]string, 0, len(s.documents))
+	for uri := range s.documents {
+		if filename := uriPath(uri); filename != "" {
+			files = append(files, filename)
+		}
+	}
+	sort.Strings(files)
+
+	return files
+}
+
+// commands lists the commands the server executes.
+func (s *Server) commands() []string {
+	commands := make([]string, 0, len(s.Actions)+1)
+	for _, action := range s.Actions {
+		commands = append(commands, CommandPrefix+action.Name)
+	}
+	if s.ChatFile != "" {
+		commands = append(commands, SendChatCommand)
+	}
+	sort.Strings(commands)
+
+	return commands
+}
+
+func (s *Server) didChange(req *jsonrpc.Request) error {
+	var params didChangeParams
+	if err := req.DecodeParams(&params); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+
+	for _, change := range params.ContentChanges {
+		if change.Range == nil {
+			doc.text = change.Text
+			continue
+		}
+
+		start, end := offset(doc.text, change.Range.Start), offset(doc.text, change.Range.End)
+		doc.text = doc.text[:start] + change.Text + doc.text[end:]
+	}
+	doc.version = params.TextDocument.Version
+
+	return nil
+}
+
+// codeActions offers the actions on a selection of an open document, and
+// sending the chat within the chat file.
+func (s *Server) codeActions(params codeActionParams) []CodeAction {
+	actions := make([]CodeAction, 0)
+
+	s.mu.Lock()
+	_, open := s.documents[params.TextDocument.URI]
+	s.mu.Unlock()
+	if !open {
+		return actions
+	}
+
+	if s.ChatFile != "" && uriPath(params.TextDocument.URI) == s.ChatFile {
+		actions = append(actions, CodeAction{
+			Title:   "Send chat",
+			Command: &Command{Title: "Send chat", Command: SendChatCommand},
+		})
+	}
+
+	if params.Range.Start == params.Range.End {
+		return actions
+	}
+
+	for _, action := range s.Actions {
+		actions = append(actions, CodeAction{
+			Title: action.Title,
+			Kind:  action.Kind,
+			Command: &Command{
+				Title:     action.Title,
+				Command:   CommandPrefix + action.Name,
+				Arguments: []interface{}{params.TextDocument.URI, params.Range},
+			},
+		})
+	}
+
+	return actions
+}
+
+// execute starts a command, which is acknowledged at once and reports its
+// failure as a message.
+func (s *Server) execute(conn *jsonrpc.Conn, params executeCommandParams) error {
+	if params.Command == SendChatCommand && s.ChatFile != "" {
+		s.background(conn, "Send chat", func(ctx context.Context) error {
+			return s.sendChat(ctx, conn)
+		})
+		return nil
+	}
+
+	for _, action := range s.Actions {
+		if params.Command != CommandPrefix+action.Name {
+			continue
+		}
+
+		var uri string
+		var selection Range
+		if len(params.Arguments) != 2 || json.Unmarshal(params.Arguments[0], &uri) != nil || json.Unmarshal(params.Arguments[1], &selection) != nil {
+			return &jsonrpc.Error{Code: jsonrpc.CodeInvalidParams, Message: params.Command + " takes a document URI and a range"}
+		}
+
+		s.mu.Lock()
+		doc, ok := s.documents[uri]
+		var text, language string
+		var version int
+		if ok {
+			text, language, version = doc.text, doc.language, doc.version
+		}
+		s.mu.Unlock()
+		if !ok {
+			return &jsonrpc.Error{Code: jsonrpc.CodeInvalidParams, Message: uri + " is not open"}
+		}
+
+		start, end := offset(text, selection.Start), offset(text, selection.End)
+		if start >= end {
+			return &jsonrpc.Error{Code: jsonrpc.CodeInvalidParams, Message: "nothing is selected"}
+		}
+
+		action := action
+		s.background(conn, action.Title, func(ctx context.Context) error {
+			return s.runAction(ctx, conn, action, uri, version, text[start:end], selection, language)
+		})
+		return nil
+	}
+
+	return &jsonrpc.Error{Code: jsonrpc.CodeInvalidParams, Message: "unknown command: " + params.Command}
+}
+
+// background runs work until the connection closes, showing its failure.
+func (s *Server) background(conn *jsonrpc.Conn, title string, work func(ctx context.Context) error) {
+	s.work.Add(1)
+	go func() {
+		defer s.work.Done()
+
+		if err := work(s.ctx); err != nil && s.ctx.Err() == nil {
+			showMessage(conn, MessageError, fmt.Sprintf("%s failed: %v", title, err))
+		}
+	}()
+}
+
+// runAction runs an action over a selection and applies its output, unless
+// the document changed meanwhile.
+func (s *Server) runAction(ctx context.Context, conn *jsonrpc.Conn, action Action, uri string, version int, selection string, selected Range, language string) error {
+	output, err := s.Run(ctx, action.Name, selection, language)
+	if err != nil {
+		return err
+	}
+
+	if action.Result == ShowResult {
+		return showMessage(conn, MessageInfo, output)
+	}
+
+	s.mu.Lock()
+	doc, ok := s.documents[uri]
+	changed := !ok || doc.version != version
+	s.mu.Unlock()
+	if changed {
+		return fmt.Errorf("the document changed while the model was answering")
+	}
+
+	edit := TextEdit{Range: selected, NewText: output}
+	if action.Result == InsertAfterSelection {
+		edit = TextEdit{Range: Range{Start: selected.End, End: selected.End}, NewText: "\n" + output}
+	}
+
+	return applyEdit(ctx, conn, action.Title, uri, edit)
+}
+
+// sendChat sends the chat, as the editor has it when it is open, and adds
+// the response to its end.
+func (s *Server) sendChat(ctx context.Context, conn *jsonrpc.Conn) error {
+	s.mu.Lock()
+	uri, open := s.chatURI()
+	var content string
+	if open {
+		content = s.documents[uri].text
+	}
+	s.mu.Unlock()
+
+	if !open {
+		data, err := os.ReadFile(s.ChatFile)
+		if err != nil {
+			return fmt.Errorf("could not read chat: %w", err)
+		}
+		content = string(data)
+	}
+
+	reply, err := s.SendChat(ctx, content)
+	if err != nil {
+		return err
+	}
+	if reply == "" {
+		return showMessage(conn, MessageInfo, "Nothing to send")
+	}
+
+	if !open {
+		f, err := os.OpenFile(s.ChatFile, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			return fmt.Errorf("could not open chat: %w", err)
+		}
+		defer f.Close()
+
+		if _, err := f.WriteString(reply); err != nil {
+			return fmt.Errorf("could not write chat: %w", err)
+		}
+		return nil
+	}
+
+	// The response goes at the end of the chat as it is now, whatever was
+	// written meanwhile
+	s.mu.Lock()
+	end := endPosition(content)
+	if doc, ok := s.documents[uri]; ok {
+		end = endPosition(doc.text)
+	}
+	s.mu.Unlock()
+
+	return applyEdit(ctx, conn, "Send chat", uri, TextEdit{Range: Range{Start: end, End: end}, NewText: reply})
+}
+
+// chatURI returns the URI the editor has the chat file open under, if it
+// has it open. The caller holds s.mu.
+func (s *Server) chatURI() (string, bool) {
+	for uri := range s.documents {
+		if uriPath(uri) == s.ChatFile {
+			return uri, true
+		}
+	}
+
+	return "", false
+}
+
+// applyEdit asks the editor to make an edit to a document.
+func applyEdit(ctx context.Context, conn *jsonrpc.Conn, label string, uri string, edit TextEdit) error {
+	params := map[string]interface{}{
+		"label": label,
+		"edit":  WorkspaceEdit{Changes: map[string][]TextEdit{uri: {edit}}},
+	}
+
+	var result applyEditResult
+	if err := conn.Call(ctx, "workspace/applyEdit", params, &result); err != nil {
+		return fmt.Errorf("could not edit %s: %w", uri, err)
+	}
+	if !result.Applied {
+		return fmt.Errorf("the editor did not apply the edit: %s", strings.TrimSpace(result.FailureReason))
+	}
+
+	return nil
+}
+
+// showMessage shows a message in the editor.
+func showMessage(conn *jsonrpc.Conn, typ int, message string) error {
+	return conn.Notify("window/showMessage", map[string]interface{}{"type": typ, "message": message})
+}
@@ -0,0 +1,331 @@
+package lsp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jcowgar/acme-utils/internal/jsonrpc"
+)
+
+func TestOffset(t *testing.T) {
+	text := "ab\nc€𝄞d\n\nend"
+
+	tests := []struct {
+		pos  Position
+		want int
+	}{
+		{Position{0, 0}, 0},
+		{Position{0, 2}, 2},
+		{Position{0, 9}, 2},
+		{Position{1, 1}, 4},
+		{Position{1, 2}, 7},
+		{Position{1, 4}, 11},
+		{Position{1, 5}, 12},
+		{Position{2, 0}, 13},
+		{Position{3, 3}, 17},
+		{Position{9, 0}, 17},
+	}
+
+	for _, tt := range tests {
+		if got := offset(text, tt.pos); got != tt.want {
+			t.Errorf("offset(%+v) = %d, want %d", tt.pos, got, tt.want)
+		}
+	}
+
+	if got, want := endPosition(text), (Position{3, 3}); got != want {
+		t.Errorf("endPosition() = %+v, want %+v", got, want)
+	}
+	if got, want := endPosition("x€𝄞"), (Position{0, 4}); got != want {
+		t.Errorf("endPosition() = %+v, want %+v", got, want)
+	}
+}
+
+// testClient is an editor connected to a server in process, recording the
+// edits and messages it is sent.
+type testClient struct {
+	*jsonrpc.Conn
+
+	edits    chan WorkspaceEdit
+	messages chan string
+}
+
+func newTestClient(t *testing.T, server *Server) (*testClient, <-chan error) {
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(jsonrpc.NewHeaderStream(serverReader, serverWriter))
+	}()
+
+	client := &testClient{edits: make(chan WorkspaceEdit, 10), messages: make(chan string, 10)}
+	client.Conn = jsonrpc.NewConn(jsonrpc.NewHeaderStream(clientReader, clientWriter), func(ctx context.Context, conn *jsonrpc.Conn, req *jsonrpc.Request) (interface{}, error) {
+		switch req.Method {
+		case "workspace/applyEdit":
+			var params struct {
+				Edit WorkspaceEdit `json:"edit"`
+			}
+			if err := req.DecodeParams(&params); err != nil {
+				return nil, err
+			}
+			client.edits <- params.Edit
+			return applyEditResult{Applied: true}, nil
+		case "window/showMessage":
+			var params struct {
+				Message string `json:"message"`
+			}
+			req.DecodeParams(&params)
+			client.messages <- params.Message
+			return nil, nil
+		}
+		return nil, jsonrpc.MethodNotFound(req.Method)
+	})
+	t.Cleanup(func() { client.Close() })
+
+	return client, served
+}
+
+func (c *testClient) edit(t *testing.T) WorkspaceEdit {
+	t.Helper()
+
+	select {
+	case edit := <-c.edits:
+		return edit
+	case message := <-c.messages:
+		t.Fatalf("got message %q, want an edit", message)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no edit was applied")
+	}
+	return WorkspaceEdit{}
+}
+
+func (c *testClient) message(t *testing.T) string {
+	t.Helper()
+
+	select {
+	case message := <-c.messages:
+		return message
+	case edit := <-c.edits:
+		t.Fatalf("got edit %+v, want a message", edit)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message was shown")
+	}
+	return ""
+}
+
+func newTestServer(chatFile string) *Server {
+	server := NewServer(ServerInfo{Name: "ai-stdio", Version: "test"})
+	server.Actions = []Action{
+		{Name: "explain", Title: "Explain selection", Result: ShowResult},
+		{Name: "refactor", Title: "Refactor with AI", Kind: "refactor.rewrite", Result: ReplaceSelection},
+		{Name: "test", Title: "Generate tests", Result: InsertAfterSelection},
+	}
+	server.Run = func(ctx context.Context, action string, selection string, language string) (string, error) {
+		if selection == "fail" {
+			return "", errors.New("model unavailable")
+		}
+		return action + "(" + language + "):" + strings.ToUpper(selection), nil
+	}
+	server.ChatFile = chatFile
+	server.SendChat = func(ctx context.Context, content string) (string, error) {
+		if strings.HasSuffix(strings.TrimSpace(content), "## You") {
+			return "", nil
+		}
+		return "\n### Response\n\nreplied to " + strings.Fields(content)[len(strings.Fields(content))-1] + "\n\n## You\n\n", nil
+	}
+
+	return server
+}
+
+func TestServerCodeActions(t *testing.T) {
+	client, served := newTestClient(t, newTestServer(""))
+	ctx := context.Background()
+
+	var initialized struct {
+		Capabilities struct {
+			ExecuteCommandProvider struct {
+				Commands []string `json:"commands"`
+			} `json:"executeCommandProvider"`
+		} `json:"capabilities"`
+		ServerInfo ServerInfo `json:"serverInfo"`
+	}
+	if err := client.Call(ctx, "initialize", map[string]interface{}{"capabilities": map[string]interface{}{}}, &initialized); err != nil {
+		t.Fatalf("initialize error = %v", err)
+	}
+	if got := initialized.Capabilities.ExecuteCommandProvider.Commands; len(got) != 3 || got[0] != "ai-stdio.explain" {
+		t.Errorf("initialize commands = %v, want the three actions", got)
+	}
+	if initialized.ServerInfo.Name != "ai-stdio" {
+		t.Errorf("initialize serverInfo = %+v, want ai-stdio", initialized.ServerInfo)
+	}
+	client.Notify("initialized", struct{}{})
+
+	uri := "file:///project/main.go"
+	client.Notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "go", "version": 1, "text": "package main\n\nfunc a() {}\n"},
+	})
+	client.Notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+		"contentChanges": []map[string]interface{}{{"text": "package main\n\nfunc b() {}\nfail\n"}},
+	})
+
+	selection := Range{Start: Position{2, 0}, End: Position{2, 11}}
+
+	var actions []CodeAction
+	if err := client.Call(ctx, "textDocument/codeAction", map[string]interface{}{"textDocument": map[string]string{"uri": uri}, "range": Range{}}, &actions); err != nil || len(actions) != 0 {
+		t.Errorf("codeAction without a selection = %+v, %v, want none", actions, err)
+	}
+	if err := client.Call(ctx, "textDocument/codeAction", map[string]interface{}{"textDocument": map[string]string{"uri": uri}, "range": selection}, &actions); err != nil || len(actions) != 3 {
+		t.Fatalf("codeAction = %+v, %v, want the three actions", actions, err)
+	}
+
+	commands := make(map[string]*Command)
+	for _, action := range actions {
+		commands[action.Title] = action.Command
+	}
+
+	execute := func(command *Command) error {
+		return client.Call(ctx, "workspace/executeCommand", map[string]interface{}{"command": command.Command, "arguments": command.Arguments}, nil)
+	}
+
+	if err := execute(commands["Explain selection"]); err != nil {
+		t.Fatalf("execute explain error = %v", err)
+	}
+	if got, want := client.message(t), "explain(go):FUNC B() {}"; got != want {
+		t.Errorf("explain showed %q, want %q", got, want)
+	}
+
+	if err := execute(commands["Refactor with AI"]); err != nil {
+		t.Fatalf("execute refactor error = %v", err)
+	}
+	edits := client.edit(t).Changes[uri]
+	if len(edits) != 1 || edits[0].Range != selection || edits[0].NewText != "refactor(go):FUNC B() {}" {
+		t.Errorf("refactor edits = %+v, want the selection replaced", edits)
+	}
+
+	if err := execute(commands["Generate tests"]); err != nil {
+		t.Fatalf("execute test error = %v", err)
+	}
+	edits = client.edit(t).Changes[uri]
+	if len(edits) != 1 || edits[0].Range.Start != selection.End || edits[0].Range.End != selection.End || edits[0].NewText != "\ntest(go):FUNC B() {}" {
+		t.Errorf("test edits = %+v, want the tests inserted after the selection", edits)
+	}
+
+	// A failing action is reported as a message
+	failing := &Command{Command: "ai-stdio.refactor", Arguments: []interface{}{uri, Range{Start: Position{3, 0}, End: Position{3, 4}}}}
+	if err := execute(failing); err != nil {
+		t.Fatalf("execute failing refactor error = %v", err)
+	}
+	if got := client.message(t); !strings.Contains(got, "model unavailable") {
+		t.Errorf("failing refactor showed %q, want the error", got)
+	}
+
+	var rpcErr *jsonrpc.Error
+	if err := execute(&Command{Command: "ai-stdio.refactor", Arguments: []interface{}{"file:///closed.go", selection}}); !errors.As(err, &rpcErr) {
+		t.Errorf("execute on a closed document error = %v, want an error response", err)
+	}
+	if err := execute(&Command{Command: "ai-stdio.unknown"}); !errors.As(err, &rpcErr) {
+		t.Errorf("execute unknown error = %v, want an error response", err)
+	}
+
+	if err := client.Call(ctx, "shutdown", nil, nil); err != nil {
+		t.Fatalf("shutdown error = %v", err)
+	}
+	client.Notify("exit", nil)
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve() = %v, want nil after shutdown and exit", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve() did not return on exit")
+	}
+}
+
+func TestServerSendChat(t *testing.T) {
+	dir := t.TempDir()
+	chatFile := filepath.Join(dir, ".ai-stdio.md")
+	content := "## You\n\nhello\n"
+	if err := os.WriteFile(chatFile, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := newTestServer(chatFile)
+	client, served := newTestClient(t, server)
+	ctx := context.Background()
+
+	if err := client.Call(ctx, "initialize", map[string]interface{}{"capabilities": map[string]interface{}{}}, nil); err != nil {
+		t.Fatalf("initialize error = %v", err)
+	}
+
+	sendChat := func() {
+		t.Helper()
+		if err := client.Call(ctx, "workspace/executeCommand", map[string]interface{}{"command": SendChatCommand}, nil); err != nil {
+			t.Fatalf("execute sendChat error = %v", err)
+		}
+	}
+
+	// A chat that is not open is sent from, and answered into, the file
+	sendChat()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := os.ReadFile(chatFile)
+		if strings.Contains(string(data), "replied to hello") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("chat = %q, want the response added", data)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// An open chat is sent as the editor has it, and answered by an edit
+	uri := pathURI(chatFile)
+	unsaved := "## You\n\nhello\n\n## You\n\nunsaved"
+	client.Notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "markdown", "version": 1, "text": unsaved},
+	})
+
+	var actions []CodeAction
+	if err := client.Call(ctx, "textDocument/codeAction", map[string]interface{}{"textDocument": map[string]string{"uri": uri}, "range": Range{}}, &actions); err != nil || len(actions) != 1 || actions[0].Command.Command != SendChatCommand {
+		t.Errorf("codeAction in the chat = %+v, %v, want Send chat", actions, err)
+	}
+
+	if got := server.OpenFiles(); len(got) != 1 || got[0] != chatFile {
+		t.Errorf("OpenFiles() = %v, want %s", got, chatFile)
+	}
+
+	sendChat()
+	edits := client.edit(t).Changes[uri]
+	end := Position{6, 7}
+	if len(edits) != 1 || edits[0].Range != (Range{Start: end, End: end}) || !strings.Contains(edits[0].NewText, "replied to unsaved") {
+		t.Errorf("sendChat edits = %+v, want the response added at the end", edits)
+	}
+
+	client.Notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+		"contentChanges": []map[string]interface{}{{"text": unsaved + edits[0].NewText}},
+	})
+	sendChat()
+	if got := client.message(t); got != "Nothing to send" {
+		t.Errorf("sendChat with nothing to send showed %q", got)
+	}
+
+	// Hanging up without shutting down is an error
+	client.Close()
+	select {
+	case err := <-served:
+		if !errors.Is(err, ErrNoShutdown) {
+			t.Errorf("Serve() = %v, want ErrNoShutdown", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve() did not return on hang up")
+	}
+}
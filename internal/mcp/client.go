@@ -0,0 +1,285 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jcowgar/acme-utils/internal/config"
+	"github.com/jcowgar/acme-utils/internal/jsonrpc"
+)
+
+const (
+	// DefaultTimeout bounds starting a server and each request made of it
+	// when the caller gives no deadline of its own.
+	DefaultTimeout = 30 * time.Second
+
+	// stopTimeout is how long a server has to exit once its input is
+	// closed before it is killed
+	stopTimeout = 2 * time.Second
+
+	// maxStderr is how much of the end of a server's error output is kept
+	// to explain its failure
+	maxStderr = 4096
+)
+
+// Client is a connection to a running MCP server.
+type Client struct {
+	// Name is the name the server is configured under.
+	Name string
+
+	// ServerInfo is what the server calls itself.
+	ServerInfo Implementation
+
+	conn   *jsonrpc.Conn
+	cmd    *exec.Cmd
+	stderr *tailBuffer
+}
+
+// Start launches a configured server and completes the MCP handshake with
+// it.
+func Start(ctx context.Context, name string, server config.MCPServerConfig) (*Client, error) {
+	if server.Command == "" {
+		return nil, fmt.Errorf("mcp server %s has no command", name)
+	}
+
+	env := os.Environ()
+	for key, value := range server.Env {
+		expanded, err := config.ExpandString(value)
+		if err != nil {
+			return nil, fmt.Errorf("mcp server %s: env.%s: %w", name, key, err)
+		}
+		env = append(env, key+"="+expanded)
+	}
+
+	cmd := exec.Command(server.Command, server.Args...)
+	cmd.Env = env
+
+	stderr := &tailBuffer{}
+	cmd.Stderr = stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("could not start mcp server %s: %w", name, err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("could not start mcp server %s: %w", name, err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not start mcp server %s: %w", name, err)
+	}
+
+	client := &Client{Name: name, cmd: cmd, stderr: stderr}
+	client.conn = jsonrpc.NewConn(jsonrpc.NewLineStream(stdout, stdin), handleServerRequest)
+
+	if err := client.initialize(ctx); err != nil {
+		client.Close()
+		if output := strings.TrimSpace(stderr.String()); output != "" {
+			err = fmt.Errorf("%w: %s", err, output)
+		}
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// Connect completes the MCP handshake with a server already connected
+// through stream.
+func Connect(ctx context.Context, name string, stream jsonrpc.Stream) (*Client, error) {
+	client := &Client{Name: name}
+	client.conn = jsonrpc.NewConn(stream, handleServerRequest)
+
+	if err := client.initialize(ctx); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// handleServerRequest answers the requests a server may make of its client,
+// of which only ping is supported.
+func handleServerRequest(ctx context.Context, conn *jsonrpc.Conn, req *jsonrpc.Request) (interface{}, error) {
+	if req.Method == "ping" {
+		return struct{}{}, nil
+	}
+
+	return nil, jsonrpc.MethodNotFound(req.Method)
+}
+
+// Self describes ai-stdio to the other side of a connection.
+func Self() Implementation {
+	version := "devel"
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		version = info.Main.Version
+	}
+
+	return Implementation{Name: "ai-stdio", Version: version}
+}
+
+func (c *Client) initialize(ctx context.Context) error {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	params := initializeParams{
+		ProtocolVersion: ProtocolVersion,
+		Capabilities:    map[string]interface{}{},
+		ClientInfo:      Self(),
+	}
+
+	var result initializeResult
+	if err := c.conn.Call(ctx, "initialize", params, &result); err != nil {
+		return fmt.Errorf("could not initialize mcp server %s: %w", c.Name, err)
+	}
+	c.ServerInfo = result.ServerInfo
+
+	if err := c.conn.Notify("notifications/initialized", nil); err != nil {
+		return fmt.Errorf("could not initialize mcp server %s: %w", c.Name, err)
+	}
+
+	return nil
+}
+
+// call makes a request of the server, naming the server in any error.
+func (c *Client) call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	if err := c.conn.Call(ctx, method, params, result); err != nil {
+		return fmt.Errorf("mcp server %s: %s: %w", c.Name, method, err)
+	}
+
+	return nil
+}
+
+// ListTools returns every tool the server offers.
+func (c *Client) ListTools(ctx context.Context) ([]Tool, error) {
+	tools := make([]Tool, 0)
+
+	cursor := ""
+	for {
+		var result listToolsResult
+		if err := c.call(ctx, "tools/list", listParams{Cursor: cursor}, &result); err != nil {
+			return nil, err
+		}
+		tools = append(tools, result.Tools...)
+
+		if result.NextCursor == "" {
+			return tools, nil
+		}
+		cursor = result.NextCursor
+	}
+}
+
+// ListResources returns every resource the server offers.
+func (c *Client) ListResources(ctx context.Context) ([]Resource, error) {
+	resources := make([]Resource, 0)
+
+	cursor := ""
+	for {
+		var result listResourcesResult
+		if err := c.call(ctx, "resources/list", listParams{Cursor: cursor}, &result); err != nil {
+			return nil, err
+		}
+		resources = append(resources, result.Resources...)
+
+		if result.NextCursor == "" {
+			return resources, nil
+		}
+		cursor = result.NextCursor
+	}
+}
+
+// CallTool calls a tool with a JSON object of arguments, returning the text
+// of its result. A result the server marks as an error is returned as one.
+func (c *Client) CallTool(ctx context.Context, name string, arguments json.RawMessage) (string, error) {
+	var result callToolResult
+	if err := c.call(ctx, "tools/call", callToolParams{Name: name, Arguments: arguments}, &result); err != nil {
+		return "", err
+	}
+
+	text := contentText(result.Content)
+	if result.IsError {
+		return "", errors.New(text)
+	}
+
+	return text, nil
+}
+
+// ReadResource returns the text content of a resource.
+func (c *Client) ReadResource(ctx context.Context, uri string) (string, error) {
+	var result readResourceResult
+	if err := c.call(ctx, "resources/read", readResourceParams{URI: uri}, &result); err != nil {
+		return "", err
+	}
+
+	return contentsText(result.Contents), nil
+}
+
+// Close closes the connection and, for a launched server, waits briefly for
+// it to exit before killing it.
+func (c *Client) Close() error {
+	err := c.conn.Close()
+
+	if c.cmd == nil {
+		return err
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		c.cmd.Wait()
+		close(exited)
+	}()
+
+	select {
+	case <-exited:
+	case <-time.After(stopTimeout):
+		c.cmd.Process.Kill()
+		<-exited
+	}
+
+	return err
+}
+
+// withDefaultTimeout applies DefaultTimeout to a context without a deadline.
+func withDefaultTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, DefaultTimeout)
+}
+
+// tailBuffer keeps the end of what is written to it.
+type tailBuffer struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.data = append(b.data, p...)
+	if len(b.data) > maxStderr {
+		b.data = b.data[len(b.data)-maxStderr:]
+	}
+
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return string(b.data)
+}
@@ -0,0 +1,186 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jcowgar/acme-utils/internal/config"
+	"github.com/jcowgar/acme-utils/internal/mcp/mcptest"
+)
+
+// TestMain runs the fake MCP server in place of the tests when the test
+// binary is launched as a server.
+func TestMain(m *testing.M) {
+	switch os.Getenv("AI_STDIO_FAKE_MCP") {
+	case "1":
+		if err := mcptest.Serve(os.Stdin, os.Stdout); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "env":
+		// Refuse to start without the expanded token
+		if token := os.Getenv("FAKE_MCP_TOKEN"); token != fakeToken {
+			fmt.Fprintf(os.Stderr, "bad token %q\n", token)
+			os.Exit(1)
+		}
+		mcptest.Serve(os.Stdin, os.Stdout)
+		os.Exit(0)
+	case "hang":
+		// Keep running once the client closes its side
+		mcptest.Serve(os.Stdin, os.Stdout)
+		time.Sleep(time.Hour)
+	}
+
+	os.Exit(m.Run())
+}
+
+// fakeToken is the secret the env server expects to be given.
+const fakeToken = "s3cret-token"
+
+// fakeServer is the configuration launching the fake server.
+func fakeServer() config.MCPServerConfig {
+	return config.MCPServerConfig{
+		Command: os.Args[0],
+		Env:     map[string]string{"AI_STDIO_FAKE_MCP": "1"},
+	}
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+
+	client, err := Start(ctx, "fake", fakeServer())
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer client.Close()
+
+	if client.ServerInfo.Name != "fake" {
+		t.Errorf("ServerInfo = %+v, want fake", client.ServerInfo)
+	}
+
+	tools, err := client.ListTools(ctx)
+	if err != nil {
+		t.Fatalf("ListTools() error = %v", err)
+	}
+	if len(tools) != 2 || tools[0].Name != "echo" || tools[0].InputSchema["type"] != "object" {
+		t.Errorf("ListTools() = %+v, want echo and fail", tools)
+	}
+
+	got, err := client.CallTool(ctx, "echo", []byte(`{"text":"hi there"}`))
+	if err != nil || got != "hi there" {
+		t.Errorf("CallTool(echo) = %q, %v, want %q", got, err, "hi there")
+	}
+
+	if _, err := client.CallTool(ctx, "fail", nil); err == nil || !strings.Contains(err.Error(), "the fail tool failed") {
+		t.Errorf("CallTool(fail) error = %v, want the tool's error", err)
+	}
+
+	resources, err := client.ListResources(ctx)
+	if err != nil || len(resources) != 1 || resources[0].URI != "test://greeting" {
+		t.Errorf("ListResources() = %+v, %v, want test://greeting", resources, err)
+	}
+
+	content, err := client.ReadResource(ctx, "test://greeting")
+	if err != nil || content != mcptest.Greeting {
+		t.Errorf("ReadResource() = %q, %v, want %q", content, err, mcptest.Greeting)
+	}
+
+	if _, err := client.ReadResource(ctx, "test://missing"); err == nil {
+		t.Error("ReadResource() of a missing resource succeeded, want an error")
+	}
+}
+
+func TestStartFailure(t *testing.T) {
+	_, err := Start(context.Background(), "broken", config.MCPServerConfig{Command: "sh", Args: []string{"-c", "echo no such database >&2; exit 1"}})
+	if err == nil || !strings.Contains(err.Error(), "no such database") {
+		t.Errorf("Start() error = %v, want the server's error output", err)
+	}
+}
+
+func TestStartEnv(t *testing.T) {
+	t.Setenv("FAKE_MCP_TOKEN_SOURCE", fakeToken)
+
+	server := config.MCPServerConfig{
+		Command: os.Args[0],
+		Env:     map[string]string{"AI_STDIO_FAKE_MCP": "env", "FAKE_MCP_TOKEN": "$ENV:FAKE_MCP_TOKEN_SOURCE"},
+	}
+
+	client, err := Start(context.Background(), "env", server)
+	if err != nil {
+		t.Fatalf("Start() with the token expanded error = %v", err)
+	}
+	client.Close()
+
+	server.Env["FAKE_MCP_TOKEN"] = "not-the-token"
+	if _, err := Start(context.Background(), "env", server); err == nil || !strings.Contains(err.Error(), "bad token") {
+		t.Errorf("Start() with the wrong token error = %v, want the server's complaint", err)
+	}
+
+	server.Env["FAKE_MCP_TOKEN"] = "$ENV:FAKE_MCP_MISSING"
+	if _, err := Start(context.Background(), "env", server); err == nil || !strings.Contains(err.Error(), "FAKE_MCP_MISSING") {
+		t.Errorf("Start() with an unset variable error = %v, want the variable named", err)
+	}
+
+	server.Env["FAKE_MCP_TOKEN"] = "$FILE:/nonexistent/token"
+	if _, err := Start(context.Background(), "env", server); err == nil || !strings.Contains(err.Error(), "env.FAKE_MCP_TOKEN") {
+		t.Errorf("Start() with an unreadable token error = %v, want the variable named", err)
+	}
+}
+
+func TestCloseKillsServer(t *testing.T) {
+	client, err := Start(context.Background(), "hang", config.MCPServerConfig{
+		Command: os.Args[0],
+		Env:     map[string]string{"AI_STDIO_FAKE_MCP": "hang"},
+	})
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	start := time.Now()
+	client.Close()
+
+	if elapsed := time.Since(start); elapsed > stopTimeout+5*time.Second {
+		t.Errorf("Close() took %s, want the server killed after %s", elapsed, stopTimeout)
+	}
+	if state := client.cmd.ProcessState; state == nil || state.Success() {
+		t.Errorf("server state after Close() = %v, want it killed", state)
+	}
+}
+
+func TestPool(t *testing.T) {
+	ctx := context.Background()
+
+	pool := NewPool(map[string]config.MCPServerConfig{"fake": fakeServer()})
+	defer pool.Close()
+
+	first, err := pool.Client(ctx, "fake")
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	again, err := pool.Client(ctx, "fake")
+	if err != nil || again != first {
+		t.Errorf("Client() again = %p, %v, want the running client %p", again, err, first)
+	}
+
+	if _, err := pool.Client(ctx, "missing"); err == nil {
+		t.Error("Client() of an unknown server succeeded, want an error")
+	}
+
+	// A changed configuration restarts the server
+	changed := fakeServer()
+	changed.Args = []string{"-test.run=^$"}
+	pool.Configure(map[string]config.MCPServerConfig{"fake": changed})
+
+	restarted, err := pool.Client(ctx, "fake")
+	if err != nil {
+		t.Fatalf("Client() after Configure() error = %v", err)
+	}
+	if restarted == first {
+		t.Error("Client() after Configure() returned the old client")
+	}
+}
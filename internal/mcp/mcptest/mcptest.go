@@ -0,0 +1,116 @@
+package mcptest
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/jcowgar/acme-utils/internal/jsonrpc"
+)
+
+// Greeting is the text of the fake server's test://greeting resource.
+const Greeting = "hello from the fake server"
+
+// Serve runs a fake MCP server over r and w until r ends. It offers an echo
+// tool returning its text argument, a fail tool whose result is an error,
+// and a single text resource, test://greeting.
+func Serve(r io.Reader, w io.Writer) error {
+	return jsonrpc.NewConn(jsonrpc.NewLineStream(r, w), handle).Wait()
+}
+
+// Pipe starts a fake server in the background, returning the stream a client
+// talks to it over. Closing the stream stops the server.
+func Pipe() jsonrpc.Stream {
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+
+	go func() {
+		Serve(serverReader, serverWriter)
+		serverWriter.Close()
+	}()
+
+	return jsonrpc.NewLineStream(clientReader, clientWriter)
+}
+
+func handle(ctx context.Context, conn *jsonrpc.Conn, req *jsonrpc.Request) (interface{}, error) {
+	switch req.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"protocolVersion": "2024-11-05",
+			"capabilities":    map[string]interface{}{"tools": map[string]interface{}{}, "resources": map[string]interface{}{}},
+			"serverInfo":      map[string]interface{}{"name": "fake", "version": "1.0.0"},
+		}, nil
+	case "notifications/initialized", "ping":
+		return struct{}{}, nil
+	case "tools/list":
+		return map[string]interface{}{
+			"tools": []interface{}{
+				map[string]interface{}{
+					"name":        "echo",
+					"description": "Return the text given",
+					"inputSchema": map[string]interface{}{
+						"type":       "object",
+						"properties": map[string]interface{}{"text": map[string]interface{}{"type": "string"}},
+						"required":   []string{"text"},
+					},
+				},
+				map[string]interface{}{
+					"name":        "fail",
+					"description": "Always fail",
+					"inputSchema": map[string]interface{}{"type": "object"},
+				},
+			},
+		}, nil
+	case "tools/call":
+		var params struct {
+			Name      string `json:"name"`
+			Arguments struct {
+				Text string `json:"text"`
+			} `json:"arguments"`
+		}
+		if err := req.DecodeParams(&params); err != nil {
+			return nil, err
+		}
+
+		switch params.Name {
+		case "echo":
+			return toolResult(params.Arguments.Text, false), nil
+		case "fail":
+			return toolResult("the fail tool failed", true), nil
+		}
+		return nil, &jsonrpc.Error{Code: jsonrpc.CodeInvalidParams, Message: "unknown tool: " + params.Name}
+	case "resources/list":
+		return map[string]interface{}{
+			"resources": []interface{}{
+				map[string]interface{}{"uri": "test://greeting", "name": "Greeting", "mimeType": "text/plain"},
+			},
+		}, nil
+	case "resources/read":
+		var params struct {
+			URI string `json:"uri"`
+		}
+		if err := req.DecodeParams(&params); err != nil {
+			return nil, err
+		}
+
+		if params.URI != "test://greeting" {
+			return nil, &jsonrpc.Error{Code: -32002, Message: "resource not found: " + params.URI}
+		}
+		return map[string]interface{}{
+			"contents": []interface{}{
+				map[string]interface{}{"uri": params.URI, "mimeType": "text/plain", "text": Greeting},
+			},
+		}, nil
+	}
+
+	return nil, jsonrpc.MethodNotFound(req.Method)
+}
+
+func toolResult(text string, isError bool) json.RawMessage {
+	data, _ := json.Marshal(map[string]interface{}{
+		"content": []interface{}{map[string]interface{}{"type": "text", "text": text}},
+		"isError": isError,
+	})
+
+	return data
+}
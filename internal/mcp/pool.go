@@ -0,0 +1,116 @@
+package mcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/jcowgar/acme-utils/internal/config"
+)
+
+// Pool starts configured servers when first used and keeps them running to
+// be used again.
+type Pool struct {
+	mu      sync.Mutex
+	servers map[string]config.MCPServerConfig
+	clients map[string]*Client
+}
+
+var shared = NewPool(nil)
+
+// NewPool returns a pool of the configured servers, none yet started.
+func NewPool(servers map[string]config.MCPServerConfig) *Pool {
+	return &Pool{servers: servers, clients: make(map[string]*Client)}
+}
+
+// Shared returns the pool shared by everything in the process, configured
+// with servers. Servers whose configuration changed since they were started
+// are stopped, to be started afresh when next used.
+func Shared(servers map[string]config.MCPServerConfig) *Pool {
+	shared.Configure(servers)
+	return shared
+}
+
+// CloseShared stops every server of the shared pool.
+func CloseShared() error {
+	return shared.Close()
+}
+
+// Configure replaces the configured servers, stopping those removed or
+// changed.
+func (p *Pool) Configure(servers map[string]config.MCPServerConfig) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for name, client := range p.clients {
+		if server, ok := servers[name]; !ok || !reflect.DeepEqual(server, p.servers[name]) {
+			client.Close()
+			delete(p.clients, name)
+		}
+	}
+
+	p.servers = servers
+}
+
+// Names returns the names of the configured servers, sorted.
+func (p *Pool) Names() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	names := make([]string, 0, len(p.servers))
+	for name := range p.servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Client returns the client of the named server, starting it if needed.
+// The pool's lock is held while a server starts, so each starts only once.
+func (p *Pool) Client(ctx context.Context, name string) (*Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if client, ok := p.clients[name]; ok {
+		select {
+		case <-client.conn.Done():
+			// The server has exited, start it again
+			delete(p.clients, name)
+		default:
+			return client, nil
+		}
+	}
+
+	server, ok := p.servers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown mcp server %q", name)
+	}
+
+	client, err := Start(ctx, name, server)
+	if err != nil {
+		return nil, err
+	}
+	p.clients[name] = client
+
+	return client, nil
+}
+
+// Close stops every started server.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	errs := make([]error, 0)
+	for name, client := range p.clients {
+		if err := client.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(p.clients, name)
+	}
+
+	return errors.Join(errs...)
+}
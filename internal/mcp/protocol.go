@@ -0,0 +1,122 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ProtocolVersion is the version of the Model Context Protocol spoken.
+const ProtocolVersion = "2024-11-05"
+
+// Implementation names a client or server and its version.
+type Implementation struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Tool is a tool a server offers, with the JSON schema of its arguments.
+type Tool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"inputSchema"`
+}
+
+// Resource is a piece of content a server offers, identified by its URI.
+type Resource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType,omitempty"`
+}
+
+// Content is an item of a tool result.
+type Content struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	MimeType string `json:"mimeType,omitempty"`
+	Data     string `json:"data,omitempty"`
+}
+
+// ResourceContents is the content of a resource, as text or a base64 blob.
+type ResourceContents struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Blob     string `json:"blob,omitempty"`
+}
+
+type initializeParams struct {
+	ProtocolVersion string                 `json:"protocolVersion"`
+	Capabilities    map[string]interface{} `json:"capabilities"`
+	ClientInfo      Implementation         `json:"clientInfo"`
+}
+
+type initializeResult struct {
+	ProtocolVersion string                     `json:"protocolVersion"`
+	Capabilities    map[string]json.RawMessage `json:"capabilities"`
+	ServerInfo      Implementation             `json:"serverInfo"`
+	Instructions    string                     `json:"instructions,omitempty"`
+}
+
+type listParams struct {
+	Cursor string `json:"cursor,omitempty"`
+}
+
+type listToolsResult struct {
+	Tools      []Tool `json:"tools"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+type listResourcesResult struct {
+	Resources  []Resource `json:"resources"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+}
+
+type callToolParams struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+type callToolResult struct {
+	Content []Content `json:"content"`
+	IsError bool      `json:"isError,omitempty"`
+}
+
+type readResourceParams struct {
+	URI string `json:"uri"`
+}
+
+type readResourceResult struct {
+	Contents []ResourceContents `json:"contents"`
+}
+
+// contentText joins the text of tool result content, describing any other
+// kind of content rather than including it.
+func contentText(content []Content) string {
+	parts := make([]string, 0, len(content))
+	for _, item := range content {
+		if item.Type == "text" {
+			parts = append(parts, item.Text)
+		} else {
+			parts = append(parts, fmt.Sprintf("[%s content, %s]", item.Type, item.MimeType))
+		}
+	}
+
+	return strings.Join(parts, "\n")
+}
+
+// contentsText joins the text of resource contents, describing blobs rather
+// than including them.
+func contentsText(contents []ResourceContents) string {
+	parts := make([]string, 0, len(contents))
+	for _, item := range contents {
+		if item.Blob != "" {
+			parts = append(parts, fmt.Sprintf("[binary content of %s, %s]", item.URI, item.MimeType))
+		} else {
+			parts = append(parts, item.Text)
+		}
+	}
+
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,176 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/jcowgar/acme-utils/internal/jsonrpc"
+)
+
+// ToolFunc runs a tool with the JSON object of arguments from the client,
+// returning the text of its result.
+type ToolFunc func(ctx context.Context, arguments json.RawMessage) (string, error)
+
+// ResourceTemplate describes a family of resources whose URIs follow a
+// pattern, such as file:///{path}.
+type ResourceTemplate struct {
+	URITemplate string `json:"uriTemplate"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType,omitempty"`
+}
+
+// Server answers MCP clients with the tools and resources given to it.
+type Server struct {
+	Info Implementation
+
+	// Instructions tell the client's model how to use the server.
+	Instructions string
+
+	// ListResources lists the resources offered, nil when there are none
+	// to list.
+	ListResources func(ctx context.Context) ([]Resource, error)
+
+	// ReadResource reads a resource, nil when the server offers none.
+	ReadResource func(ctx context.Context, uri string) ([]ResourceContents, error)
+
+	// ResourceTemplates describe the resources that may be read without
+	// being listed.
+	ResourceTemplates []ResourceTemplate
+
+	tools map[string]serverTool
+}
+
+type serverTool struct {
+	Tool
+	run ToolFunc
+}
+
+// NewServer returns a server calling itself info, offering nothing yet.
+func NewServer(info Implementation) *Server {
+	return &Server{Info: info, tools: make(map[string]serverTool)}
+}
+
+// AddTool offers a tool, replacing any of the same name.
+func (s *Server) AddTool(tool Tool, run ToolFunc) {
+	if tool.InputSchema == nil {
+		tool.InputSchema = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+	}
+
+	s.tools[tool.Name] = serverTool{Tool: tool, run: run}
+}
+
+// Serve answers the client over stream until it hangs up.
+func (s *Server) Serve(stream jsonrpc.Stream) error {
+	return jsonrpc.NewConn(stream, s.handle).Wait()
+}
+
+func (s *Server) handle(ctx context.Context, conn *jsonrpc.Conn, req *jsonrpc.Request) (interface{}, error) {
+	switch req.Method {
+	case "initialize":
+		capabilities := map[string]interface{}{"tools": map[string]interface{}{}}
+		if s.ReadResource != nil {
+			capabilities["resources"] = map[string]interface{}{}
+		}
+
+		return map[string]interface{}{
+			"protocolVersion": ProtocolVersion,
+			"capabilities":    capabilities,
+			"serverInfo":      s.Info,
+			"instructions":    s.Instructions,
+		}, nil
+	case "ping":
+		return struct{}{}, nil
+	case "notifications/initialized":
+		return nil, nil
+	case "notifications/cancelled":
+		var params struct {
+			RequestID json.RawMessage `json:"requestId"`
+		}
+		if err := req.DecodeParams(&params); err == nil {
+			conn.Cancel(params.RequestID)
+		}
+		return nil, nil
+	case "tools/list":
+		return listToolsResult{Tools: s.toolList()}, nil
+	case "tools/call":
+		return s.callTool(ctx, req)
+	case "resources/list":
+		resources := make([]Resource, 0)
+		if s.ListResources != nil {
+			listed, err := s.ListResources(ctx)
+			if err != nil {
+				return nil, err
+			}
+			resources = listed
+		}
+		return listResourcesResult{Resources: resources}, nil
+	case "resources/templates/list":
+		templates := s.ResourceTemplates
+		if templates == nil {
+			templates = make([]ResourceTemplate, 0)
+		}
+		return map[string]interface{}{"resourceTemplates": templates}, nil
+	case "resources/read":
+		if s.ReadResource == nil {
+			return nil, jsonrpc.MethodNotFound(req.Method)
+		}
+
+		var params readResourceParams
+		if err := req.DecodeParams(&params); err != nil {
+			return nil, err
+		}
+
+		contents, err := s.ReadResource(ctx, params.URI)
+		if err != nil {
+			return nil, &jsonrpc.Error{Code: codeResourceNotFound, Message: err.Error()}
+		}
+		return readResourceResult{Contents: contents}, nil
+	}
+
+	return nil, jsonrpc.MethodNotFound(req.Method)
+}
+
+// codeResourceNotFound is the error MCP gives for a resource that cannot be
+// read.
+const codeResourceNotFound = -32002
+
+func (s *Server) toolList() []Tool {
+	tools := make([]Tool, 0, len(s.tools))
+	for _, tool := range s.tools {
+		tools = append(tools, tool.Tool)
+	}
+
+	sort.Slice(tools, func(i, j int) bool {
+		return tools[i].Name < tools[j].Name
+	})
+
+	return tools
+}
+
+// callTool runs a tool. A tool that fails reports its error in the result,
+// for the client's model to see, rather than as an error response.
+func (s *Server) callTool(ctx context.Context, req *jsonrpc.Request) (interface{}, error) {
+	var params callToolParams
+	if err := req.DecodeParams(&params); err != nil {
+		return nil, err
+	}
+
+	tool, ok := s.tools[params.Name]
+	if !ok {
+		return nil, &jsonrpc.Error{Code: jsonrpc.CodeInvalidParams, Message: "unknown tool: " + params.Name}
+	}
+
+	arguments := params.Arguments
+	if len(arguments) == 0 || string(arguments) == "null" {
+		arguments = json.RawMessage("{}")
+	}
+
+	text, err := tool.run(ctx, arguments)
+	if err != nil {
+		return callToolResult{Content: []Content{{Type: "text", Text: err.Error()}}, IsError: true}, nil
+	}
+
+	return callToolResult{Content: []Content{{Type: "text", Text: text}}}, nil
+}
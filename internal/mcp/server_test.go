@@ -0,0 +1,84 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/jcowgar/acme-utils/internal/jsonrpc"
+)
+
+func TestServer(t *testing.T) {
+	server := NewServer(Implementation{Name: "test", Version: "1"})
+	server.AddTool(Tool{Name: "upper", Description: "Upper case the text"}, func(ctx context.Context, arguments json.RawMessage) (string, error) {
+		var args struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(arguments, &args); err != nil {
+			return "", err
+		}
+		if args.Text == "" {
+			return "", errors.New("no text given")
+		}
+		return strings.ToUpper(args.Text), nil
+	})
+	server.ListResources = func(ctx context.Context) ([]Resource, error) {
+		return []Resource{{URI: "test://a", Name: "A"}}, nil
+	}
+	server.ReadResource = func(ctx context.Context, uri string) ([]ResourceContents, error) {
+		if uri != "test://a" {
+			return nil, errors.New("no such resource")
+		}
+		return []ResourceContents{{URI: uri, Text: "content of a"}}, nil
+	}
+
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+	go server.Serve(jsonrpc.NewLineStream(serverReader, serverWriter))
+
+	ctx := context.Background()
+
+	client, err := Connect(ctx, "test", jsonrpc.NewLineStream(clientReader, clientWriter))
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer client.Close()
+
+	if client.ServerInfo.Name != "test" {
+		t.Errorf("ServerInfo = %+v, want test", client.ServerInfo)
+	}
+
+	tools, err := client.ListTools(ctx)
+	if err != nil || len(tools) != 1 || tools[0].Name != "upper" || tools[0].InputSchema["type"] != "object" {
+		t.Errorf("ListTools() = %+v, %v, want upper with an object schema", tools, err)
+	}
+
+	if got, err := client.CallTool(ctx, "upper", json.RawMessage(`{"text":"shout"}`)); err != nil || got != "SHOUT" {
+		t.Errorf("CallTool(upper) = %q, %v, want SHOUT", got, err)
+	}
+
+	// A failing tool reports its error in the result
+	if _, err := client.CallTool(ctx, "upper", nil); err == nil || err.Error() != "no text given" {
+		t.Errorf("CallTool(upper) without text error = %v, want no text given", err)
+	}
+
+	var rpcErr *jsonrpc.Error
+	if _, err := client.CallTool(ctx, "missing", nil); !errors.As(err, &rpcErr) || rpcErr.Code != jsonrpc.CodeInvalidParams {
+		t.Errorf("CallTool(missing) error = %v, want invalid params", err)
+	}
+
+	resources, err := client.ListResources(ctx)
+	if err != nil || len(resources) != 1 || resources[0].URI != "test://a" {
+		t.Errorf("ListResources() = %+v, %v, want test://a", resources, err)
+	}
+
+	if got, err := client.ReadResource(ctx, "test://a"); err != nil || got != "content of a" {
+		t.Errorf("ReadResource() = %q, %v, want content of a", got, err)
+	}
+	if _, err := client.ReadResource(ctx, "test://b"); !errors.As(err, &rpcErr) || rpcErr.Code != codeResourceNotFound {
+		t.Errorf("ReadResource(test://b) error = %v, want resource not found", err)
+	}
+}
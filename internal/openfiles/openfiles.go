@@ -0,0 +1,158 @@
+package openfiles
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/jcowgar/acme-utils/internal/buffer"
+)
+
+// Source reports the files the user currently has open, which the +files
+// directive attaches to a message.
+type Source interface {
+	// OpenFiles returns the absolute paths of the open files
+	OpenFiles() ([]string, error)
+
+	// Name returns the source's name for identification
+	Name() string
+}
+
+// AcmeSource reports the files open in Acme windows.
+type AcmeSource struct {
+	Windows *buffer.AcmeSource
+}
+
+func (s AcmeSource) Name() string {
+	return "acme"
+}
+
+func (s AcmeSource) OpenFiles() ([]string, error) {
+	windows, err := s.Windows.Windows()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(windows))
+	for _, window := range windows {
+		if !window.IsDir && filepath.IsAbs(window.Name) {
+			files = append(files, window.Name)
+		}
+	}
+
+	return files, nil
+}
+
+// ListSource reports a list of files given by the editor, such as Helix
+// passing its open buffers on the command line or standard input. Relative
+// paths are taken relative to Dir.
+type ListSource struct {
+	Dir   string
+	Files []string
+}
+
+// ReadList reads a list of files, one per line, ignoring blank lines.
+func ReadList(r io.Reader) ([]string, error) {
+	files := make([]string, 0)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			files = append(files, line)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read file list: %w", err)
+	}
+
+	return files, nil
+}
+
+func (s ListSource) Name() string {
+	return "list"
+}
+
+func (s ListSource) OpenFiles() ([]string, error) {
+	files := make([]string, 0, len(s.Files))
+	for _, file := range s.Files {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(s.Dir, file)
+		}
+		files = append(files, filepath.Clean(file))
+	}
+
+	return files, nil
+}
+
+// GitSource reports the files modified or added in the git working tree
+// containing Dir, as the files being worked on.
+type GitSource struct {
+	Dir string
+}
+
+func (s GitSource) Name() string {
+	return "git"
+}
+
+func (s GitSource) OpenFiles() ([]string, error) {
+	root, err := s.git("rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	root = strings.TrimSpace(root)
+
+	status, err := s.git("status", "--porcelain=v1", "-z", "--untracked-files=all")
+	if err != nil {
+		return nil, err
+	}
+
+	return parseGitStatus(root, status), nil
+}
+
+func (s GitSource) git(args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", s.Dir}, args...)...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
+
+// parseGitStatus returns the absolute paths of the files in NUL separated
+// porcelain status output that still exist in the working tree.
+func parseGitStatus(root string, status string) []string {
+	files := make([]string, 0)
+	entries := strings.Split(status, "\x00")
+
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+
+		code, path := entry[:2], entry[3:]
+
+		// Renames and copies are followed by the original path
+		if code[0] == 'R' || code[0] == 'C' {
+			i++
+		}
+
+		if code[0] == 'D' || code[1] == 'D' {
+			continue
+		}
+
+		files = append(files, filepath.Join(root, path))
+	}
+
+	return files
+}
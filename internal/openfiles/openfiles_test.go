@@ -0,0 +1,88 @@
+package openfiles
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestListSource(t *testing.T) {
+	files, err := ReadList(strings.NewReader("main.go\n\n  internal/x.go \n/abs/y.go\n"))
+	if err != nil {
+		t.Fatalf("ReadList() error = %v", err)
+	}
+
+	got, err := ListSource{Dir: "/project", Files: files}.OpenFiles()
+	if err != nil {
+		t.Fatalf("OpenFiles() error = %v", err)
+	}
+
+	want := []string{"/project/main.go", "/project/internal/x.go", "/abs/y.go"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("OpenFiles() = %v, want %v", got, want)
+	}
+}
+
+func TestParseGitStatus(t *testing.T) {
+	status := " M main.go\x00?? docs/new.md\x00R  renamed.go\x00original.go\x00 D gone.go\x00A  added.go\x00"
+
+	got := parseGitStatus("/repo", status)
+	want := []string{"/repo/main.go", "/repo/docs/new.md", "/repo/renamed.go", "/repo/added.go"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseGitStatus() = %v, want %v", got, want)
+	}
+}
+
+func TestGitSource(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	run := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, out)
+		}
+	}
+	write := func(name string, content string) {
+		t.Helper()
+		filename := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run("init", "-q")
+	write("clean.go", "package clean\n")
+	write("changed.go", "package changed\n")
+	run("add", ".")
+	run("-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "initial")
+
+	write("changed.go", "package changed // edited\n")
+	write("sub/untracked.go", "package sub\n")
+
+	got, err := GitSource{Dir: dir}.OpenFiles()
+	if err != nil {
+		t.Fatalf("OpenFiles() error = %v", err)
+	}
+
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(got)
+	want := []string{filepath.Join(root, "changed.go"), filepath.Join(root, "sub/untracked.go")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("OpenFiles() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,390 @@
+package patch
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DevNull is the name a diff gives the missing side of a created or deleted
+// file.
+const DevNull = "/dev/null"
+
+// contextLines is the number of unchanged lines around each change in a diff
+const contextLines = 3
+
+// maxCells bounds the work of comparing two texts line by line, beyond which
+// a diff replaces the whole text
+const maxCells = 4 << 20
+
+const noNewline = `\ No newline at end of file`
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// Hunk is a run of changed lines along with the unchanged lines around them.
+// Each line keeps its leading ' ', '-' or '+' and its newline, if it has one.
+type Hunk struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+	Lines              []string
+}
+
+// File is the change a unified diff makes to one file.
+type File struct {
+	OldName, NewName string
+	Hunks            []Hunk
+}
+
+// IsNew reports whether the change creates the file.
+func (f File) IsNew() bool {
+	return f.OldName == DevNull
+}
+
+// IsDelete reports whether the change deletes the file.
+func (f File) IsDelete() bool {
+	return f.NewName == DevNull
+}
+
+// Path returns the name of the changed file without the a/ or b/ prefix
+// diffs usually give it.
+func (f File) Path() string {
+	name := f.NewName
+	if f.IsDelete() {
+		name = f.OldName
+	}
+
+	if strings.HasPrefix(name, "a/") || strings.HasPrefix(name, "b/") {
+		name = name[2:]
+	}
+
+	return name
+}
+
+// splitLines splits text into lines, each keeping its newline.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+type op struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// Diff returns the unified diff turning oldText into newText, or an empty
+// string when they are the same. A missing file is named DevNull.
+func Diff(oldName string, newName string, oldText string, newText string) string {
+	ops := diffLines(splitLines(oldText), splitLines(newText))
+
+	hunks := buildHunks(ops)
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+	for _, hunk := range hunks {
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(hunk.OldStart, hunk.OldLines), hunkRange(hunk.NewStart, hunk.NewLines))
+		for _, line := range hunk.Lines {
+			b.WriteString(line)
+			if !strings.HasSuffix(line, "\n") {
+				b.WriteString("\n" + noNewline + "\n")
+			}
+		}
+	}
+
+	return b.String()
+}
+
+func hunkRange(start int, lines int) string {
+	if lines == 1 {
+		return strconv.Itoa(start)
+	}
+
+	return fmt.Sprintf("%d,%d", start, lines)
+}
+
+// diffLines returns the edits turning a into b, keeping the longest common
+// subsequence of lines unchanged.
+func diffLines(a []string, b []string) []op {
+	ops := make([]op, 0, len(a)+len(b))
+
+	// Common prefix and suffix need no comparing
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	for _, line := range a[:prefix] {
+		ops = append(ops, op{' ', line})
+	}
+
+	middleA, middleB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	n, m := len(middleA), len(middleB)
+
+	if (n+1)*(m+1) > maxCells {
+		for _, line := range middleA {
+			ops = append(ops, op{'-', line})
+		}
+		for _, line := range middleB {
+			ops = append(ops, op{'+', line})
+		}
+	} else {
+		// lcs[i*(m+1)+j] is the length of the longest common subsequence
+		// of middleA[i:] and middleB[j:]
+		lcs := make([]int32, (n+1)*(m+1))
+		for i := n - 1; i >= 0; i-- {
+			for j := m - 1; j >= 0; j-- {
+				if middleA[i] == middleB[j] {
+					lcs[i*(m+1)+j] = lcs[(i+1)*(m+1)+j+1] + 1
+				} else {
+					lcs[i*(m+1)+j] = max(lcs[(i+1)*(m+1)+j], lcs[i*(m+1)+j+1])
+				}
+			}
+		}
+
+		i, j := 0, 0
+		for i < n || j < m {
+			switch {
+			case i < n && j < m && middleA[i] == middleB[j]:
+				ops = append(ops, op{' ', middleA[i]})
+				i++
+				j++
+			case j == m || (i < n && lcs[(i+1)*(m+1)+j] >= lcs[i*(m+1)+j+1]):
+				ops = append(ops, op{'-', middleA[i]})
+				i++
+			default:
+				ops = append(ops, op{'+', middleB[j]})
+				j++
+			}
+		}
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, op{' ', line})
+	}
+
+	return ops
+}
+
+// buildHunks groups edits into hunks, joining changes close enough that their
+// context would overlap.
+func buildHunks(ops []op) []Hunk {
+	// Lines of each file before each edit
+	oldBefore := make([]int, len(ops)+1)
+	newBefore := make([]int, len(ops)+1)
+	for k, o := range ops {
+		oldBefore[k+1], newBefore[k+1] = oldBefore[k], newBefore[k]
+		if o.kind != '+' {
+			oldBefore[k+1]++
+		}
+		if o.kind != '-' {
+			newBefore[k+1]++
+		}
+	}
+
+	hunks := make([]Hunk, 0)
+	for k := 0; k < len(ops); k++ {
+		if ops[k].kind == ' ' {
+			continue
+		}
+
+		// Extend the hunk while the next change is within reach
+		last := k
+		for next := k + 1; next < len(ops) && next-last <= 2*contextLines; next++ {
+			if ops[next].kind != ' ' {
+				last = next
+			}
+		}
+
+		start := max(0, k-contextLines)
+		end := min(len(ops), last+contextLines+1)
+
+		hunk := Hunk{
+			OldStart: oldBefore[start] + 1,
+			OldLines: oldBefore[end] - oldBefore[start],
+			NewStart: newBefore[start] + 1,
+			NewLines: newBefore[end] - newBefore[start],
+		}
+		if hunk.OldLines == 0 {
+			hunk.OldStart--
+		}
+		if hunk.NewLines == 0 {
+			hunk.NewStart--
+		}
+		for _, o := range ops[start:end] {
+			hunk.Lines = append(hunk.Lines, string(o.kind)+o.line)
+		}
+
+		hunks = append(hunks, hunk)
+		k = end - 1
+	}
+
+	return hunks
+}
+
+// Parse reads the files of a unified diff, as made by Diff or git diff.
+//
+// Line counts in hunk headers are not trusted, a hunk runs until the next
+// hunk or file, and a blank line within a hunk is taken as an unchanged
+// blank line, as hand written diffs often lose the leading space.
+func Parse(diff string) ([]File, error) {
+	lines := splitLines(strings.TrimRight(diff, "\n") + "\n")
+	files := make([]File, 0)
+
+	var file *File
+	var hunk *Hunk
+
+	finishHunk := func() {
+		if hunk == nil {
+			return
+		}
+
+		hunk.OldLines, hunk.NewLines = 0, 0
+		for _, line := range hunk.Lines {
+			if line[0] != '+' {
+				hunk.OldLines++
+			}
+			if line[0] != '-' {
+				hunk.NewLines++
+			}
+		}
+
+		file.Hunks = append(file.Hunks, *hunk)
+		hunk = nil
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		if strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ ") {
+			finishHunk()
+			files = append(files, File{OldName: fileName(line[4:]), NewName: fileName(lines[i+1][4:])})
+			file = &files[len(files)-1]
+			i++
+			continue
+		}
+
+		if match := hunkHeader.FindStringSubmatch(line); match != nil {
+			if file == nil {
+				return nil, errors.New("hunk before any file header")
+			}
+			finishHunk()
+
+			oldStart, _ := strconv.Atoi(match[1])
+			newStart, _ := strconv.Atoi(match[3])
+			hunk = &Hunk{OldStart: oldStart, NewStart: newStart}
+			continue
+		}
+
+		if hunk == nil {
+			// Headers such as diff --git and index lines
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, `\`):
+			if n := len(hunk.Lines); n > 0 {
+				hunk.Lines[n-1] = strings.TrimSuffix(hunk.Lines[n-1], "\n")
+			}
+		case line == "\n":
+			hunk.Lines = append(hunk.Lines, " \n")
+		case line[0] == ' ' || line[0] == '-' || line[0] == '+':
+			hunk.Lines = append(hunk.Lines, line)
+		case strings.HasPrefix(line, "diff "):
+			finishHunk()
+		default:
+			return nil, fmt.Errorf("unexpected line in hunk: %q", strings.TrimSuffix(line, "\n"))
+		}
+	}
+	finishHunk()
+
+	if len(files) == 0 {
+		return nil, errors.New("no files in diff")
+	}
+
+	return files, nil
+}
+
+// fileName returns the name in a --- or +++ header, dropping any timestamp.
+func fileName(header string) string {
+	name, _, _ := strings.Cut(strings.TrimSuffix(header, "\n"), "\t")
+	return strings.TrimSpace(name)
+}
+
+// Apply returns text with the file's hunks applied. A hunk whose lines have
+// moved is applied where its lines are found nearest to where it says; one
+// whose lines are not found is an error.
+func (f File) Apply(text string) (string, error) {
+	lines := splitLines(text)
+	result := make([]string, 0, len(lines))
+	pos := 0
+
+	for n, hunk := range f.Hunks {
+		old := make([]string, 0, len(hunk.Lines))
+		replacement := make([]string, 0, len(hunk.Lines))
+		for _, line := range hunk.Lines {
+			if line[0] != '+' {
+				old = append(old, line[1:])
+			}
+			if line[0] != '-' {
+				replacement = append(replacement, line[1:])
+			}
+		}
+
+		expected := hunk.OldStart - 1
+		if len(old) == 0 {
+			expected = hunk.OldStart
+		}
+
+		at := findLines(lines, old, pos, expected)
+		if at < 0 {
+			return "", fmt.Errorf("hunk %d of %s does not apply", n+1, f.Path())
+		}
+
+		result = append(result, lines[pos:at]...)
+		result = append(result, replacement...)
+		pos = at + len(old)
+	}
+
+	result = append(result, lines[pos:]...)
+
+	return strings.Join(result, ""), nil
+}
+
+// findLines returns the index of want in lines, no earlier than from, that
+// is closest to expected, or -1 if it is not there.
+func findLines(lines []string, want []string, from int, expected int) int {
+	matches := func(at int) bool {
+		if at < from || at+len(want) > len(lines) {
+			return false
+		}
+		for i, line := range want {
+			if lines[at+i] != line {
+				return false
+			}
+		}
+		return true
+	}
+
+	for offset := 0; expected-offset >= from || expected+offset <= len(lines); offset++ {
+		if matches(expected - offset) {
+			return expected - offset
+		}
+		if matches(expected + offset) {
+			return expected + offset
+		}
+	}
+
+	return -1
+}
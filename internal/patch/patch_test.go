@@ -0,0 +1,125 @@
+package patch
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		oldText string
+		newText string
+	}{
+		{"change a line", "a\nb\nc\n", "a\nB\nc\n"},
+		{"add lines", "a\nb\n", "a\nx\nb\ny\n"},
+		{"remove lines", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", "1\n3\n4\n5\n6\n7\n8\n10\n"},
+		{"separate hunks", strings.Repeat("line\n", 20) + "end\n", "start\n" + strings.Repeat("line\n", 20) + "END\n"},
+		{"create", "", "new\nfile\n"},
+		{"delete", "old\nfile\n", ""},
+		{"no newline at end", "a\nb", "a\nc"},
+		{"add newline at end", "a\nb", "a\nb\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := Diff("a/file.txt", "b/file.txt", tt.oldText, tt.newText)
+
+			files, err := Parse(diff)
+			if err != nil {
+				t.Fatalf("Parse() error = %v\n%s", err, diff)
+			}
+			if len(files) != 1 {
+				t.Fatalf("Parse() files = %d, want 1", len(files))
+			}
+
+			got, err := files[0].Apply(tt.oldText)
+			if err != nil {
+				t.Fatalf("Apply() error = %v\n%s", err, diff)
+			}
+			if got != tt.newText {
+				t.Errorf("Apply() = %q, want %q\n%s", got, tt.newText, diff)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	got := Diff(DevNull, "b/hello.txt", "", "hello\n")
+	want := "--- /dev/null\n+++ b/hello.txt\n@@ -0,0 +1 @@\n+hello\n"
+	if got != want {
+		t.Errorf("Diff() = %q, want %q", got, want)
+	}
+
+	if got := Diff("a/same", "b/same", "x\n", "x\n"); got != "" {
+		t.Errorf("Diff() of the same text = %q, want empty", got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	// Written as a model might, with wrong counts and a blank context line
+	// that lost its space
+	diff := `diff --git a/main.go b/main.go
+index 83db48f..bf269f4 100644
+--- a/main.go
++++ b/main.go
+@@ -1,9 +1,9 @@
+ package main
+
+-func main() {}
++func main() { run() }
+--- /dev/null
++++ b/run.go
+@@ -0,0 +1,3 @@
++package main
++
++func run() {}
+`
+
+	files, err := Parse(diff)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Parse() files = %d, want 2", len(files))
+	}
+
+	if files[0].Path() != "main.go" || files[0].IsNew() || files[0].IsDelete() {
+		t.Errorf("first file = %+v, want main.go changed", files[0])
+	}
+	if files[1].Path() != "run.go" || !files[1].IsNew() {
+		t.Errorf("second file = %+v, want run.go created", files[1])
+	}
+
+	got, err := files[0].Apply("package main\n\nfunc main() {}\n")
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if want := "package main\n\nfunc main() { run() }\n"; got != want {
+		t.Errorf("Apply() = %q, want %q", got, want)
+	}
+
+	if _, err := Parse("not a diff\n"); err == nil {
+		t.Error("Parse() of text without files succeeded, want an error")
+	}
+}
+
+func TestApplyMoved(t *testing.T) {
+	files, err := Parse("--- a/f\n+++ b/f\n@@ -2,3 +2,3 @@\n x\n-y\n+Y\n z\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	// The lines have moved down since the diff was made
+	got, err := files[0].Apply("new\nlines\na\nx\ny\nz\n")
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if want := "new\nlines\na\nx\nY\nz\n"; got != want {
+		t.Errorf("Apply() = %q, want %q", got, want)
+	}
+
+	if _, err := files[0].Apply("a\nx\nchanged\nz\n"); err == nil {
+		t.Error("Apply() to changed lines succeeded, want an error")
+	}
+}
@@ -0,0 +1,196 @@
+package prompt
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ProjectDir is where a project keeps its templates, relative to the project
+// directory.
+const ProjectDir = ".ai-stdio/prompts"
+
+// Extension is the extension of template files, which is not part of the
+// template's name.
+const Extension = ".md"
+
+// Template is a named prompt template.
+type Template struct {
+	Name string
+	Path string
+}
+
+// Library finds templates in a list of directories, earlier directories
+// taking precedence over later ones.
+type Library struct {
+	Dirs []string
+}
+
+// NewLibrary returns the library of a project: the templates of the project
+// itself, then those in the prompts directory of the configuration.
+// configDir may be empty to leave out the configured templates.
+func NewLibrary(projectDir string, configDir string) *Library {
+	dirs := make([]string, 0, 2)
+	if projectDir != "" {
+		dirs = append(dirs, filepath.Join(projectDir, ProjectDir))
+	}
+	if configDir != "" {
+		dirs = append(dirs, filepath.Join(configDir, "prompts"))
+	}
+
+	return &Library{Dirs: dirs}
+}
+
+// Find returns the template called name.
+func (l *Library) Find(name string) (Template, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return Template{}, fmt.Errorf("invalid template name %q", name)
+	}
+
+	for _, dir := range l.Dirs {
+		filename := filepath.Join(dir, name+Extension)
+		if _, err := os.Stat(filename); err == nil {
+			return Template{Name: name, Path: filename}, nil
+		}
+	}
+
+	return Template{}, fmt.Errorf("template %q not found in %s", name, strings.Join(l.Dirs, ", "))
+}
+
+// List returns every template, sorted by name, hiding any overridden by a
+// template of the same name in an earlier directory.
+func (l *Library) List() ([]Template, error) {
+	seen := make(map[string]bool)
+	templates := make([]Template, 0)
+
+	for _, dir := range l.Dirs {
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("could not read templates: %w", err)
+		}
+
+		for _, entry := range entries {
+			name, ok := strings.CutSuffix(entry.Name(), Extension)
+			if !ok || entry.IsDir() || seen[name] {
+				continue
+			}
+			seen[name] = true
+			templates = append(templates, Template{Name: name, Path: filepath.Join(dir, entry.Name())})
+		}
+	}
+
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].Name < templates[j].Name
+	})
+
+	return templates, nil
+}
+
+// Vars are the values available to a template, such as {{.Project}} or
+// {{.Params.audience}}.
+type Vars struct {
+	// Project is the name of the project directory.
+	Project    string
+	ProjectDir string
+
+	// Branch is the current git branch, empty outside a git repository.
+	Branch string
+
+	// Language is the main language of the project, guessed from the files
+	// at its root, or empty when unknown.
+	Language string
+
+	// Date is today's date as YYYY-MM-DD.
+	Date string
+
+	// Model is the model the chat selects, empty for the default.
+	Model string
+
+	// Params are the other front matter values of the chat.
+	Params map[string]string
+}
+
+// DetectVars returns the variables describing the project in projectDir.
+func DetectVars(projectDir string) Vars {
+	return Vars{
+		Project:    filepath.Base(projectDir),
+		ProjectDir: projectDir,
+		Branch:     gitBranch(projectDir),
+		Language:   detectLanguage(projectDir),
+		Date:       time.Now().Format("2006-01-02"),
+		Params:     make(map[string]string),
+	}
+}
+
+// Render executes the template with vars. Referring to a variable that does
+// not exist is an error.
+func (t Template) Render(vars Vars) (string, error) {
+	content, err := os.ReadFile(t.Path)
+	if err != nil {
+		return "", fmt.Errorf("could not read template: %w", err)
+	}
+
+	return Render(t.Name, string(content), vars)
+}
+
+// Render executes the template text with vars.
+func Render(name string, text string, vars Vars) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("could not parse template %s: %w", name, err)
+	}
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, vars); err != nil {
+		return "", fmt.Errorf("could not render template %s: %w", name, err)
+	}
+
+	return strings.TrimSpace(b.String()), nil
+}
+
+func gitBranch(dir string) string {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "--abbrev-ref", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+// languageMarkers maps files found at the root of a project to its language,
+// checked in order.
+var languageMarkers = []struct {
+	filename string
+	language string
+}{
+	{"go.mod", "Go"},
+	{"Cargo.toml", "Rust"},
+	{"tsconfig.json", "TypeScript"},
+	{"package.json", "JavaScript"},
+	{"pyproject.toml", "Python"},
+	{"requirements.txt", "Python"},
+	{"setup.py", "Python"},
+	{"Gemfile", "Ruby"},
+	{"pom.xml", "Java"},
+	{"build.gradle", "Java"},
+	{"mix.exs", "Elixir"},
+	{"CMakeLists.txt", "C++"},
+}
+
+func detectLanguage(dir string) string {
+	for _, marker := range languageMarkers {
+		if _, err := os.Stat(filepath.Join(dir, marker.filename)); err == nil {
+			return marker.language
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,98 @@
+package prompt
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeTemplate(t *testing.T, dir string, name string, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLibrary(t *testing.T) {
+	projectDir := t.TempDir()
+	configDir := t.TempDir()
+
+	writeTemplate(t, filepath.Join(projectDir, ProjectDir), "review.md", "project review")
+	writeTemplate(t, filepath.Join(configDir, "prompts"), "review.md", "global review")
+	writeTemplate(t, filepath.Join(configDir, "prompts"), "explain.md", "global explain")
+	writeTemplate(t, filepath.Join(configDir, "prompts"), "notes.txt", "not a template")
+
+	library := NewLibrary(projectDir, configDir)
+
+	tmpl, err := library.Find("review")
+	if err != nil {
+		t.Fatalf("Find() error = %v", err)
+	}
+	if tmpl.Path != filepath.Join(projectDir, ProjectDir, "review.md") {
+		t.Errorf("Find() = %v, want the project template", tmpl.Path)
+	}
+
+	if _, err := library.Find("missing"); err == nil {
+		t.Errorf("Find(missing) error = nil")
+	}
+	if _, err := library.Find("../review"); err == nil {
+		t.Errorf("Find(../review) error = nil, want names to stay within the library")
+	}
+
+	templates, err := library.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+
+	names := make([]string, 0, len(templates))
+	for _, tmpl := range templates {
+		names = append(names, tmpl.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"explain", "review"}) {
+		t.Errorf("List() = %v, want explain and review", names)
+	}
+}
+
+func TestRender(t *testing.T) {
+	vars := Vars{
+		Project:  "acme-utils",
+		Branch:   "main",
+		Language: "Go",
+		Date:     "2024-05-01",
+		Params:   map[string]string{"audience": "reviewers"},
+	}
+
+	got, err := Render("review", "Review {{.Project}} ({{.Language}}) on {{.Branch}} for {{.Params.audience}}, {{.Date}}.\n", vars)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	want := "Review acme-utils (Go) on main for reviewers, 2024-05-01."
+	if got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+
+	if _, err := Render("bad", "{{.Params.missing}}", vars); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("Render() of a missing parameter error = %v", err)
+	}
+	if _, err := Render("bad", "{{.Unknown}}", vars); err == nil {
+		t.Errorf("Render() of an unknown variable error = nil")
+	}
+}
+
+func TestDetectVars(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "widget")
+	writeTemplate(t, dir, "package.json", "{}")
+	writeTemplate(t, dir, "tsconfig.json", "{}")
+
+	vars := DetectVars(dir)
+	if vars.Project != "widget" || vars.Language != "TypeScript" || vars.Date == "" {
+		t.Errorf("DetectVars() = %+v", vars)
+	}
+}
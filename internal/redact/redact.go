@@ -0,0 +1,254 @@
+package redact
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jcowgar/acme-utils/internal/config"
+)
+
+// Detector finds secrets of a single kind within text.
+type Detector struct {
+	Name  string
+	Regex *regexp.Regexp
+
+	// Accept, when set, is consulted for every match and may reject it.
+	Accept func(match string) bool
+}
+
+// Finding records how many secrets a detector found in a named source.
+type Finding struct {
+	Detector string
+	Source   string
+	Count    int
+}
+
+// Redactor replaces secrets with stable placeholders.
+//
+// The same secret is always replaced with the same placeholder for the
+// lifetime of a Redactor, so the model can still tell that two redacted
+// values are identical.
+type Redactor struct {
+	detectors    []Detector
+	placeholders map[string]string
+	counters     map[string]int
+}
+
+const highEntropyMinimum = 4.2
+
+var builtinDetectors = []Detector{
+	{
+		Name:  "private_key",
+		Regex: regexp.MustCompile(`(?s)-----BEGIN [A-Z0-9 ]*PRIVATE KEY(?: BLOCK)?-----.*?-----END [A-Z0-9 ]*PRIVATE KEY(?: BLOCK)?-----`),
+	},
+	{
+		Name:  "aws_access_key",
+		Regex: regexp.MustCompile(`\b(?:AKIA|ASIA|AGPA|AIDA|AROA|ANPA|ANVA|AIPA)[0-9A-Z]{16}\b`),
+	},
+	{
+		Name:  "aws_secret_key",
+		Regex: regexp.MustCompile(`(?i)aws_?secret_?(?:access_?)?key["']?\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})`),
+	},
+	{
+		Name:  "github_token",
+		Regex: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`),
+	},
+	{
+		Name:  "openai_key",
+		Regex: regexp.MustCompile(`\bsk-(?:proj-|or-v1-|ant-)?[A-Za-z0-9_-]{20,}`),
+	},
+	{
+		Name:   "high_entropy",
+		Regex:  regexp.MustCompile(`[A-Za-z0-9+/_=-]{32,}`),
+		Accept: isHighEntropy,
+	},
+}
+
+// New creates a Redactor from the built-in detectors, less any disabled in
+// the configuration, followed by the user supplied patterns.
+func New(cfg config.RedactConfig) (*Redactor, error) {
+	disabled := make(map[string]bool)
+	for _, name := range cfg.DisabledDetectors {
+		disabled[name] = true
+	}
+
+	detectors := make([]Detector, 0, len(builtinDetectors)+len(cfg.Patterns))
+	for _, detector := range builtinDetectors {
+		if !disabled[detector.Name] {
+			detectors = append(detectors, detector)
+		}
+	}
+
+	for _, pattern := range cfg.Patterns {
+		re, err := regexp.Compile(pattern.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %q: %w", pattern.Name, err)
+		}
+
+		name := pattern.Name
+		if name == "" {
+			name = "custom"
+		}
+
+		detectors = append(detectors, Detector{Name: name, Regex: re})
+	}
+
+	return &Redactor{
+		detectors:    detectors,
+		placeholders: make(map[string]string),
+		counters:     make(map[string]int),
+	}, nil
+}
+
+// Redact replaces every secret found in text with a placeholder. The
+// source names where the text came from and is only used for reporting.
+//
+// When a detector's expression has a capture group only the first group is
+// redacted, which lets a pattern match on context such as a key name
+// without hiding it.
+func (r *Redactor) Redact(source string, text string) (string, []Finding) {
+	findings := make([]Finding, 0)
+
+	for _, detector := range r.detectors {
+		count := 0
+		text = replaceMatches(detector, text, func(secret string) string {
+			count++
+			return r.placeholder(detector.Name, secret)
+		})
+
+		if count > 0 {
+			findings = append(findings, Finding{
+				Detector: detector.Name,
+				Source:   source,
+				Count:    count,
+			})
+		}
+	}
+
+	return text, findings
+}
+
+// placeholder returns the stable placeholder for a secret.
+func (r *Redactor) placeholder(detectorName string, secret string) string {
+	if existing, ok := r.placeholders[secret]; ok {
+		return existing
+	}
+
+	r.counters[detectorName]++
+	placeholder := fmt.Sprintf("[REDACTED:%s#%d]", detectorName, r.counters[detectorName])
+	r.placeholders[secret] = placeholder
+
+	return placeholder
+}
+
+// Restore puts the secrets back in place of the placeholders this Redactor
+// replaced them with, for output the model wrote from redacted text that is
+// to replace the original, such as a refactored selection.
+func (r *Redactor) Restore(text string) string {
+	if len(r.placeholders) == 0 {
+		return text
+	}
+
+	oldnew := make([]string, 0, 2*len(r.placeholders))
+	for secret, placeholder := range r.placeholders {
+		oldnew = append(oldnew, placeholder, secret)
+	}
+
+	return strings.NewReplacer(oldnew...).Replace(text)
+}
+
+// replaceMatches calls replace for every accepted match of the detector,
+// substituting its result into text.
+func replaceMatches(detector Detector, text string, replace func(string) string) string {
+	matches := detector.Regex.FindAllStringSubmatchIndex(text, -1)
+	if len(matches) == 0 {
+		return text
+	}
+
+	var sb strings.Builder
+	last := 0
+
+	for _, match := range matches {
+		start, end := match[0], match[1]
+		if len(match) >= 4 && match[2] >= 0 {
+			start, end = match[2], match[3]
+		}
+
+		// Skip text that was already redacted by an earlier detector
+		if start < last || strings.HasPrefix(text[start:], "[REDACTED:") {
+			continue
+		}
+
+		secret := text[start:end]
+		if detector.Accept != nil && !detector.Accept(secret) {
+			continue
+		}
+
+		sb.WriteString(text[last:start])
+		sb.WriteString(replace(secret))
+		last = end
+	}
+
+	sb.WriteString(text[last:])
+
+	return sb.String()
+}
+
+// isHighEntropy reports whether s looks like a random token rather than an
+// identifier or a word: it must mix letters and digits and have a high
+// Shannon entropy per character.
+func isHighEntropy(s string) bool {
+	hasDigit := strings.ContainsAny(s, "0123456789")
+	hasLower := strings.ContainsAny(s, "abcdefghijklmnopqrstuvwxyz")
+	hasUpper := strings.ContainsAny(s, "ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	if !hasDigit || !(hasLower || hasUpper) {
+		return false
+	}
+
+	return shannonEntropy(s) >= highEntropyMinimum
+}
+
+func shannonEntropy(s string) float64 {
+	frequencies := make(map[rune]float64)
+	for _, r := range s {
+		frequencies[r]++
+	}
+
+	length := float64(len(s))
+	entropy := 0.0
+	for _, count := range frequencies {
+		p := count / length
+		entropy -= p * math.Log2(p)
+	}
+
+	return entropy
+}
+
+// Summarize merges findings by source and detector into a stable, human
+// readable list of lines.
+func Summarize(findings []Finding) []string {
+	totals := make(map[string]map[string]int)
+	for _, finding := range findings {
+		if totals[finding.Source] == nil {
+			totals[finding.Source] = make(map[string]int)
+		}
+		totals[finding.Source][finding.Detector] += finding.Count
+	}
+
+	lines := make([]string, 0, len(totals))
+	for source, detectors := range totals {
+		parts := make([]string, 0, len(detectors))
+		for detector, count := range detectors {
+			parts = append(parts, fmt.Sprintf("%d %s", count, detector))
+		}
+		sort.Strings(parts)
+
+		lines = append(lines, fmt.Sprintf("%s: %s", source, strings.Join(parts, ", ")))
+	}
+	sort.Strings(lines)
+
+	return lines
+}
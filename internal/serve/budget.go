@@ -0,0 +1,76 @@
+package serve
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrOverBudget is the error of a request refused because the budget of its
+// model is spent.
+var ErrOverBudget = errors.New("over budget")
+
+// Limit caps the requests sent to a model. A cap of 0 is no cap.
+type Limit struct {
+	PerHour int
+	PerDay  int
+}
+
+// Budgets counts the requests sent to each model against its limit. The
+// counts are kept for the life of the server only.
+type Budgets struct {
+	limits map[string]Limit
+
+	// now returns the current time, replaced by tests
+	now func() time.Time
+
+	mu   sync.Mutex
+	sent map[string][]time.Time
+}
+
+// NewBudgets returns budgets with the limits of each model, by the name it
+// is configured under. Models without a limit are not capped.
+func NewBudgets(limits map[string]Limit) *Budgets {
+	return &Budgets{limits: limits, now: time.Now, sent: make(map[string][]time.Time)}
+}
+
+// Admit counts a request to a model, or refuses it with an error wrapping
+// ErrOverBudget when the model's budget for the last hour or day is spent.
+func (b *Budgets) Admit(model string) error {
+	limit, ok := b.limits[model]
+	if !ok {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+
+	// Requests older than a day no longer count against either cap
+	sent := b.sent[model]
+	for len(sent) > 0 && now.Sub(sent[0]) >= 24*time.Hour {
+		sent = sent[1:]
+	}
+
+	lastHour := 0
+	for _, at := range sent {
+		if now.Sub(at) < time.Hour {
+			lastHour++
+		}
+	}
+
+	if limit.PerHour > 0 && lastHour >= limit.PerHour {
+		b.sent[model] = sent
+		return fmt.Errorf("%w: %s allows %d requests an hour", ErrOverBudget, model, limit.PerHour)
+	}
+	if limit.PerDay > 0 && len(sent) >= limit.PerDay {
+		b.sent[model] = sent
+		return fmt.Errorf("%w: %s allows %d requests a day", ErrOverBudget, model, limit.PerDay)
+	}
+
+	b.sent[model] = append(sent, now)
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package serve
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBudgets(t *testing.T) {
+	now := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+
+	budgets := NewBudgets(map[string]Limit{
+		"hourly": {PerHour: 2},
+		"daily":  {PerHour: 5, PerDay: 3},
+	})
+	budgets.now = func() time.Time { return now }
+
+	admit := func(model string, want bool) {
+		t.Helper()
+		err := budgets.Admit(model)
+		if want && err != nil {
+			t.Errorf("Admit(%s) at %s error = %v, want it admitted", model, now.Format("15:04"), err)
+		}
+		if !want && !errors.Is(err, ErrOverBudget) {
+			t.Errorf("Admit(%s) at %s error = %v, want over budget", model, now.Format("15:04"), err)
+		}
+	}
+
+	admit("hourly", true)
+	admit("hourly", true)
+	admit("hourly", false)
+	admit("uncapped", true)
+
+	// Refused requests do not count, so the hour frees both
+	now = now.Add(time.Hour)
+	admit("hourly", true)
+	admit("hourly", true)
+	admit("hourly", false)
+
+	admit("daily", true)
+	now = now.Add(2 * time.Hour)
+	admit("daily", true)
+	admit("daily", true)
+	admit("daily", false)
+
+	now = now.Add(22 * time.Hour)
+	admit("daily", true)
+	admit("daily", false)
+}
@@ -0,0 +1,361 @@
+package serve
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jcowgar/acme-utils/internal/llm"
+)
+
+// maxRequestBytes caps the body of a chat completion request
+const maxRequestBytes = 10 * 1024 * 1024
+
+// HTTP answers local tools over an API compatible with OpenAI chat
+// completions:
+//
+//	GET /v1/models
+//	POST /v1/chat/completions, streamed as server-sent events with stream
+//
+// Tool calling is not offered.
+//
+// As the API spends the keys of the configured providers, requests from web
+// pages, whose Origin is not on this machine, are refused. Without a Token,
+// so are requests naming a host other than localhost, which a page may send
+// by rebinding its domain to 127.0.0.1.
+type HTTP struct {
+	// Token, when set, is the bearer token every request must send in its
+	// Authorization header.
+	Token string
+
+	backend *Backend
+	mux     *http.ServeMux
+}
+
+// NewHTTP returns a handler answering with backend.
+func NewHTTP(backend *Backend) *HTTP {
+	h := &HTTP{backend: backend, mux: http.NewServeMux()}
+	h.mux.HandleFunc("/v1/models", h.models)
+	h.mux.HandleFunc("/v1/chat/completions", h.chatCompletions)
+
+	return h
+}
+
+func (h *HTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || !IsLoopback(u.Hostname()) {
+			writeError(w, http.StatusForbidden, "", "requests from %s are not allowed", origin)
+			return
+		}
+	}
+
+	if h.Token == "" {
+		host := r.Host
+		if name, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = name
+		}
+		if !IsLoopback(host) {
+			writeError(w, http.StatusForbidden, "", "requests for host %s are not allowed, use localhost", r.Host)
+			return
+		}
+	} else {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.Token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid_api_key", "a valid bearer token is required")
+			return
+		}
+	}
+
+	h.mux.ServeHTTP(w, r)
+}
+
+// IsLoopback reports whether a host name or address is on this machine only.
+func IsLoopback(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// apiError is the body of an error response, and the event ending a stream
+// that failed.
+type apiError struct {
+	Error struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+		Code    string `json:"code,omitempty"`
+	} `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, status int, code string, format string, args ...interface{}) {
+	var body apiError
+	body.Error.Message = fmt.Sprintf(format, args...)
+	body.Error.Type = "invalid_request_error"
+	if status >= http.StatusInternalServerError {
+		body.Error.Type = "api_error"
+	}
+	body.Error.Code = code
+
+	writeJSON(w, status, body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+type model struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+func (h *HTTP) models(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "", "use GET for %s", r.URL.Path)
+		return
+	}
+
+	models := make([]model, 0)
+	if h.backend.Models != nil {
+		for _, name := range h.backend.Models() {
+			models = append(models, model{ID: name, Object: "model", OwnedBy: "ai-stdio"})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"object": "list", "data": models})
+}
+
+// completionRequest is the part of a chat completion request that is
+// understood.
+type completionRequest struct {
+	Model    string              `json:"model"`
+	Messages []completionMessage `json:"messages"`
+	Stream   bool                `json:"stream"`
+	Tools    json.RawMessage     `json:"tools"`
+}
+
+type completionMessage struct {
+	Role string `json:"role"`
+
+	// Content is a string or an array of content parts
+	Content json.RawMessage `json:"content"`
+}
+
+// text returns the content of a message, joining the text of its parts.
+func (m completionMessage) text() (string, error) {
+	if len(m.Content) == 0 || string(m.Content) == "null" {
+		return "", nil
+	}
+
+	var text string
+	if err := json.Unmarshal(m.Content, &text); err == nil {
+		return text, nil
+	}
+
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(m.Content, &parts); err != nil {
+		return "", fmt.Errorf("content is neither text nor an array of parts")
+	}
+
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Type != "text" {
+			return "", fmt.Errorf("%s content is not supported", part.Type)
+		}
+		texts = append(texts, part.Text)
+	}
+
+	return strings.Join(texts, "\n"), nil
+}
+
+// completionChoice is the choice of a completion, or of a chunk of one when
+// streamed.
+type completionChoice struct {
+	Index        int              `json:"index"`
+	Message      *completionDelta `json:"message,omitempty"`
+	Delta        *completionDelta `json:"delta,omitempty"`
+	FinishReason *string          `json:"finish_reason"`
+}
+
+type completionDelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content"`
+}
+
+type completion struct {
+	ID      string             `json:"id"`
+	Object  string             `json:"object"`
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []completionChoice `json:"choices"`
+}
+
+func (h *HTTP) chatCompletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "", "use POST for %s", r.URL.Path)
+		return
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, "", "send the request as application/json")
+		return
+	}
+
+	var req completionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "", "invalid request: %v", err)
+		return
+	}
+
+	if len(req.Tools) > 0 && string(req.Tools) != "null" && string(req.Tools) != "[]" {
+		writeError(w, http.StatusBadRequest, "", "tools are not supported")
+		return
+	}
+
+	messages, err := providerMessages(req.Messages)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "", "%v", err)
+		return
+	}
+
+	provider, name, err := h.backend.provider(req.Model)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "model_not_found", "%v", err)
+		return
+	}
+
+	if err := h.backend.admit(name); err != nil {
+		writeError(w, http.StatusTooManyRequests, "rate_limit_exceeded", "%v", err)
+		return
+	}
+
+	messages = h.backend.redactMessages(messages)
+
+	result := completion{
+		ID:      completionID(),
+		Created: time.Now().Unix(),
+		Model:   name,
+	}
+	stop := "stop"
+
+	if !req.Stream {
+		reply, err := provider.Chat(r.Context(), messages)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, "", "failed to get response from provider: %v", err)
+			return
+		}
+
+		result.Object = "chat.completion"
+		result.Choices = []completionChoice{{Message: &completionDelta{Role: "assistant", Content: reply}, FinishReason: &stop}}
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "", "streaming is not supported by this connection")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	result.Object = "chat.completion.chunk"
+	send := func(event interface{}) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	result.Choices = []completionChoice{{Delta: &completionDelta{Role: "assistant"}}}
+	if err := send(result); err != nil {
+		return
+	}
+
+	_, err = provider.ChatStream(r.Context(), messages, func(chunk string) error {
+		if chunk == "" {
+			return nil
+		}
+		result.Choices = []completionChoice{{Delta: &completionDelta{Content: chunk}}}
+		return send(result)
+	})
+	if err != nil {
+		// The status is already sent, so the failure ends the stream instead
+		if r.Context().Err() == nil {
+			var body apiError
+			body.Error.Message = fmt.Sprintf("failed to get response from provider: %v", err)
+			body.Error.Type = "api_error"
+			send(body)
+		}
+		return
+	}
+
+	result.Choices = []completionChoice{{Delta: &completionDelta{}, FinishReason: &stop}}
+	if err := send(result); err != nil {
+		return
+	}
+
+	fmt.Fprintf(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
+
+// providerMessages converts the messages of a request, which may only be
+// system, user and assistant messages.
+func providerMessages(messages []completionMessage) ([]llm.Message, error) {
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("messages are required")
+	}
+
+	converted := make([]llm.Message, 0, len(messages))
+	for i, msg := range messages {
+		role := msg.Role
+		switch role {
+		case "developer":
+			role = "system"
+		case "system", "user", "assistant":
+		default:
+			return nil, fmt.Errorf("messages[%d]: %s messages are not supported", i, msg.Role)
+		}
+
+		text, err := msg.text()
+		if err != nil {
+			return nil, fmt.Errorf("messages[%d]: %w", i, err)
+		}
+
+		converted = append(converted, llm.Message{Role: role, Content: text})
+	}
+
+	return converted, nil
+}
+
+// completionID returns a new identifier for a completion.
+func completionID() string {
+	var b [12]byte
+	rand.Read(b[:])
+
+	return "chatcmpl-" + hex.EncodeToString(b[:])
+}
@@ -0,0 +1,259 @@
+package serve
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPModels(t *testing.T) {
+	server := httptest.NewServer(NewHTTP(newTestBackend(&fakeProvider{})))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/v1/models")
+	if err != nil {
+		t.Fatalf("GET /v1/models error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Object string `json:"object"`
+		Data   []struct {
+			ID     string `json:"id"`
+			Object string `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("could not decode models: %v", err)
+	}
+
+	if body.Object != "list" || len(body.Data) != 2 || body.Data[0].ID != "f" || body.Data[1].Object != "model" {
+		t.Errorf("GET /v1/models = %+v, want f and fake listed", body)
+	}
+}
+
+func TestHTTPChatCompletions(t *testing.T) {
+	server := httptest.NewServer(NewHTTP(newTestBackend(&fakeProvider{})))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		want       string
+	}{
+		{
+			name:       "default model",
+			body:       `{"messages":[{"role":"user","content":"hello"}]}`,
+			wantStatus: http.StatusOK,
+			want:       "user:hello",
+		},
+		{
+			name:       "alias, parts and redaction",
+			body:       `{"model":"f","messages":[{"role":"developer","content":"be brief"},{"role":"user","content":[{"type":"text","text":"hunter2"}]}]}`,
+			wantStatus: http.StatusOK,
+			want:       "system:be brief|user:[REDACTED]",
+		},
+		{
+			name:       "unknown model",
+			body:       `{"model":"missing","messages":[{"role":"user","content":"hello"}]}`,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "tool messages",
+			body:       `{"messages":[{"role":"tool","content":"42"}]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "no messages",
+			body:       `{"model":"f"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "provider failure",
+			body:       `{"messages":[{"role":"user","content":"fail"}]}`,
+			wantStatus: http.StatusBadGateway,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(server.URL+"/v1/chat/completions", "application/json", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("POST error = %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("POST status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+
+			var body struct {
+				Object  string `json:"object"`
+				Model   string `json:"model"`
+				Choices []struct {
+					Message struct {
+						Role    string `json:"role"`
+						Content string `json:"content"`
+					} `json:"message"`
+					FinishReason string `json:"finish_reason"`
+				} `json:"choices"`
+				Error struct {
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("could not decode response: %v", err)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				if body.Error.Message == "" {
+					t.Errorf("POST = %+v, want an error message", body)
+				}
+				return
+			}
+
+			if body.Object != "chat.completion" || body.Model != "fake" || len(body.Choices) != 1 {
+				t.Fatalf("POST = %+v, want one choice from fake", body)
+			}
+			if got := body.Choices[0].Message; got.Role != "assistant" || got.Content != tt.want {
+				t.Errorf("POST message = %+v, want assistant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPChatCompletionsStream(t *testing.T) {
+	server := httptest.NewServer(NewHTTP(newTestBackend(&fakeProvider{})))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/v1/chat/completions", "application/json", strings.NewReader(`{"model":"fake","stream":true,"messages":[{"role":"user","content":"hello"}]}`))
+	if err != nil {
+		t.Fatalf("POST error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", got)
+	}
+
+	var content strings.Builder
+	role, finish, done := "", "", false
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			done = true
+			continue
+		}
+
+		var chunk struct {
+			Object  string `json:"object"`
+			Choices []struct {
+				Delta struct {
+					Role    string `json:"role"`
+					Content string `json:"content"`
+				} `json:"delta"`
+				FinishReason *string `json:"finish_reason"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil || chunk.Object != "chat.completion.chunk" || len(chunk.Choices) != 1 {
+			t.Fatalf("event %s is not a completion chunk: %v", data, err)
+		}
+
+		if chunk.Choices[0].Delta.Role != "" {
+			role = chunk.Choices[0].Delta.Role
+		}
+		content.WriteString(chunk.Choices[0].Delta.Content)
+		if chunk.Choices[0].FinishReason != nil {
+			finish = *chunk.Choices[0].FinishReason
+		}
+	}
+
+	if role != "assistant" || content.String() != "user:hello" || finish != "stop" || !done {
+		t.Errorf("stream = role %q, content %q, finish %q, done %v, want assistant user:hello stop and [DONE]", role, content.String(), finish, done)
+	}
+}
+
+func TestHTTPGuard(t *testing.T) {
+	const body = `{"messages":[{"role":"user","content":"hello"}]}`
+
+	tests := []struct {
+		name        string
+		token       string
+		host        string
+		header      map[string]string
+		contentType string
+		wantStatus  int
+	}{
+		{name: "local", wantStatus: http.StatusOK},
+		{name: "localhost", host: "localhost:8080", wantStatus: http.StatusOK},
+		{name: "local page", header: map[string]string{"Origin": "http://127.0.0.1:3000"}, wantStatus: http.StatusOK},
+		{name: "json with charset", contentType: "application/json; charset=utf-8", wantStatus: http.StatusOK},
+		{name: "form", contentType: "application/x-www-form-urlencoded", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "plain text", contentType: "text/plain", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "foreign page", header: map[string]string{"Origin": "https://evil.example"}, wantStatus: http.StatusForbidden},
+		{name: "null origin", header: map[string]string{"Origin": "null"}, wantStatus: http.StatusForbidden},
+		{name: "rebound host", host: "evil.example:8080", wantStatus: http.StatusForbidden},
+		{name: "no token", token: "s3cret", wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", token: "s3cret", header: map[string]string{"Authorization": "Bearer guess"}, wantStatus: http.StatusUnauthorized},
+		{name: "token", token: "s3cret", header: map[string]string{"Authorization": "Bearer s3cret"}, wantStatus: http.StatusOK},
+		{name: "token from another host", token: "s3cret", host: "devbox:8080", header: map[string]string{"Authorization": "Bearer s3cret"}, wantStatus: http.StatusOK},
+		{name: "token from a foreign page", token: "s3cret", header: map[string]string{"Authorization": "Bearer s3cret", "Origin": "https://evil.example"}, wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewHTTP(newTestBackend(&fakeProvider{}))
+			handler.Token = tt.token
+
+			req := httptest.NewRequest(http.MethodPost, "http://127.0.0.1:8080/v1/chat/completions", strings.NewReader(body))
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+			contentType := tt.contentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			req.Header.Set("Content-Type", contentType)
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("POST status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
+
+func TestHTTPBudget(t *testing.T) {
+	backend := newTestBackend(&fakeProvider{})
+	backend.Admit = NewBudgets(map[string]Limit{"fake": {PerHour: 1}}).Admit
+
+	server := httptest.NewServer(NewHTTP(backend))
+	defer server.Close()
+
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		// The budget is counted by the configured name, whatever the alias
+		resp, err := http.Post(server.URL+"/v1/chat/completions", "application/json", strings.NewReader(`{"model":"f","messages":[{"role":"user","content":"hello"}]}`))
+		if err != nil {
+			t.Fatalf("POST error = %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != want {
+			t.Errorf("POST %d status = %d, want %d", i+1, resp.StatusCode, want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package serve
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/jcowgar/acme-utils/internal/jsonrpc"
+	"github.com/jcowgar/acme-utils/internal/llm"
+)
+
+// ChunkNotification is sent with each piece of a response as it arrives.
+const ChunkNotification = "chat/chunk"
+
+// CodeCancelled is the error code of a send stopped by cancel, as in LSP.
+const CodeCancelled = -32800
+
+// RPC answers editor plugins over JSON-RPC, keeping their chats between
+// requests. It offers:
+//
+//	newChat {model?, system?} -> {chatId, model}
+//	send {chatId, content} -> {chatId, content}, with chat/chunk
+//	    {chatId, text} notifications as the response arrives
+//	cancel {chatId} -> {cancelled}
+//	closeChat {chatId} -> {}
+//	listModels -> {models, default}
+type RPC struct {
+	backend *Backend
+
+	mu     sync.Mutex
+	nextID int
+	chats  map[string]*chat
+}
+
+// chat is a conversation kept by the server, unredacted.
+type chat struct {
+	id       string
+	model    string
+	provider llm.Provider
+	messages []llm.Message
+
+	// cancel stops the send in progress, nil when there is none
+	cancel context.CancelFunc
+}
+
+// NewRPC returns a server answering with backend.
+func NewRPC(backend *Backend) *RPC {
+	return &RPC{backend: backend, chats: make(map[string]*chat)}
+}
+
+// Serve answers the client over stream until it hangs up.
+func (s *RPC) Serve(stream jsonrpc.Stream) error {
+	return jsonrpc.NewConn(stream, s.handle).Wait()
+}
+
+type chatParams struct {
+	ChatID string `json:"chatId"`
+}
+
+func (s *RPC) handle(ctx context.Context, conn *jsonrpc.Conn, req *jsonrpc.Request) (interface{}, error) {
+	switch req.Method {
+	case "newChat":
+		return s.newChat(req)
+	case "send":
+		return s.send(ctx, conn, req)
+	case "cancel":
+		var params chatParams
+		if err := req.DecodeParams(&params); err != nil {
+			return nil, err
+		}
+		c, err := s.chat(params.ChatID)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]bool{"cancelled": s.cancel(c)}, nil
+	case "closeChat":
+		var params chatParams
+		if err := req.DecodeParams(&params); err != nil {
+			return nil, err
+		}
+		c, err := s.chat(params.ChatID)
+		if err != nil {
+			return nil, err
+		}
+		s.cancel(c)
+		s.mu.Lock()
+		delete(s.chats, c.id)
+		s.mu.Unlock()
+		return struct{}{}, nil
+	case "listModels":
+		models := make([]string, 0)
+		if s.backend.Models != nil {
+			models = append(models, s.backend.Models()...)
+		}
+		return map[string]interface{}{"models": models, "default": s.backend.DefaultModel}, nil
+	}
+
+	return nil, jsonrpc.MethodNotFound(req.Method)
+}
+
+func (s *RPC) newChat(req *jsonrpc.Request) (interface{}, error) {
+	var params struct {
+		Model  string `json:"model"`
+		System string `json:"system"`
+	}
+	if err := req.DecodeParams(&params); err != nil {
+		return nil, err
+	}
+
+	provider, model, err := s.backend.provider(params.Model)
+	if err != nil {
+		return nil, &jsonrpc.Error{Code: jsonrpc.CodeInvalidParams, Message: err.Error()}
+	}
+
+	c := &chat{model: model, provider: provider, messages: make([]llm.Message, 0)}
+	if params.System != "" {
+		c.messages = append(c.messages, llm.Message{Role: "system", Content: params.System})
+	}
+
+	s.mu.Lock()
+	s.nextID++
+	c.id = strconv.Itoa(s.nextID)
+	s.chats[c.id] = c
+	s.mu.Unlock()
+
+	return map[string]string{"chatId": c.id, "model": model}, nil
+}
+
+// send sends a message of a chat, streaming the response to the client. The
+// turn is only kept in the chat once the response is complete.
+func (s *RPC) send(ctx context.Context, conn *jsonrpc.Conn, req *jsonrpc.Request) (interface{}, error) {
+	var params struct {
+		ChatID  string `json:"chatId"`
+		Content string `json:"content"`
+	}
+	if err := req.DecodeParams(&params); err != nil {
+		return nil, err
+	}
+
+	c, err := s.chat(params.ChatID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s.mu.Lock()
+	if c.cancel != nil {
+		s.mu.Unlock()
+		return nil, &jsonrpc.Error{Code: jsonrpc.CodeInvalidRequest, Message: fmt.Sprintf("chat %s is already sending", c.id)}
+	}
+	c.cancel = cancel
+	messages := append(c.messages[:len(c.messages):len(c.messages)], llm.Message{Role: "user", Content: params.Content})
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		c.cancel = nil
+		s.mu.Unlock()
+	}()
+
+	if err := s.backend.admit(c.model); err != nil {
+		return nil, err
+	}
+
+	reply, err := c.provider.ChatStream(ctx, s.backend.redactMessages(messages), func(chunk string) error {
+		if chunk == "" {
+			return nil
+		}
+		return conn.Notify(ChunkNotification, map[string]string{"chatId": c.id, "text": chunk})
+	})
+	if ctx.Err() != nil {
+		return nil, &jsonrpc.Error{Code: CodeCancelled, Message: "cancelled"}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get response from provider: %w", err)
+	}
+
+	s.mu.Lock()
+	c.messages = append(messages, llm.Message{Role: "assistant", Content: reply})
+	s.mu.Unlock()
+
+	return map[string]string{"chatId": c.id, "content": reply}, nil
+}
+
+// cancel stops the send in progress of a chat, reporting whether there was
+// one.
+func (s *RPC) cancel(c *chat) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c.cancel == nil {
+		return false
+	}
+
+	c.cancel()
+
+	return true
+}
+
+func (s *RPC) chat(id string) (*chat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.chats[id]
+	if !ok {
+		return nil, &jsonrpc.Error{Code: jsonrpc.CodeInvalidParams, Message: "unknown chat: " + id}
+	}
+
+	return c, nil
+}
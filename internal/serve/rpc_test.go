@@ -0,0 +1,293 @@
+package serve
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jcowgar/acme-utils/internal/jsonrpc"
+	"github.com/jcowgar/acme-utils/internal/llm"
+)
+
+// fakeProvider replies with the messages it was sent, in two chunks, and
+// blocks on a message of "wait" until cancelled.
+type fakeProvider struct {
+	mu   sync.Mutex
+	sent [][]llm.Message
+
+	// waiting is closed once a send blocks on "wait"
+	waiting chan struct{}
+}
+
+func (p *fakeProvider) reply(messages []llm.Message) string {
+	parts := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		parts = append(parts, msg.Role+":"+msg.Content)
+	}
+	return strings.Join(parts, "|")
+}
+
+func (p *fakeProvider) Chat(ctx context.Context, messages []llm.Message) (string, error) {
+	return p.ChatStream(ctx, messages, func(string) error { return nil })
+}
+
+func (p *fakeProvider) ChatStream(ctx context.Context, messages []llm.Message, onChunk func(chunk string) error) (string, error) {
+	p.mu.Lock()
+	p.sent = append(p.sent, messages)
+	p.mu.Unlock()
+
+	last := messages[len(messages)-1].Content
+	if last == "wait" {
+		close(p.waiting)
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+	if last == "fail" {
+		return "", errors.New("model unavailable")
+	}
+
+	reply := p.reply(messages)
+	half := len(reply) / 2
+	for _, chunk := range []string{reply[:half], reply[half:]} {
+		if err := onChunk(chunk); err != nil {
+			return "", err
+		}
+	}
+
+	return reply, nil
+}
+
+func (p *fakeProvider) ChatTools(ctx context.Context, messages []llm.Message, tools []llm.Tool) (llm.Message, error) {
+	return llm.Message{}, errors.New("not supported")
+}
+
+func (p *fakeProvider) Name() string {
+	return "fake"
+}
+
+func newTestBackend(provider *fakeProvider) *Backend {
+	return &Backend{
+		Models: func() []string {
+			return []string{"f", "fake"}
+		},
+		DefaultModel: "fake",
+		Provider: func(model string) (llm.Provider, string, error) {
+			switch model {
+			case "fake", "f":
+				return provider, "fake", nil
+			}
+			return nil, "", fmt.Errorf("unknown model: %s", model)
+		},
+		Redact: func(source string, text string) string {
+			return strings.ReplaceAll(text, "hunter2", "[REDACTED]")
+		},
+	}
+}
+
+// rpcClient connects to a new server through pipes, collecting the chunks
+// it streams.
+type rpcClient struct {
+	*jsonrpc.Conn
+
+	mu     sync.Mutex
+	chunks map[string]string
+}
+
+func newRPCClient(t *testing.T, backend *Backend) *rpcClient {
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+	go NewRPC(backend).Serve(jsonrpc.NewLineStream(serverReader, serverWriter))
+
+	client := &rpcClient{chunks: make(map[string]string)}
+	client.Conn = jsonrpc.NewConn(jsonrpc.NewLineStream(clientReader, clientWriter), func(ctx context.Context, conn *jsonrpc.Conn, req *jsonrpc.Request) (interface{}, error) {
+		if req.Method != ChunkNotification {
+			return nil, jsonrpc.MethodNotFound(req.Method)
+		}
+
+		var params struct {
+			ChatID string `json:"chatId"`
+			Text   string `json:"text"`
+		}
+		if err := req.DecodeParams(&params); err != nil {
+			return nil, err
+		}
+
+		client.mu.Lock()
+		client.chunks[params.ChatID] += params.Text
+		client.mu.Unlock()
+		return nil, nil
+	})
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+func (c *rpcClient) streamed(chatID string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	text := c.chunks[chatID]
+	delete(c.chunks, chatID)
+	return text
+}
+
+func TestRPCChat(t *testing.T) {
+	provider := &fakeProvider{}
+	client := newRPCClient(t, newTestBackend(provider))
+	ctx := context.Background()
+
+	var models struct {
+		Models  []string `json:"models"`
+		Default string   `json:"default"`
+	}
+	if err := client.Call(ctx, "listModels", nil, &models); err != nil || len(models.Models) != 2 || models.Default != "fake" {
+		t.Errorf("listModels = %+v, %v, want the two models and fake as default", models, err)
+	}
+
+	var created struct {
+		ChatID string `json:"chatId"`
+		Model  string `json:"model"`
+	}
+	if err := client.Call(ctx, "newChat", map[string]string{"model": "f", "system": "be brief"}, &created); err != nil {
+		t.Fatalf("newChat error = %v", err)
+	}
+	if created.ChatID == "" || created.Model != "fake" {
+		t.Errorf("newChat = %+v, want an id and model fake", created)
+	}
+
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"hello", "system:be brief|user:hello"},
+		{"my password is hunter2", "system:be brief|user:hello|assistant:system:be brief|user:hello|user:my password is [REDACTED]"},
+	}
+
+	for _, tt := range tests {
+		var sent struct {
+			Content string `json:"content"`
+		}
+		if err := client.Call(ctx, "send", map[string]string{"chatId": created.ChatID, "content": tt.content}, &sent); err != nil {
+			t.Fatalf("send(%q) error = %v", tt.content, err)
+		}
+		if sent.Content != tt.want {
+			t.Errorf("send(%q) = %q, want %q", tt.content, sent.Content, tt.want)
+		}
+
+		// Notifications are handled before the response that follows them
+		if got := client.streamed(created.ChatID); got != tt.want {
+			t.Errorf("send(%q) streamed %q, want %q", tt.content, got, tt.want)
+		}
+	}
+
+	// A failed turn is not kept
+	if err := client.Call(ctx, "send", map[string]string{"chatId": created.ChatID, "content": "fail"}, nil); err == nil || !strings.Contains(err.Error(), "model unavailable") {
+		t.Errorf("send(fail) error = %v, want model unavailable", err)
+	}
+	if err := client.Call(ctx, "send", map[string]string{"chatId": created.ChatID, "content": "again"}, nil); err != nil {
+		t.Fatalf("send(again) error = %v", err)
+	}
+	if got := provider.sent[len(provider.sent)-1]; len(got) != 6 || got[4].Role != "assistant" {
+		t.Errorf("send(again) sent %+v, want the failed turn left out", got)
+	}
+
+	var rpcErr *jsonrpc.Error
+	if err := client.Call(ctx, "newChat", map[string]string{"model": "missing"}, nil); !errors.As(err, &rpcErr) || rpcErr.Code != jsonrpc.CodeInvalidParams {
+		t.Errorf("newChat(missing) error = %v, want invalid params", err)
+	}
+
+	if err := client.Call(ctx, "closeChat", map[string]string{"chatId": created.ChatID}, nil); err != nil {
+		t.Errorf("closeChat error = %v", err)
+	}
+	if err := client.Call(ctx, "send", map[string]string{"chatId": created.ChatID, "content": "hello"}, nil); !errors.As(err, &rpcErr) || rpcErr.Code != jsonrpc.CodeInvalidParams {
+		t.Errorf("send after closeChat error = %v, want invalid params", err)
+	}
+}
+
+func TestRPCBudget(t *testing.T) {
+	provider := &fakeProvider{}
+	backend := newTestBackend(provider)
+	backend.Admit = NewBudgets(map[string]Limit{"fake": {PerDay: 1}}).Admit
+
+	client := newRPCClient(t, backend)
+	ctx := context.Background()
+
+	var created struct {
+		ChatID string `json:"chatId"`
+	}
+	if err := client.Call(ctx, "newChat", map[string]string{"model": "f"}, &created); err != nil {
+		t.Fatalf("newChat error = %v", err)
+	}
+
+	if err := client.Call(ctx, "send", map[string]string{"chatId": created.ChatID, "content": "hello"}, nil); err != nil {
+		t.Fatalf("send error = %v", err)
+	}
+	if err := client.Call(ctx, "send", map[string]string{"chatId": created.ChatID, "content": "again"}, nil); err == nil || !strings.Contains(err.Error(), "over budget") {
+		t.Errorf("send over budget error = %v, want over budget", err)
+	}
+	if len(provider.sent) != 1 {
+		t.Errorf("provider was sent %d requests, want 1", len(provider.sent))
+	}
+}
+
+func TestRPCCancel(t *testing.T) {
+	provider := &fakeProvider{waiting: make(chan struct{})}
+	client := newRPCClient(t, newTestBackend(provider))
+	ctx := context.Background()
+
+	var created struct {
+		ChatID string `json:"chatId"`
+	}
+	if err := client.Call(ctx, "newChat", nil, &created); err != nil {
+		t.Fatalf("newChat error = %v", err)
+	}
+	params := map[string]string{"chatId": created.ChatID}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Call(ctx, "send", map[string]string{"chatId": created.ChatID, "content": "wait"}, nil)
+	}()
+
+	select {
+	case <-provider.waiting:
+	case <-time.After(5 * time.Second):
+		t.Fatal("send did not reach the provider")
+	}
+
+	// A chat sends one message at a time
+	if err := client.Call(ctx, "send", map[string]string{"chatId": created.ChatID, "content": "hello"}, nil); err == nil {
+		t.Errorf("second send succeeded, want it refused while the first is sending")
+	}
+
+	var cancelled struct {
+		Cancelled bool `json:"cancelled"`
+	}
+	if err := client.Call(ctx, "cancel", params, &cancelled); err != nil || !cancelled.Cancelled {
+		t.Errorf("cancel = %+v, %v, want cancelled", cancelled, err)
+	}
+
+	var rpcErr *jsonrpc.Error
+	select {
+	case err := <-done:
+		if !errors.As(err, &rpcErr) || rpcErr.Code != CodeCancelled {
+			t.Errorf("cancelled send error = %v, want code %d", err, CodeCancelled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("send was not cancelled")
+	}
+
+	if err := client.Call(ctx, "cancel", params, &cancelled); err != nil || cancelled.Cancelled {
+		t.Errorf("cancel when idle = %+v, %v, want nothing cancelled", cancelled, err)
+	}
+
+	var raw json.RawMessage
+	if err := client.Call(ctx, "unknown", nil, &raw); !errors.As(err, &rpcErr) || rpcErr.Code != jsonrpc.CodeMethodNotFound {
+		t.Errorf("unknown method error = %v, want method not found", err)
+	}
+}
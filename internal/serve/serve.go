@@ -0,0 +1,67 @@
+// Package serve answers editors and other local tools from one long-lived
+// process, sending their chats to the configured models.
+package serve
+
+import (
+	"fmt"
+
+	"github.com/jcowgar/acme-utils/internal/llm"
+)
+
+// Backend is what the servers answer with: the configured models and the
+// redaction of what is sent to them.
+type Backend struct {
+	// Models lists the names a model may be asked for by.
+	Models func() []string
+
+	// DefaultModel is the model used when none is asked for.
+	DefaultModel string
+
+	// Provider returns the provider of a model and the name the model is
+	// configured under.
+	Provider func(model string) (llm.Provider, string, error)
+
+	// Redact scrubs secrets from text about to be sent to a model, nil
+	// when nothing is redacted.
+	Redact func(source string, text string) string
+
+	// Admit is asked before each request to a model, by the name it is
+	// configured under, and refuses the request with an error wrapping
+	// ErrOverBudget when the model's budget is spent. Nil admits every
+	// request.
+	Admit func(model string) error
+}
+
+// provider returns the provider of a model, or of the default model for "".
+func (b *Backend) provider(model string) (llm.Provider, string, error) {
+	if model == "" {
+		model = b.DefaultModel
+	}
+
+	return b.Provider(model)
+}
+
+// admit counts a request to a model against its budget.
+func (b *Backend) admit(model string) error {
+	if b.Admit == nil {
+		return nil
+	}
+
+	return b.Admit(model)
+}
+
+// redactMessages returns a copy of the messages with secrets scrubbed.
+func (b *Backend) redactMessages(messages []llm.Message) []llm.Message {
+	redacted := make([]llm.Message, len(messages))
+	copy(redacted, messages)
+
+	if b.Redact == nil {
+		return redacted
+	}
+
+	for i := range redacted {
+		redacted[i].Content = b.Redact(fmt.Sprintf("message %d", i+1), redacted[i].Content)
+	}
+
+	return redacted
+}
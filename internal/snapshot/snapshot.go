@@ -0,0 +1,154 @@
+package snapshot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Store keeps content addressed copies of fetched resources along with a
+// manifest per chat recording which resources each turn was sent with. As
+// resources are stored as fetched, before any redaction, only the user may
+// read them.
+//
+// Layout within the store directory:
+//
+//	objects/<sha256>          resource content
+//	manifests/<sha256>.json   manifest, named by a hash of the chat filename
+type Store struct {
+	Dir string
+}
+
+// Manifest records the resources sent with each turn of a chat.
+type Manifest struct {
+	Chat  string `json:"chat"`
+	Turns []Turn `json:"turns"`
+}
+
+// Turn is a snapshot of the resources fetched for a single message.
+type Turn struct {
+	// Message is the index of the message within the conversation.
+	Message int `json:"message"`
+
+	// Digest is a hash of the message content when the snapshot was taken.
+	// A snapshot is only replayed while the message is unchanged.
+	Digest string `json:"digest"`
+
+	Resources []Entry `json:"resources"`
+}
+
+// Entry describes one snapshotted resource.
+type Entry struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	Hash string `json:"hash"`
+}
+
+// Digest returns the hex encoded SHA-256 of content.
+func Digest(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// Put stores content and returns the hash it can be retrieved by.
+func (s *Store) Put(content string) (string, error) {
+	hash := Digest(content)
+	filename := s.objectFilename(hash)
+
+	if _, err := os.Stat(filename); err == nil {
+		return hash, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return "", fmt.Errorf("could not create snapshot directory: %w", err)
+	}
+
+	if err := os.WriteFile(filename, []byte(content), 0600); err != nil {
+		return "", fmt.Errorf("could not write snapshot: %w", err)
+	}
+
+	return hash, nil
+}
+
+// Get returns the content stored under hash.
+func (s *Store) Get(hash string) (string, error) {
+	data, err := os.ReadFile(s.objectFilename(hash))
+	if err != nil {
+		return "", fmt.Errorf("could not read snapshot %s: %w", hash, err)
+	}
+
+	return string(data), nil
+}
+
+// LoadManifest returns the manifest for a chat, or an empty manifest if the
+// chat has not been snapshotted yet.
+func (s *Store) LoadManifest(chat string) (*Manifest, error) {
+	data, err := os.ReadFile(s.manifestFilename(chat))
+	if errors.Is(err, os.ErrNotExist) {
+		return &Manifest{Chat: chat}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read snapshot manifest: %w", err)
+	}
+
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("could not decode snapshot manifest: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// SaveManifest writes the manifest for its chat.
+func (s *Store) SaveManifest(manifest *Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode snapshot manifest: %w", err)
+	}
+
+	filename := s.manifestFilename(manifest.Chat)
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return fmt.Errorf("could not create snapshot directory: %w", err)
+	}
+
+	if err := os.WriteFile(filename, data, 0600); err != nil {
+		return fmt.Errorf("could not write snapshot manifest: %w", err)
+	}
+
+	return nil
+}
+
+// Turn returns the snapshot for a message if one was taken while the
+// message had the given digest.
+func (m *Manifest) Turn(message int, digest string) (Turn, bool) {
+	for _, turn := range m.Turns {
+		if turn.Message == message && turn.Digest == digest {
+			return turn, true
+		}
+	}
+
+	return Turn{}, false
+}
+
+// Record replaces any snapshot of the turn's message with turn.
+func (m *Manifest) Record(turn Turn) {
+	for i, existing := range m.Turns {
+		if existing.Message == turn.Message {
+			m.Turns[i] = turn
+			return
+		}
+	}
+
+	m.Turns = append(m.Turns, turn)
+}
+
+func (s *Store) objectFilename(hash string) string {
+	return filepath.Join(s.Dir, "objects", hash)
+}
+
+func (s *Store) manifestFilename(chat string) string {
+	return filepath.Join(s.Dir, "manifests", Digest(chat)+".json")
+}
@@ -0,0 +1,82 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStorePutGet(t *testing.T) {
+	store := &Store{Dir: t.TempDir()}
+
+	hash, err := store.Put("package main\n")
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if hash != Digest("package main\n") {
+		t.Errorf("Put() hash = %s, want content digest", hash)
+	}
+
+	got, err := store.Get(hash)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got != "package main\n" {
+		t.Errorf("Get() = %q, want %q", got, "package main\n")
+	}
+
+	if _, err := store.Get(Digest("never stored")); err == nil {
+		t.Error("Get() expected error for unknown hash")
+	}
+
+	info, err := os.Stat(filepath.Join(store.Dir, "objects", hash))
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("snapshot permissions = %v, want -rw-------", perm)
+	}
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	store := &Store{Dir: t.TempDir()}
+
+	manifest, err := store.LoadManifest("/project/.ai-stdio.md")
+	if err != nil {
+		t.Fatalf("LoadManifest() error = %v", err)
+	}
+	if len(manifest.Turns) != 0 {
+		t.Fatalf("LoadManifest() of new chat has %d turns, want 0", len(manifest.Turns))
+	}
+
+	manifest.Record(Turn{Message: 0, Digest: "a", Resources: []Entry{{Type: "file", Name: "old.go", Hash: "1"}}})
+	manifest.Record(Turn{Message: 2, Digest: "b"})
+	manifest.Record(Turn{Message: 0, Digest: "c", Resources: []Entry{{Type: "file", Name: "new.go", Hash: "2"}}})
+
+	if err := store.SaveManifest(manifest); err != nil {
+		t.Fatalf("SaveManifest() error = %v", err)
+	}
+
+	loaded, err := store.LoadManifest("/project/.ai-stdio.md")
+	if err != nil {
+		t.Fatalf("LoadManifest() error = %v", err)
+	}
+
+	if len(loaded.Turns) != 2 {
+		t.Fatalf("loaded manifest has %d turns, want 2", len(loaded.Turns))
+	}
+
+	if _, ok := loaded.Turn(0, "a"); ok {
+		t.Error("Turn() returned a replaced snapshot")
+	}
+
+	turn, ok := loaded.Turn(0, "c")
+	if !ok || len(turn.Resources) != 1 || turn.Resources[0].Name != "new.go" {
+		t.Errorf("Turn(0, c) = %v, %v, want the new.go snapshot", turn, ok)
+	}
+
+	other, err := store.LoadManifest("/other/.ai-stdio.md")
+	if err != nil || len(other.Turns) != 0 {
+		t.Errorf("LoadManifest() of another chat = %v, %v, want empty", other, err)
+	}
+}